@@ -0,0 +1,76 @@
+package bigcache
+
+const (
+	sketchDepth           = 4
+	sketchMaxCounter      = 15
+	sketchMinWidth        = 64
+	sketchBytesPerEntry   = 16
+	sketchResetMultiplier = 10
+)
+
+var sketchSeeds = [sketchDepth]uint64{
+	0xc3a5c85c97cb3127, 0xb492b66fbe98f273, 0x9ae16a3b2f90404f, 0xcbf29ce484222325,
+}
+
+// countMinSketch estimates the access frequencies of key hashes with counters saturated at 15,
+// all counters are halved periodically so that the old popularity fades away
+type countMinSketch struct {
+	counters []uint8
+	width    uint64
+	shift    uint
+
+	additions int
+	resetAt   int
+}
+
+func newCountMinSketch(width int) *countMinSketch {
+	if width < sketchMinWidth {
+		width = sketchMinWidth
+	}
+	width = nextPowerOfTwo(width)
+	_, shift := computeSegmentMask(width)
+
+	return &countMinSketch{
+		counters: make([]uint8, sketchDepth*width),
+		width:    uint64(width),
+		shift:    uint(shift),
+		resetAt:  sketchResetMultiplier * width,
+	}
+}
+
+func (s *countMinSketch) index(hash uint32, row int) uint64 {
+	h := (uint64(hash) + sketchSeeds[row]) * 0x9e3779b97f4a7c15
+	return uint64(row)*s.width + h>>s.shift
+}
+
+func (s *countMinSketch) increase(hash uint32) {
+	for i := 0; i < sketchDepth; i++ {
+		index := s.index(hash, i)
+		if s.counters[index] < sketchMaxCounter {
+			s.counters[index]++
+		}
+	}
+
+	s.additions++
+	if s.additions >= s.resetAt {
+		s.reset()
+	}
+}
+
+func (s *countMinSketch) estimate(hash uint32) uint8 {
+	min := uint8(sketchMaxCounter)
+	for i := 0; i < sketchDepth; i++ {
+		value := s.counters[s.index(hash, i)]
+		if value < min {
+			min = value
+		}
+	}
+	return min
+}
+
+func (s *countMinSketch) reset() {
+	for i := range s.counters {
+		s.counters[i] >>= 1
+	}
+	s.additions /= 2
+}
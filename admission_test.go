@@ -0,0 +1,143 @@
+package bigcache
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/QuangTung97/bigcache/memhash"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestCountMinSketch_Estimate(t *testing.T) {
+	s := newCountMinSketch(100)
+	assert.Equal(t, 128, int(s.width))
+	assert.Equal(t, 4*128, len(s.counters))
+
+	s.increase(10)
+	s.increase(10)
+	s.increase(10)
+	s.increase(20)
+
+	assert.Equal(t, uint8(3), s.estimate(10))
+	assert.Equal(t, uint8(1), s.estimate(20))
+	assert.Equal(t, uint8(0), s.estimate(30))
+}
+
+func TestCountMinSketch_Saturated(t *testing.T) {
+	s := newCountMinSketch(100)
+	for i := 0; i < 20; i++ {
+		s.increase(10)
+	}
+	assert.Equal(t, uint8(15), s.estimate(10))
+}
+
+func TestCountMinSketch_Aging(t *testing.T) {
+	s := newCountMinSketch(64)
+	for i := 0; i < 8; i++ {
+		s.increase(10)
+	}
+	assert.Equal(t, uint8(8), s.estimate(10))
+
+	s.reset()
+	assert.Equal(t, uint8(4), s.estimate(10))
+	assert.Equal(t, 4, s.additions)
+
+	for i := s.additions; i < s.resetAt; i++ {
+		s.increase(uint32(1000 + i))
+	}
+	assert.Equal(t, s.resetAt/2, s.additions)
+}
+
+func newSegmentTinyLFU(bufSize int) *segment {
+	s := &segment{}
+	initSegment(s, bufSize, newCacheOptions(WithTinyLFUAdmission()))
+	s.getNow = monoGetNow(0)
+	return s
+}
+
+func TestSegment_TinyLFU_Reject_Less_Frequent(t *testing.T) {
+	const entrySize = entryHeaderSize + 8
+	s := newSegmentTinyLFU(entrySize * 2)
+
+	data := make([]byte, 100)
+
+	s.put(40, []byte{1, 2, 0}, []byte{101, 102, 103, 100})
+	s.getAndApply(40, []byte{1, 2, 0}, data)
+	s.put(41, []byte{1, 2, 1}, []byte{101, 102, 103, 101})
+
+	s.put(42, []byte{1, 2, 2}, []byte{101, 102, 103, 102})
+	_, ok := s.getAndApply(42, []byte{1, 2, 2}, data)
+	assert.Equal(t, false, ok)
+	_, ok = s.getAndApply(40, []byte{1, 2, 0}, data)
+	assert.Equal(t, true, ok)
+
+	// 42 has been accessed 2 times, more than 40
+	s.getAndApply(42, []byte{1, 2, 2}, data)
+	s.put(42, []byte{1, 2, 2}, []byte{101, 102, 103, 102})
+	_, ok = s.getAndApply(42, []byte{1, 2, 2}, data)
+	assert.Equal(t, true, ok)
+
+	assert.Equal(t, uint64(2), s.getTotal())
+	assert.Equal(t, s.totalAge, s.getSumTotalAge())
+}
+
+func TestSegment_TinyLFU_Always_Admit_Existing_Key(t *testing.T) {
+	const entrySize = entryHeaderSize + 8
+	s := newSegmentTinyLFU(entrySize * 2)
+
+	data := make([]byte, 100)
+
+	s.put(40, []byte{1, 2, 0}, []byte{101, 102, 103, 100})
+	s.getAndApply(40, []byte{1, 2, 0}, data)
+	s.getAndApply(40, []byte{1, 2, 0}, data)
+	s.put(41, []byte{1, 2, 1}, []byte{101, 102, 103, 101})
+
+	s.put(41, []byte{1, 2, 1}, []byte{101, 102, 103, 101, 5, 6, 7, 8})
+
+	n, ok := s.getAndApply(41, []byte{1, 2, 1}, data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, []byte{101, 102, 103, 101, 5, 6, 7, 8}, data[:n])
+}
+
+func simulateScan(s *segment) float64 {
+	var key [8]byte
+	value := make([]byte, 32)
+	placeholder := make([]byte, 32)
+
+	access := func(k uint64) bool {
+		binary.LittleEndian.PutUint64(key[:], k)
+		hash := uint32(memhash.Hash(key[:]))
+		_, ok := s.getAndApply(hash, key[:], placeholder)
+		if !ok {
+			s.put(hash, key[:], value)
+		}
+		return ok
+	}
+
+	const hotCount = 80
+	const scanPerHot = 3
+
+	hitCount := 0
+	for k := uint64(0); k < 20000; k++ {
+		for i := uint64(0); i < scanPerHot; i++ {
+			access(1000 + k*scanPerHot + i)
+		}
+		if access(k % hotCount) {
+			hitCount++
+		}
+	}
+	return float64(hitCount) / 20000
+}
+
+func TestSegment_TinyLFU_Scan_Resistance(t *testing.T) {
+	const bufSize = 100 * (entryHeaderSize + 8 + 32)
+
+	plain := simulateScan(newSegmentPolicy(bufSize, AccessTimePolicy{}))
+	tinyLFU := simulateScan(newSegmentTinyLFU(bufSize))
+
+	fmt.Println("PLAIN:", plain)
+	fmt.Println("TINY LFU:", tinyLFU)
+
+	assert.Greater(t, tinyLFU, 0.6)
+	assert.Greater(t, tinyLFU, plain)
+}
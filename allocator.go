@@ -0,0 +1,234 @@
+package bigcache
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ErrMmapNotSupported is returned by the mmap allocators on platforms without mmap
+var ErrMmapNotSupported = errors.New("bigcache: mmap is not supported on this platform")
+
+// Allocator allocates the ring buffers of the segments (see WithAllocator)
+type Allocator interface {
+	// Alloc returns a zeroed buffer of size bytes for the segment at index
+	Alloc(segment int, size int) ([]byte, error)
+
+	// Free releases a buffer returned by Alloc, the buffer must not be used after
+	Free(segment int, data []byte) error
+}
+
+// HeapAllocator allocates the ring buffers on the Go heap, the default allocator
+type HeapAllocator struct {
+}
+
+var _ Allocator = HeapAllocator{}
+
+// Alloc ...
+func (HeapAllocator) Alloc(_ int, size int) ([]byte, error) {
+	return make([]byte, size), nil
+}
+
+// Free ...
+func (HeapAllocator) Free(int, []byte) error {
+	return nil
+}
+
+// MmapAllocator allocates the ring buffers with anonymous mmap, outside the Go heap
+// so that they do not count toward the GOGC pacing
+type MmapAllocator struct {
+}
+
+var _ Allocator = MmapAllocator{}
+
+// Alloc ...
+func (MmapAllocator) Alloc(_ int, size int) ([]byte, error) {
+	return mmapAnonymous(size)
+}
+
+// Free ...
+func (MmapAllocator) Free(_ int, data []byte) error {
+	return munmap(data)
+}
+
+// FileAllocator maps the ring buffer of each segment to a file in a directory with MAP_SHARED,
+// the content of the buffers is written back to the files by the operating system
+type FileAllocator struct {
+	dir string
+
+	mu    sync.Mutex
+	files map[*byte]*mappedFile // by the first byte of the buffers
+}
+
+type mappedFile struct {
+	file    *os.File
+	path    string
+	segment int
+}
+
+var _ Allocator = &FileAllocator{}
+
+// NewFileAllocator creates a file allocator using the directory dir, the files of the segments
+// are created or truncated when allocating
+func NewFileAllocator(dir string) *FileAllocator {
+	return &FileAllocator{
+		dir:   dir,
+		files: map[*byte]*mappedFile{},
+	}
+}
+
+// Alloc ...
+func (a *FileAllocator) Alloc(segment int, size int) ([]byte, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	path := a.segmentPath(segment)
+	if a.findFile(segment, path) != nil {
+		// the segment is being resized, the file replaces the old one when the old buffer is freed
+		path += ".resize"
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := file.Truncate(int64(size)); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return a.mapFile(file, path, segment, size)
+}
+
+// mapExisting maps the existing file of the segment without truncating, the file must have size bytes
+func (a *FileAllocator) mapExisting(segment int, size int) ([]byte, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	path := a.segmentPath(segment)
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	if info.Size() != int64(size) {
+		_ = file.Close()
+		return nil, fmt.Errorf("bigcache: file %s has %d bytes instead of %d", path, info.Size(), size)
+	}
+	return a.mapFile(file, path, segment, size)
+}
+
+func (a *FileAllocator) mapFile(file *os.File, path string, segment int, size int) ([]byte, error) {
+	data, err := mmapFile(file, size)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	a.files[&data[0]] = &mappedFile{
+		file:    file,
+		path:    path,
+		segment: segment,
+	}
+	return data, nil
+}
+
+// Free ...
+func (a *FileAllocator) Free(segment int, data []byte) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var f *mappedFile
+	if len(data) > 0 {
+		f = a.files[&data[0]]
+	}
+	if f == nil || f.segment != segment {
+		return fmt.Errorf("bigcache: buffer of segment %d is not allocated by the file allocator", segment)
+	}
+	delete(a.files, &data[0])
+
+	if err := munmap(data); err != nil {
+		_ = f.file.Close()
+		return err
+	}
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+
+	path := a.segmentPath(segment)
+	if resized := a.findFile(segment, path+".resize"); resized != nil {
+		resized.path = path
+		return os.Rename(path+".resize", path)
+	}
+	return nil
+}
+
+// Sync flushes the content of the buffers to the files
+func (a *FileAllocator) Sync() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, f := range a.files {
+		if err := f.file.Sync(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// segmentPath returns the path of the file of the segment
+func (a *FileAllocator) segmentPath(segment int) string {
+	return filepath.Join(a.dir, fmt.Sprintf("segment-%04d.data", segment))
+}
+
+func (a *FileAllocator) findFile(segment int, path string) *mappedFile {
+	for _, f := range a.files {
+		if f.segment == segment && f.path == path {
+			return f
+		}
+	}
+	return nil
+}
+
+// allocRingBuf allocates the buffer of a segment, panics if the allocator fails because
+// New and Resize do not return errors
+func allocRingBuf(allocator Allocator, segment int, size int) []byte {
+	data, err := allocator.Alloc(segment, size)
+	if err != nil {
+		panic(fmt.Sprintf("bigcache: allocate ring buffer of segment %d: %v", segment, err))
+	}
+	if len(data) != size {
+		panic(fmt.Sprintf("bigcache: allocator returned %d bytes instead of %d", len(data), size))
+	}
+	return data
+}
+
+// Close releases the ring buffers through the allocator, the cache must not be used after Close.
+// For a cache opened with Open, the state of the ring buffers is saved for the next Open
+func (c *Cache) Close() error {
+	if c.persist != nil {
+		return c.closePersistent()
+	}
+	return c.freeRingBufs()
+}
+
+func (c *Cache) freeRingBufs() error {
+	var firstErr error
+	for i := range c.segments {
+		seg := &c.segments[i]
+		seg.mu.Lock()
+		if seg.rb.data != nil {
+			if err := c.allocator.Free(i, seg.rb.data); err != nil && firstErr == nil {
+				firstErr = err
+			}
+			seg.rb.data = nil
+		}
+		seg.mu.Unlock()
+	}
+	return firstErr
+}
@@ -0,0 +1,89 @@
+package bigcache
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testCacheWithAllocator(t *testing.T, allocator Allocator) {
+	c := New(4, 1<<14, WithAllocator(allocator))
+
+	for i := 0; i < 100; i++ {
+		c.Put([]byte(fmt.Sprintf("key:%d", i)), []byte(fmt.Sprintf("value:%d", i)))
+	}
+
+	assert.Equal(t, nil, c.Resize(1<<17))
+	assert.Equal(t, 1<<17, c.GetCapacity())
+
+	data := make([]byte, 20)
+	for i := 0; i < 100; i++ {
+		n, ok := c.Get([]byte(fmt.Sprintf("key:%d", i)), data)
+		assert.Equal(t, true, ok)
+		assert.Equal(t, fmt.Sprintf("value:%d", i), string(data[:n]))
+	}
+
+	assert.Equal(t, nil, c.Close())
+}
+
+func TestCache_Heap_Allocator(t *testing.T) {
+	testCacheWithAllocator(t, HeapAllocator{})
+}
+
+func TestCache_Mmap_Allocator(t *testing.T) {
+	data, err := MmapAllocator{}.Alloc(0, 4096)
+	if err == ErrMmapNotSupported {
+		t.Skip(err)
+	}
+	assert.Equal(t, nil, err)
+	assert.Equal(t, make([]byte, 4096), data)
+	assert.Equal(t, nil, MmapAllocator{}.Free(0, data))
+
+	testCacheWithAllocator(t, MmapAllocator{})
+}
+
+func TestCache_File_Allocator(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := (MmapAllocator{}).Alloc(0, 4096); err == ErrMmapNotSupported {
+		t.Skip(err)
+	}
+
+	testCacheWithAllocator(t, NewFileAllocator(dir))
+
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{
+		filepath.Join(dir, "segment-0000.data"),
+		filepath.Join(dir, "segment-0001.data"),
+		filepath.Join(dir, "segment-0002.data"),
+		filepath.Join(dir, "segment-0003.data"),
+	}, files)
+
+	// the entries are written back to the files
+	content := make([]byte, 0, 1<<17)
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, 1<<15, len(data))
+		content = append(content, data...)
+	}
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, true, bytes.Contains(content, []byte(fmt.Sprintf("value:%d", i))))
+	}
+}
+
+func TestFileAllocator_Free_Unknown_Buffer(t *testing.T) {
+	a := NewFileAllocator(t.TempDir())
+	err := a.Free(1, make([]byte, 10))
+	assert.EqualError(t, err, "bigcache: buffer of segment 1 is not allocated by the file allocator")
+}
+
+func TestFileAllocator_Alloc_Error(t *testing.T) {
+	c := func() {
+		New(4, 1<<14, WithAllocator(NewFileAllocator(filepath.Join(t.TempDir(), "not-found"))))
+	}
+	assert.Panics(t, c)
+}
@@ -3,6 +3,9 @@ package bigcache
 import (
 	"github.com/QuangTung97/bigcache/memhash"
 	"math/bits"
+	"sync"
+	"time"
+	"unsafe"
 )
 
 // Cache ...
@@ -11,62 +14,182 @@ type Cache struct {
 
 	segmentMask  uint64
 	segmentShift int
+
+	getExpireNow func() uint32
+	invalidator  *invalidator
+	namespaces   *namespaceRegistry
+
+	chunkSize       int64
+	chunkGeneration uint64
+
+	compressor             Compressor
+	compressionThreshold   int
+	compressionPool        *bufferPool
+	compressionRawBytes    uint64
+	compressionStoredBytes uint64
+
+	keyring *Keyring
+
+	allocator Allocator
+	resizeMu  sync.Mutex
+
+	// the configured maximum size of pinned entries per segment, recomputed for the new segment size by Resize
+	maxPinnedBytes int
+
+	// keys are hashed with a seeded hash for caches opened with Open, the hash must not change between processes
+	seededHash bool
+	hashSeed   uint64
+	persist    *persistState
 }
 
 // New ...
-func New(numSegments int, segmentSize int) *Cache {
+func New(numSegments int, segmentSize int, options ...Option) *Cache {
 	if numSegments < 1 {
 		panic("numSegments must not be < 1")
 	}
 	numSegments = nextPowerOfTwo(numSegments)
 
+	opts := newCacheOptions(options...)
+	return newCache(numSegments, segmentSize, opts, func(index int) []byte {
+		return allocRingBuf(opts.allocator, index, segmentSize)
+	})
+}
+
+// newCache creates the cache with numSegments a power of two, the ring buffers are returned by alloc
+func newCache(numSegments int, segmentSize int, opts *cacheOptions, alloc func(index int) []byte) *Cache {
 	segments := make([]segment, numSegments)
 	for i := range segments {
-		initSegment(&segments[i], segmentSize)
+		initSegmentWithData(&segments[i], alloc(i), opts)
 	}
 
 	mask, shift := computeSegmentMask(numSegments)
-	return &Cache{
+	c := &Cache{
 		segments:     segments,
 		segmentMask:  mask,
 		segmentShift: shift,
+
+		getExpireNow: opts.getExpireNow,
+		invalidator:  opts.invalidator,
+		namespaces:   opts.namespaces,
+
+		chunkSize: computeChunkSize(segmentSize),
+
+		compressor:           opts.compressor,
+		compressionThreshold: opts.compressionThreshold,
+		compressionPool:      newBufferPool(defaultTypedBufferSize),
+
+		keyring: opts.keyring,
+
+		allocator: opts.allocator,
+
+		maxPinnedBytes: opts.maxPinnedBytes,
 	}
+	c.invalidator.liveEpochs = c.minLiveEpochs
+	return c
 }
 
 func (c *Cache) getSegment(key []byte) (*segment, uint64) {
-	hash := memhash.Hash(key)
+	hash := c.hash(key)
 	index := getSegmentIndex(c.segmentMask, c.segmentShift, hash)
 	return &c.segments[index], hash
 }
 
+func (c *Cache) getSegmentString(key string) (*segment, uint64) {
+	hash := c.hashString(key)
+	index := getSegmentIndex(c.segmentMask, c.segmentShift, hash)
+	return &c.segments[index], hash
+}
+
+// getSpaceSegment is the same as getSegment for a key of the key space
+func (c *Cache) getSpaceSegment(space keySpace, key []byte) (*segment, uint64) {
+	hash := space.mixHash(c.hash(key))
+	index := getSegmentIndex(c.segmentMask, c.segmentShift, hash)
+	return &c.segments[index], hash
+}
+
+func (c *Cache) hash(key []byte) uint64 {
+	if c.seededHash {
+		return memhash.SeededHash(c.hashSeed, key)
+	}
+	return memhash.Hash(key)
+}
+
+func (c *Cache) hashString(key string) uint64 {
+	if c.seededHash {
+		return memhash.SeededHashString(c.hashSeed, key)
+	}
+	return memhash.HashString(key)
+}
+
 // Put ...
 func (c *Cache) Put(key []byte, value []byte) {
 	seg, hash := c.getSegment(key)
+	c.putEntry(seg, hash, key, value, putParams{})
+}
 
-	seg.mu.Lock()
-	seg.put(uint32(hash), key, value)
-	seg.mu.Unlock()
+// PutWithTTL is the same as Put but the entry expires after ttl, with resolution of seconds.
+// ttl <= 0 means the entry never expires
+func (c *Cache) PutWithTTL(key []byte, value []byte, ttl time.Duration) {
+	seg, hash := c.getSegment(key)
+	params := putParams{expireAt: c.computeExpireAt(ttl)}
+	c.putEntry(seg, hash, key, value, params)
 }
 
-// Get ...
-func (c *Cache) Get(key []byte, value []byte) (int, bool) {
+// PutWithStaleTTL is the same as PutWithTTL but the entry also becomes stale after staleTTL,
+// Lookup of a stale entry returns the value with LookupStale, staleTTL <= 0 means never stale
+func (c *Cache) PutWithStaleTTL(key []byte, value []byte, staleTTL time.Duration, ttl time.Duration) {
 	seg, hash := c.getSegment(key)
+	params := putParams{
+		expireAt: c.computeExpireAt(ttl),
+		staleAt:  c.computeExpireAt(staleTTL),
+	}
+	c.putEntry(seg, hash, key, value, params)
+}
 
-	seg.mu.Lock()
-	n, ok := seg.get(uint32(hash), key, value)
-	seg.mu.Unlock()
+func (c *Cache) computeExpireAt(ttl time.Duration) uint32 {
+	if ttl <= 0 {
+		return 0
+	}
+	seconds := (ttl + time.Second - 1) / time.Second
+	return c.getExpireNow() + uint32(seconds)
+}
 
-	return n, ok
+// Get only holds the read lock of the segment, gets of the same segment can run concurrently
+func (c *Cache) Get(key []byte, value []byte) (int, bool) {
+	n, result := c.Lookup(key, value)
+	return n, result.IsFound()
 }
 
 // Delete ...
 func (c *Cache) Delete(key []byte) bool {
 	seg, hash := c.getSegment(key)
+	return c.deleteKey(seg, hash, keySpace{}, key)
+}
 
+// PutString is the same as Put but with string key, without allocation
+func (c *Cache) PutString(key string, value []byte) {
+	seg, hash := c.getSegmentString(key)
+	c.putEntry(seg, hash, stringBytes(key), value, putParams{})
+}
+
+// GetString is the same as Get but with string key, without allocation
+func (c *Cache) GetString(key string, value []byte) (int, bool) {
+	n, result := c.LookupString(key, value)
+	return n, result.IsFound()
+}
+
+// DeleteString is the same as Delete but with string key, without allocation
+func (c *Cache) DeleteString(key string) bool {
+	seg, hash := c.getSegmentString(key)
+	return c.deleteKey(seg, hash, keySpace{}, stringBytes(key))
+}
+
+func (c *Cache) deleteKey(seg *segment, hash uint64, space keySpace, key []byte) bool {
 	seg.mu.Lock()
-	affected := seg.delete(uint32(hash), key)
+	affected, manifest := seg.deleteWithManifest(uint32(hash), space, key)
 	seg.mu.Unlock()
 
+	c.deleteChunks(space, manifest, manifest.chunkCount())
 	return affected
 }
 
@@ -97,6 +220,24 @@ func (c *Cache) GetTotal() uint64 {
 	return count
 }
 
+// GetExpiredCount returns the number of entries removed because of expiration
+func (c *Cache) GetExpiredCount() uint64 {
+	count := uint64(0)
+	for i := range c.segments {
+		count += c.segments[i].getExpiredCount()
+	}
+	return count
+}
+
+// GetDeadBytes returns the size of deleted entries still occupying the ring buffers
+func (c *Cache) GetDeadBytes() uint64 {
+	count := uint64(0)
+	for i := range c.segments {
+		count += c.segments[i].getDeadBytes()
+	}
+	return count
+}
+
 func nextPowerOfTwo(n int) int {
 	num := uint32(n)
 	return 1 << bits.Len32(num-1)
@@ -110,3 +251,24 @@ func computeSegmentMask(n int) (uint64, int) {
 func getSegmentIndex(mask uint64, shift int, hash uint64) int {
 	return int((hash & mask) >> shift)
 }
+
+type sliceHeader struct {
+	data unsafe.Pointer
+	len  int
+	cap  int
+}
+
+type stringHeader struct {
+	data unsafe.Pointer
+	len  int
+}
+
+// stringBytes returns the bytes of s without copying, the result must NOT be modified or retained
+func stringBytes(s string) []byte {
+	sh := (*stringHeader)(unsafe.Pointer(&s))
+	return *(*[]byte)(unsafe.Pointer(&sliceHeader{
+		data: sh.data,
+		len:  sh.len,
+		cap:  sh.len,
+	}))
+}
@@ -1,8 +1,10 @@
 package bigcache
 
 import (
+	"github.com/QuangTung97/bigcache/bigcachetest"
 	"github.com/stretchr/testify/assert"
 	"testing"
+	"time"
 )
 
 func TestNextPowerOfTwo(t *testing.T) {
@@ -78,3 +80,79 @@ func TestCache_New_Panic(t *testing.T) {
 		New(0, 12345)
 	})
 }
+
+func TestCache_String_Key(t *testing.T) {
+	c := New(4, 12345)
+
+	c.PutString("key01", []byte{20, 21, 22})
+	c.Put([]byte("key02"), []byte{30, 31})
+
+	value := make([]byte, 20)
+
+	n, ok := c.GetString("key01", value)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, []byte{20, 21, 22}, value[:n])
+
+	n, ok = c.Get([]byte("key01"), value)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, []byte{20, 21, 22}, value[:n])
+
+	n, ok = c.GetString("key02", value)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, []byte{30, 31}, value[:n])
+
+	n, ok = c.GetString("key03", value)
+	assert.Equal(t, false, ok)
+	assert.Equal(t, 0, n)
+
+	assert.Equal(t, true, c.DeleteString("key02"))
+	assert.Equal(t, false, c.DeleteString("key02"))
+
+	_, ok = c.Get([]byte("key02"), value)
+	assert.Equal(t, false, ok)
+	assert.Equal(t, uint64(1), c.GetTotal())
+}
+
+func TestCache_String_Key_No_Alloc(t *testing.T) {
+	c := New(4, 12345)
+	key := "some-key"
+	value := make([]byte, 20)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		c.PutString(key, []byte{1, 2, 3})
+		c.GetString(key, value)
+		c.DeleteString(key)
+	})
+	assert.Equal(t, float64(0), allocs)
+}
+
+func TestStringBytes(t *testing.T) {
+	assert.Equal(t, []byte("abcd"), stringBytes("abcd"))
+	assert.Equal(t, 0, len(stringBytes("")))
+}
+
+func TestCache_Put_With_TTL(t *testing.T) {
+	clock := bigcachetest.NewFakeClock(0)
+	c := New(4, 12345, WithClock(clock))
+
+	c.PutWithTTL([]byte{10, 11, 12}, []byte{20, 21}, 1500*time.Millisecond)
+	c.PutWithTTL([]byte{10, 11, 13}, []byte{20, 22}, 0)
+
+	value := make([]byte, 20)
+
+	clock.Advance(time.Second)
+	_, ok := c.Get([]byte{10, 11, 12}, value)
+	assert.Equal(t, true, ok)
+
+	clock.Advance(time.Second)
+	_, ok = c.Get([]byte{10, 11, 12}, value)
+	assert.Equal(t, false, ok)
+
+	clock.Advance(time.Hour)
+	n, ok := c.Get([]byte{10, 11, 13}, value)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, []byte{20, 22}, value[:n])
+
+	assert.Equal(t, uint64(1), c.GetTotal())
+	assert.Equal(t, uint64(1), c.GetExpiredCount())
+}
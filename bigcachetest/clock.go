@@ -0,0 +1,30 @@
+// Package bigcachetest provides utilities for testing code using bigcache
+package bigcachetest
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// FakeClock is a manual clock implementing bigcache.Clock, the time only changes when advanced
+type FakeClock struct {
+	now int64
+}
+
+// NewFakeClock creates a fake clock starting at the nano time start
+func NewFakeClock(start int64) *FakeClock {
+	return &FakeClock{now: start}
+}
+
+// NanoTime ...
+func (c *FakeClock) NanoTime() int64 {
+	return atomic.LoadInt64(&c.now)
+}
+
+// Advance moves the clock forward
+func (c *FakeClock) Advance(d time.Duration) {
+	if d < 0 {
+		panic("can not advance a negative duration")
+	}
+	atomic.AddInt64(&c.now, int64(d))
+}
@@ -0,0 +1,64 @@
+package bigcachetest
+
+import (
+	"github.com/QuangTung97/bigcache"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+var _ bigcache.Clock = &FakeClock{}
+
+func TestFakeClock(t *testing.T) {
+	c := NewFakeClock(1000)
+	assert.Equal(t, int64(1000), c.NanoTime())
+	assert.Equal(t, int64(1000), c.NanoTime())
+
+	c.Advance(2 * time.Second)
+	assert.Equal(t, int64(1000+2*time.Second), c.NanoTime())
+
+	assert.PanicsWithValue(t, "can not advance a negative duration", func() {
+		c.Advance(-1)
+	})
+}
+
+func TestFakeClock_With_Cache_Eviction(t *testing.T) {
+	clock := NewFakeClock(0)
+	c := bigcache.New(1, 4*(20+8), bigcache.WithClock(clock))
+
+	for i := 0; i < 4; i++ {
+		clock.Advance(time.Second)
+		c.Put([]byte{1, 2, uint8(i)}, []byte{101, 102, 103, 100})
+	}
+
+	clock.Advance(time.Second)
+	value := make([]byte, 10)
+	_, ok := c.Get([]byte{1, 2, 1}, value)
+	assert.Equal(t, true, ok)
+
+	clock.Advance(time.Second)
+	c.Put([]byte{5, 5, 5}, []byte{101, 102, 103, 100})
+
+	_, ok = c.Get([]byte{1, 2, 0}, value)
+	assert.Equal(t, false, ok)
+	_, ok = c.Get([]byte{1, 2, 1}, value)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, uint64(4), c.GetTotal())
+}
+
+func TestFakeClock_With_Cache_TTL(t *testing.T) {
+	clock := NewFakeClock(0)
+	c := bigcache.New(1, 1024, bigcache.WithClock(clock))
+
+	c.PutWithTTL([]byte("key"), []byte("value"), 5*time.Second)
+
+	value := make([]byte, 10)
+	clock.Advance(4 * time.Second)
+	_, ok := c.Get([]byte("key"), value)
+	assert.Equal(t, true, ok)
+
+	clock.Advance(time.Second)
+	_, ok = c.Get([]byte("key"), value)
+	assert.Equal(t, false, ok)
+	assert.Equal(t, uint64(0), c.GetTotal())
+}
@@ -0,0 +1,138 @@
+package bigcache
+
+import (
+	"fmt"
+	"hash/crc32"
+	"sync/atomic"
+	"unsafe"
+)
+
+// GetCorruptedCount returns the number of entries found corrupted by lookups and removed (see WithChecksum)
+func (c *Cache) GetCorruptedCount() uint64 {
+	count := uint64(0)
+	for i := range c.segments {
+		count += c.segments[i].getCorruptedCount()
+	}
+	return count
+}
+
+// Verify scans all segments and checks the consistency between the index and the ring buffers:
+// every live entry is indexed at its offset, the entries cover the ring buffer exactly, the counters
+// match and the checksums are valid if enabled. Segments are locked one at a time for reading
+func (c *Cache) Verify() error {
+	for i := range c.segments {
+		seg := &c.segments[i]
+
+		seg.mu.RLock()
+		err := seg.verify()
+		seg.mu.RUnlock()
+
+		if err != nil {
+			return fmt.Errorf("bigcache: segment %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func (s *segment) verify() error {
+	var headerData [maxEntryHeaderSize]byte
+	header := (*entryHeader)(unsafe.Pointer(&headerData[0]))
+
+	liveCount := 0
+	deadBytes := 0
+
+	pos := s.rb.getBeginPos()
+	endPos := pos + uint64(s.rb.size)
+	for pos < endPos {
+		offset := s.rb.posToOffset(pos)
+		s.rb.readHeader(&headerData, offset)
+
+		size := header.entrySize()
+		if header.valLen > header.valCap || pos+uint64(size) > endPos {
+			return fmt.Errorf("invalid entry header at offset %d", offset)
+		}
+		pos += uint64(size)
+
+		if header.deleted {
+			deadBytes += size
+			continue
+		}
+		liveCount++
+		if err := s.verifyEntry(header, offset); err != nil {
+			return err
+		}
+	}
+	return s.verifyCounts(liveCount, deadBytes)
+}
+
+// verifyEntry checks that the live entry at offset is indexed and matches its checksum
+func (s *segment) verifyEntry(header *entryHeader, offset int) error {
+	if indexOffset, ok := s.kv[header.hash]; !ok || indexOffset != offset {
+		return fmt.Errorf("entry at offset %d is not indexed", offset)
+	}
+	if s.isCorrupted(header, offset) {
+		return fmt.Errorf("checksum mismatch of entry at offset %d", offset)
+	}
+	return nil
+}
+
+// verifyCounts checks the counters of the segment against the entries of the ring buffer
+func (s *segment) verifyCounts(liveCount int, deadBytes int) error {
+	if liveCount != len(s.kv) {
+		return fmt.Errorf("index has %d entries but ring buffer has %d live entries", len(s.kv), liveCount)
+	}
+	if uint64(liveCount) != s.getTotal() {
+		return fmt.Errorf("total is %d but ring buffer has %d live entries", s.getTotal(), liveCount)
+	}
+	if uint64(deadBytes) != s.getDeadBytes() {
+		return fmt.Errorf("dead bytes is %d but ring buffer has %d dead bytes", s.getDeadBytes(), deadBytes)
+	}
+	return nil
+}
+
+func (s *segment) computeChecksum(header *entryHeader, offset int) uint32 {
+	crc := s.rb.updateCRC(0, s.checksumTable, header.keyOffset(offset), int(header.keyLen))
+	return s.rb.updateCRC(crc, s.checksumTable, header.valueOffset(offset), int(header.valLen))
+}
+
+// writeChecksum computes the checksum of the entry of hash after its value is written
+func (s *segment) writeChecksum(hash uint32) {
+	if s.checksumTable == nil {
+		return
+	}
+
+	var headerData [maxEntryHeaderSize]byte
+	header := (*entryHeader)(unsafe.Pointer(&headerData[0]))
+
+	offset := s.kv[hash]
+	s.rb.readHeader(&headerData, offset)
+	header.checksum = s.computeChecksum(header, offset)
+	s.rb.writeHeader(&headerData, offset)
+}
+
+// isCorrupted returns true if checksums are enabled and the entry does not match its checksum
+func (s *segment) isCorrupted(header *entryHeader, offset int) bool {
+	if s.checksumTable == nil {
+		return false
+	}
+	if header.valLen > header.valCap || header.entrySize() > len(s.rb.data) {
+		return true
+	}
+	return header.checksum != s.computeChecksum(header, offset)
+}
+
+func (s *segment) removeCorrupted(header *entryHeader, headerData *[maxEntryHeaderSize]byte, offset int) {
+	s.deleteEntry(header, headerData, offset)
+	atomic.AddUint64(&s.corruptedCount, 1)
+}
+
+// updateCRC updates crc with n bytes at offset
+func (r *ringBuf) updateCRC(crc uint32, table *crc32.Table, offset int, n int) uint32 {
+	offset = offset % len(r.data)
+	if offset+n <= len(r.data) {
+		return crc32.Update(crc, table, r.data[offset:offset+n])
+	}
+	firstPart := len(r.data) - offset
+	crc = crc32.Update(crc, table, r.data[offset:])
+	return crc32.Update(crc, table, r.data[:n-firstPart])
+}
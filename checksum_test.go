@@ -0,0 +1,152 @@
+package bigcache
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"testing"
+)
+
+func corruptValue(c *Cache, key []byte) {
+	seg, hash := c.getSegment(key)
+	offset := seg.kv[uint32(hash)]
+	header := seg.getHeader(uint32(hash))
+	seg.rb.data[header.valueOffset(offset)%len(seg.rb.data)] ^= 0xff
+}
+
+func TestCache_Checksum(t *testing.T) {
+	c := New(4, 1<<14, WithChecksum())
+
+	c.Put([]byte("key01"), []byte("value01"))
+	c.Put([]byte("key02"), []byte("value02"))
+
+	seg, hash := c.getSegment([]byte("key01"))
+	assert.NotEqual(t, uint32(0), seg.getHeader(uint32(hash)).checksum)
+
+	data := make([]byte, 20)
+	n, ok := c.Get([]byte("key01"), data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "value01", string(data[:n]))
+
+	corruptValue(c, []byte("key01"))
+	assert.Error(t, c.Verify())
+
+	_, ok = c.Get([]byte("key01"), data)
+	assert.Equal(t, false, ok)
+	assert.Equal(t, uint64(1), c.GetCorruptedCount())
+	assert.Equal(t, uint64(1), c.GetTotal())
+	assert.Equal(t, nil, c.Verify())
+
+	n, ok = c.Get([]byte("key02"), data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "value02", string(data[:n]))
+}
+
+func TestCache_Checksum_Replace_In_Place(t *testing.T) {
+	c := New(4, 1<<14, WithChecksum())
+
+	c.Put([]byte("key01"), []byte("value01"))
+	c.Put([]byte("key01"), []byte("value1"))
+
+	data := make([]byte, 20)
+	n, ok := c.Get([]byte("key01"), data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "value1", string(data[:n]))
+
+	err := c.PutFrom([]byte("key01"), bytes.NewReader([]byte("value2")), 6)
+	assert.Equal(t, nil, err)
+	n, ok = c.Get([]byte("key01"), data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "value2", string(data[:n]))
+	assert.Equal(t, uint64(0), c.GetCorruptedCount())
+}
+
+func TestSegment_Checksum_Exclusive_Get(t *testing.T) {
+	s := &segment{}
+	initSegment(s, 1024, newCacheOptions(WithChecksum()))
+
+	s.put(40, []byte{1, 2, 3}, []byte{10, 11, 12, 13})
+	s.rb.data[entryHeaderSize+entryExtendedSize+1] ^= 1 // corrupt the key
+
+	_, ok := s.getAndApply(40, []byte{1, 3, 3}, nil)
+	assert.Equal(t, false, ok)
+	assert.Equal(t, uint64(1), s.getCorruptedCount())
+	assert.Equal(t, uint64(0), s.getTotal())
+}
+
+func TestCache_Without_Checksum(t *testing.T) {
+	c := New(4, 1<<14)
+
+	c.Put([]byte("key01"), []byte("value01"))
+	corruptValue(c, []byte("key01"))
+
+	_, ok := c.Get([]byte("key01"), nil)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, nil, c.Verify())
+}
+
+func TestCache_Verify_Index(t *testing.T) {
+	c := New(1, 1<<14)
+	c.Put([]byte("key01"), []byte("value01"))
+	c.Put([]byte("key02"), []byte("value02"))
+
+	seg, hash := c.getSegment([]byte("key02"))
+	notIndexed := fmt.Sprintf("bigcache: segment 0: entry at offset %d is not indexed", entryHeaderSize+12)
+	seg.kv[uint32(hash)] = 0
+	assert.EqualError(t, c.Verify(), notIndexed)
+
+	delete(seg.kv, uint32(hash))
+	assert.EqualError(t, c.Verify(), notIndexed)
+}
+
+func TestCache_Verify_Counters(t *testing.T) {
+	c := New(1, 1<<14)
+	c.Put([]byte("key01"), []byte("value01"))
+	c.Put([]byte("key02"), []byte("value02"))
+	c.Delete([]byte("key01"))
+	assert.Equal(t, nil, c.Verify())
+
+	c.segments[0].deadBytes = 0
+	assert.EqualError(t, c.Verify(), fmt.Sprintf(
+		"bigcache: segment 0: dead bytes is 0 but ring buffer has %d dead bytes", entryHeaderSize+12,
+	))
+
+	c.segments[0].total = 2
+	assert.EqualError(t, c.Verify(), "bigcache: segment 0: total is 2 but ring buffer has 1 live entries")
+}
+
+func TestCache_Verify_Invalid_Header(t *testing.T) {
+	c := New(1, 1<<14)
+	c.Put([]byte("key01"), []byte("value01"))
+
+	c.segments[0].rb.data[8] = 0xff // keyLen
+	assert.EqualError(t, c.Verify(), "bigcache: segment 0: invalid entry header at offset 0")
+}
+
+func TestCache_Verify_Stress(t *testing.T) {
+	c := New(4, 1<<12, WithChecksum())
+	ns := c.Namespace("ns", 1<<11)
+
+	for i := 0; i < 20000; i++ {
+		key := []byte(fmt.Sprintf("key:%d", rand.Intn(500)))
+		switch rand.Intn(6) {
+		case 0:
+			c.Delete(key)
+		case 1:
+			ns.Put(key, make([]byte, rand.Intn(100)))
+		case 2:
+			c.PutWithTags(key, make([]byte, rand.Intn(100)), "tag")
+		case 3:
+			c.Get(key, nil)
+		default:
+			c.Put(key, make([]byte, rand.Intn(100)))
+		}
+		if i%5000 == 0 {
+			c.InvalidateTag("tag")
+			assert.Equal(t, nil, c.Resize(1<<14+rand.Intn(1<<14)))
+		}
+	}
+	assert.Equal(t, nil, c.Verify())
+	assert.Equal(t, uint64(0), c.GetCorruptedCount())
+}
@@ -0,0 +1,257 @@
+package bigcache
+
+import (
+	"encoding/binary"
+	"io"
+	"sync/atomic"
+)
+
+// chunkKeySize is the size of the keys of chunks, chunks are in their own key space (see keySpace)
+const chunkKeySize = 8 + 4 // generation | index
+
+const chunkManifestSize = 8 + 8 + 4 // total length | generation | chunk size
+
+// lookupChunked is set on the result of a segment lookup when the value is a chunk manifest,
+// it is never returned by Cache.Lookup
+const lookupChunked LookupResult = 1 << 8
+
+// Values bigger than the chunk size (a quarter of the segment size) are split into chunks, each chunk is
+// an entry with a key derived from a generation number unique to the put, possibly in another segment.
+// The entry of the key itself only contains a manifest, chunks are written before the manifest.
+// Chunks are not subject to admission, they are deleted with the value by Delete or when the value is replaced.
+// Chunks of evicted or invalidated values are no longer reachable and are evicted as usual
+type chunkManifest struct {
+	totalLen   uint64
+	generation uint64
+	chunkSize  uint32
+}
+
+func (m chunkManifest) encode(data []byte) {
+	binary.LittleEndian.PutUint64(data[0:], m.totalLen)
+	binary.LittleEndian.PutUint64(data[8:], m.generation)
+	binary.LittleEndian.PutUint32(data[16:], m.chunkSize)
+}
+
+func decodeChunkManifest(data []byte) chunkManifest {
+	return chunkManifest{
+		totalLen:   binary.LittleEndian.Uint64(data[0:]),
+		generation: binary.LittleEndian.Uint64(data[8:]),
+		chunkSize:  binary.LittleEndian.Uint32(data[16:]),
+	}
+}
+
+// chunkCount returns 0 for the zero manifest
+func (m chunkManifest) chunkCount() int {
+	if m.chunkSize == 0 {
+		return 0
+	}
+	return int((m.totalLen + uint64(m.chunkSize) - 1) / uint64(m.chunkSize))
+}
+
+func (m chunkManifest) chunkKey(index int) [chunkKeySize]byte {
+	var key [chunkKeySize]byte
+	binary.BigEndian.PutUint64(key[:], m.generation)
+	binary.BigEndian.PutUint32(key[8:], uint32(index))
+	return key
+}
+
+func computeChunkSize(segmentSize int) int64 {
+	return int64(segmentSize / 4)
+}
+
+func (c *Cache) getChunkSize() int {
+	return int(atomic.LoadInt64(&c.chunkSize))
+}
+
+// encodeBuffers are the pooled buffers holding a value returned by encodeValue
+type encodeBuffers struct {
+	compressed *[]byte
+	encrypted  *[]byte
+}
+
+// encodeValue compresses and encrypts the value if enabled, setting the flags and the key id of params.
+// The returned value may be in bufs, to be released by releaseEncodeBuffers after putting
+func (c *Cache) encodeValue(key []byte, value []byte, params *putParams) ([]byte, encodeBuffers) {
+	var bufs encodeBuffers
+	if c.compressor != nil {
+		bufs.compressed = c.compressionPool.get()
+		if compressed, ok := c.compressValue(value, bufs.compressed); ok {
+			value = compressed
+			params.flags |= entryFlagCompressed
+		}
+	}
+	if c.keyring != nil {
+		bufs.encrypted = c.compressionPool.get()
+		value, params.keyID = c.encryptValue(value, key, bufs.encrypted)
+	}
+	return value, bufs
+}
+
+func (c *Cache) releaseEncodeBuffers(bufs encodeBuffers) {
+	if bufs.compressed != nil {
+		c.compressionPool.put(bufs.compressed)
+	}
+	if bufs.encrypted != nil {
+		c.compressionPool.put(bufs.encrypted)
+	}
+}
+
+// putEntry puts the entry into the segment of the key, compressing and encrypting the value if enabled
+// and splitting it into chunks if too big
+func (c *Cache) putEntry(seg *segment, hash uint64, key []byte, value []byte, params putParams) {
+	value, bufs := c.encodeValue(key, value, &params)
+	defer c.releaseEncodeBuffers(bufs)
+
+	if len(value) > c.getChunkSize() {
+		c.putChunked(seg, hash, key, value, params)
+		return
+	}
+
+	c.putIntoSegment(seg, hash, key, value, params)
+}
+
+// putIntoSegment puts the entry into seg, then deletes the chunks of the value it replaced.
+// Returns false if the entry is not admitted
+func (c *Cache) putIntoSegment(seg *segment, hash uint64, key []byte, value []byte, params putParams) bool {
+	seg.mu.Lock()
+	replaced, admitted := seg.putWithParams(uint32(hash), key, value, params)
+	seg.mu.Unlock()
+
+	c.deleteChunks(params.keySpace(), replaced, replaced.chunkCount())
+	return admitted
+}
+
+func (c *Cache) putChunked(seg *segment, hash uint64, key []byte, value []byte, params putParams) {
+	_ = c.putChunks(seg, hash, key, value, chunkSource{size: len(value)}, params)
+}
+
+// chunkSource is the size of the value put by putChunks and its reader if the value is not in memory.
+// The value is not part of it so that it does not escape with the reader
+type chunkSource struct {
+	r    io.Reader
+	size int
+}
+
+// putChunks puts the chunks of a value, from value or read from src if value is nil,
+// then puts the manifest if all chunks are put successfully
+func (c *Cache) putChunks(
+	seg *segment, hash uint64, key []byte, value []byte, src chunkSource, params putParams,
+) error {
+	size := src.size
+	manifest := chunkManifest{
+		totalLen:   uint64(size),
+		generation: atomic.AddUint64(&c.chunkGeneration, 1),
+		chunkSize:  uint32(c.getChunkSize()),
+	}
+
+	chunkParams := putParams{
+		expireAt:  params.expireAt,
+		staleAt:   params.staleAt,
+		flags:     entryFlagChunk,
+		namespace: params.namespace,
+	}
+	for i := 0; i < manifest.chunkCount(); i++ {
+		begin := i * int(manifest.chunkSize)
+		end := begin + int(manifest.chunkSize)
+		if end > size {
+			end = size
+		}
+
+		chunkKey := manifest.chunkKey(i)
+		chunkSeg, chunkHash := c.getSpaceSegment(chunkParams.keySpace(), chunkKey[:])
+
+		// the keys of chunks are unique, no chunked value is replaced
+		var err error
+		chunkSeg.mu.Lock()
+		if value != nil {
+			chunkSeg.putWithParams(uint32(chunkHash), chunkKey[:], value[begin:end], chunkParams)
+		} else {
+			_, err = chunkSeg.putFrom(uint32(chunkHash), chunkKey[:], src.r, end-begin, chunkParams)
+		}
+		chunkSeg.mu.Unlock()
+
+		if err != nil {
+			c.deleteChunks(params.keySpace(), manifest, i)
+			return err
+		}
+	}
+
+	var data [chunkManifestSize]byte
+	manifest.encode(data[:])
+	params.flags |= entryFlagChunked
+
+	if !c.putIntoSegment(seg, hash, key, data[:], params) {
+		c.deleteChunks(params.keySpace(), manifest, manifest.chunkCount())
+	}
+	return nil
+}
+
+// deleteChunks deletes the first count chunks of the manifest of a value of the key space
+func (c *Cache) deleteChunks(space keySpace, manifest chunkManifest, count int) {
+	chunkSpace := space.chunkSpace()
+	for i := 0; i < count; i++ {
+		chunkKey := manifest.chunkKey(i)
+		chunkSeg, chunkHash := c.getSpaceSegment(chunkSpace, chunkKey[:])
+		c.deleteKey(chunkSeg, chunkHash, chunkSpace, chunkKey[:])
+	}
+}
+
+// lookupEntry looks up the key in its segment, reassembling, decrypting and decompressing the value if needed
+func (c *Cache) lookupEntry(seg *segment, hash uint64, space keySpace, key []byte, value []byte) (int, LookupResult) {
+	n, result := c.lookupStored(seg, hash, space, key, value)
+	if result&lookupEncodingMask == 0 {
+		return n, result
+	}
+	l := entryLookup{seg: seg, hash: hash, space: space, key: key}
+	return c.lookupEncodedValue(l, value, n, result)
+}
+
+// lookupStored is the same as lookupEntry but the value is not decoded, the encoding bits are kept in the result
+func (c *Cache) lookupStored(seg *segment, hash uint64, space keySpace, key []byte, value []byte) (int, LookupResult) {
+	n, result := seg.getWithReadLock(uint32(hash), space, key, value)
+	if result&lookupChunked == 0 {
+		return n, result
+	}
+	result &^= lookupChunked
+
+	var data [chunkManifestSize]byte
+	if len(value) >= chunkManifestSize {
+		copy(data[:], value)
+	} else {
+		_, chunkResult := seg.getWithReadLock(uint32(hash), space, key, data[:])
+		if chunkResult&lookupChunked == 0 {
+			return 0, LookupMiss
+		}
+	}
+	manifest := decodeChunkManifest(data[:])
+
+	// every chunk is checked even if the value buffer is too small
+	for i := 0; i < manifest.chunkCount(); i++ {
+		begin := i * int(manifest.chunkSize)
+		end := begin + int(manifest.chunkSize)
+		if uint64(end) > manifest.totalLen {
+			end = int(manifest.totalLen)
+		}
+
+		chunkKey := manifest.chunkKey(i)
+		chunkSeg, chunkHash := c.getSpaceSegment(space.chunkSpace(), chunkKey[:])
+		chunkLen, chunkResult := chunkSeg.getWithReadLock(
+			uint32(chunkHash), space.chunkSpace(), chunkKey[:], subSlice(value, begin, end),
+		)
+		if !chunkResult.IsFound() || chunkLen != end-begin {
+			return 0, LookupMiss
+		}
+	}
+	return int(manifest.totalLen), result
+}
+
+// subSlice returns data[begin:end] limited to the length of data
+func subSlice(data []byte, begin int, end int) []byte {
+	if end > len(data) {
+		end = len(data)
+	}
+	if begin > end {
+		begin = end
+	}
+	return data[begin:end]
+}
@@ -0,0 +1,296 @@
+package bigcache
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/QuangTung97/bigcache/bigcachetest"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func randomBytes(n int) []byte {
+	data := make([]byte, n)
+	rand.Read(data)
+	return data
+}
+
+func TestChunkManifest(t *testing.T) {
+	m := chunkManifest{totalLen: 1001, generation: 12, chunkSize: 100}
+	var data [chunkManifestSize]byte
+	m.encode(data[:])
+
+	assert.Equal(t, m, decodeChunkManifest(data[:]))
+	assert.Equal(t, 11, m.chunkCount())
+	assert.Equal(t, [chunkKeySize]byte{0, 0, 0, 0, 0, 0, 0, 12, 0, 0, 0, 3}, m.chunkKey(3))
+}
+
+// deleteTestChunk deletes the chunk at index of a chunked value of the Cache itself
+func deleteTestChunk(c *Cache, manifest chunkManifest, index int) bool {
+	space := keySpace{}.chunkSpace()
+	chunkKey := manifest.chunkKey(index)
+	seg, hash := c.getSpaceSegment(space, chunkKey[:])
+	return c.deleteKey(seg, hash, space, chunkKey[:])
+}
+
+func TestCache_Put_Chunked(t *testing.T) {
+	c := New(4, 1<<14)
+	assert.Equal(t, 1<<12, c.getChunkSize())
+
+	value := randomBytes(10000)
+	c.Put([]byte("page"), value)
+	assert.Equal(t, uint64(1+3), c.GetTotal())
+
+	data := make([]byte, 20000)
+	n, ok := c.Get([]byte("page"), data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, value, data[:n])
+
+	n, result := c.LookupString("page", data)
+	assert.Equal(t, LookupHit, result)
+	assert.Equal(t, value, data[:n])
+}
+
+func TestCache_Put_Chunked_Small_Buffer(t *testing.T) {
+	c := New(4, 1<<14)
+
+	value := randomBytes(9000)
+	c.Put([]byte("page"), value)
+
+	data := make([]byte, 4500)
+	n, ok := c.Get([]byte("page"), data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, 9000, n)
+	assert.Equal(t, value[:4500], data)
+
+	n, ok = c.Get([]byte("page"), data[:10])
+	assert.Equal(t, true, ok)
+	assert.Equal(t, 9000, n)
+	assert.Equal(t, value[:10], data[:10])
+}
+
+func TestCache_Put_Chunked_Missing_Chunk(t *testing.T) {
+	c := New(4, 1<<14)
+
+	c.Put([]byte("page"), randomBytes(9000))
+
+	manifest := chunkManifest{totalLen: 9000, generation: 1, chunkSize: 1 << 12}
+	assert.Equal(t, true, deleteTestChunk(c, manifest, 2))
+
+	data := make([]byte, 9000)
+	n, result := c.Lookup([]byte("page"), data)
+	assert.Equal(t, LookupMiss, result)
+	assert.Equal(t, 0, n)
+}
+
+func TestCache_Put_Chunked_Binary_Key(t *testing.T) {
+	c := New(4, 1<<14)
+
+	value := randomBytes(9000)
+	c.Put([]byte("page"), value)
+
+	// the same bytes as the key of the first chunk
+	manifest := chunkManifest{totalLen: 9000, generation: 1, chunkSize: 1 << 12}
+	chunkKey := manifest.chunkKey(0)
+	_, ok := c.Get(chunkKey[:], nil)
+	assert.Equal(t, false, ok)
+	c.Put(chunkKey[:], []byte("value"))
+	assert.Equal(t, false, c.Delete(chunkKey[:][:4]))
+
+	data := make([]byte, 9000)
+	n, ok := c.Get([]byte("page"), data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, value, data[:n])
+
+	n, ok = c.Get(chunkKey[:], data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "value", string(data[:n]))
+}
+
+func TestCache_Put_Chunked_Delete(t *testing.T) {
+	c := New(4, 1<<14)
+
+	c.Put([]byte("page"), randomBytes(9000))
+	assert.Equal(t, uint64(1+3), c.GetTotal())
+
+	assert.Equal(t, true, c.Delete([]byte("page")))
+	assert.Equal(t, uint64(0), c.GetTotal())
+
+	manifest := chunkManifest{totalLen: 9000, generation: 1, chunkSize: 1 << 12}
+	assert.Equal(t, false, deleteTestChunk(c, manifest, 0))
+}
+
+func TestCache_Put_Chunked_TinyLFU(t *testing.T) {
+	c := New(1, 1<<16, WithTinyLFUAdmission(), WithEvictionPolicy(FIFOPolicy{}))
+
+	// fills the cache so that new entries need admission
+	value := make([]byte, 100)
+	for i := 0; i < 1000; i++ {
+		c.Put([]byte(fmt.Sprintf("key:%d", i)), value)
+	}
+
+	// the manifest is admitted when more frequent than the entries at the head
+	page := randomBytes(30000)
+	for i := 0; i < 3; i++ {
+		c.Put([]byte("page"), page)
+	}
+
+	data := make([]byte, 30000)
+	n, ok := c.Get([]byte("page"), data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, page, data[:n])
+}
+
+func TestCache_Put_Chunked_TinyLFU_Not_Admitted(t *testing.T) {
+	c := New(1, 1<<14, WithTinyLFUAdmission(), WithEvictionPolicy(FIFOPolicy{}))
+
+	value := make([]byte, 100)
+	for i := 0; i < 200; i++ {
+		c.Put([]byte(fmt.Sprintf("key:%d", i)), value)
+	}
+	// every entry is more frequent than a new key
+	for k := 0; k < 20; k++ {
+		for i := 0; i < 200; i++ {
+			c.Get([]byte(fmt.Sprintf("key:%d", i)), nil)
+		}
+		c.segments[0].mu.Lock()
+		c.segments[0].drainReads()
+		c.segments[0].mu.Unlock()
+	}
+	total := c.GetTotal()
+
+	// the manifest with a long key needs space and is not admitted, its chunks are deleted
+	key := bytes.Repeat([]byte("page"), 1000)
+	c.Put(key, randomBytes(9000))
+	_, ok := c.Get(key, nil)
+	assert.Equal(t, false, ok)
+	assert.Less(t, c.GetTotal(), total)
+	manifest := chunkManifest{totalLen: 9000, generation: 1, chunkSize: 1 << 12}
+	for i := 0; i < manifest.chunkCount(); i++ {
+		assert.Equal(t, false, deleteTestChunk(c, manifest, i))
+	}
+}
+
+func TestCache_Put_Chunked_Evicted(t *testing.T) {
+	c := New(4, 1<<14)
+
+	// bigger than the whole cache, some chunks are evicted by the others
+	c.Put([]byte("page"), randomBytes(1<<17))
+
+	data := make([]byte, 1<<17)
+	_, ok := c.Get([]byte("page"), data)
+	assert.Equal(t, false, ok)
+}
+
+func TestCache_Put_Chunked_Replace(t *testing.T) {
+	c := New(4, 1<<14)
+
+	c.Put([]byte("page"), randomBytes(9000))
+	c.Put([]byte("page"), []byte("small"))
+	// the chunks of the replaced value are deleted
+	assert.Equal(t, uint64(1), c.GetTotal())
+
+	data := make([]byte, 9000)
+	n, ok := c.Get([]byte("page"), data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "small", string(data[:n]))
+
+	value := randomBytes(6000)
+	c.Put([]byte("page"), value)
+	n, ok = c.Get([]byte("page"), data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, value, data[:n])
+}
+
+func TestCache_Put_Chunked_Replace_Chunked(t *testing.T) {
+	c := New(4, 1<<14)
+
+	var value []byte
+	for i := 0; i < 5; i++ {
+		value = randomBytes(10000)
+		c.Put([]byte("page"), value)
+		assert.Equal(t, uint64(1+3), c.GetTotal())
+	}
+
+	data := make([]byte, 10000)
+	n, ok := c.Get([]byte("page"), data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, value, data[:n])
+
+	assert.Equal(t, true, c.Delete([]byte("page")))
+	assert.Equal(t, uint64(0), c.GetTotal())
+}
+
+func TestCache_Put_Chunked_Replace_Negative_And_Pinned(t *testing.T) {
+	c := New(4, 1<<14, WithMaxPinnedBytes(1<<10))
+
+	c.Put([]byte("page"), randomBytes(10000))
+	c.PutNegative([]byte("page"), 0)
+	assert.Equal(t, uint64(1), c.GetTotal())
+	_, result := c.Lookup([]byte("page"), nil)
+	assert.Equal(t, LookupNegative, result)
+
+	c.Put([]byte("page"), randomBytes(10000))
+	assert.Equal(t, nil, c.PutPinned([]byte("page"), []byte("pinned")))
+	assert.Equal(t, uint64(1), c.GetTotal())
+
+	c.Put([]byte("page"), randomBytes(10000))
+	assert.Equal(t, nil, c.PutFrom([]byte("page"), bytes.NewReader([]byte("stream")), 6))
+	assert.Equal(t, uint64(1), c.GetTotal())
+
+	c.Put([]byte("page"), randomBytes(10000))
+	assert.Equal(t, nil, c.PutFrom([]byte("page"), bytes.NewReader(make([]byte, 10000)), 10000))
+	assert.Equal(t, uint64(1+3), c.GetTotal())
+}
+
+func TestCache_Put_Chunked_With_TTL(t *testing.T) {
+	clock := bigcachetest.NewFakeClock(0)
+	c := New(4, 1<<14, WithClock(clock))
+
+	value := randomBytes(9000)
+	c.PutWithStaleTTL([]byte("page"), value, 10*time.Second, 20*time.Second)
+
+	data := make([]byte, 9000)
+	n, result := c.Lookup([]byte("page"), data)
+	assert.Equal(t, LookupHit, result)
+	assert.Equal(t, value, data[:n])
+
+	clock.Advance(10 * time.Second)
+	n, result = c.Lookup([]byte("page"), data)
+	assert.Equal(t, LookupStale, result)
+	assert.Equal(t, value, data[:n])
+
+	clock.Advance(10 * time.Second)
+	_, result = c.Lookup([]byte("page"), data)
+	assert.Equal(t, LookupMiss, result)
+}
+
+func TestCache_Put_Chunked_Typed(t *testing.T) {
+	c := NewTyped[string, []byte](New(4, 1<<14), StringCodec{}, bytesTestCodec{})
+
+	value := randomBytes(9000)
+	assert.Equal(t, nil, c.Put("page", value))
+
+	result, ok, err := c.Get("page")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, value, result)
+}
+
+func TestCache_Put_Chunked_Resize(t *testing.T) {
+	c := New(4, 1<<14)
+	assert.Equal(t, nil, c.Resize(1<<15))
+	assert.Equal(t, 1<<11, c.getChunkSize())
+}
+
+type bytesTestCodec struct {
+}
+
+func (bytesTestCodec) AppendValue(dst []byte, value []byte) ([]byte, error) {
+	return append(dst, value...), nil
+}
+
+func (bytesTestCodec) DecodeValue(data []byte) ([]byte, error) {
+	return append([]byte(nil), data...), nil
+}
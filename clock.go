@@ -0,0 +1,44 @@
+package bigcache
+
+import (
+	"github.com/QuangTung97/bigcache/memhash"
+	"time"
+)
+
+// Clock is the source of time of the cache, used for access times and expirations
+type Clock interface {
+	// NanoTime returns the current time in nanoseconds from a monotonic clock
+	NanoTime() int64
+}
+
+type monoClock struct {
+}
+
+func (monoClock) NanoTime() int64 {
+	return memhash.NanoTime()
+}
+
+// newMonoGetNow returns the number of ticks of the resolution since the creation of the function.
+// With a resolution of 1ms, the uint32 value wraps around after about 49 days, ages stay correct up to that long
+func newMonoGetNow(nanoTime func() int64, resolution time.Duration) func() uint32 {
+	start := nanoTime()
+	return func() uint32 {
+		return uint32((nanoTime() - start) / int64(resolution))
+	}
+}
+
+// newLogicalGetNow returns a counter that is increased on every call
+func newLogicalGetNow() func() uint32 {
+	now := uint32(0)
+	return func() uint32 {
+		now++
+		return now
+	}
+}
+
+func newSegmentGetNow(opts *cacheOptions) func() uint32 {
+	if opts.logicalAccessTime {
+		return newLogicalGetNow()
+	}
+	return newMonoGetNow(opts.clock.NanoTime, opts.accessTimeResolution)
+}
@@ -0,0 +1,136 @@
+package bigcache
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestNewMonoGetNow_Resolution(t *testing.T) {
+	nano := int64(5 * time.Hour)
+	nanoTime := func() int64 { return nano }
+
+	getNow := newMonoGetNow(nanoTime, time.Millisecond)
+	assert.Equal(t, uint32(0), getNow())
+
+	nano += int64(1500 * time.Microsecond)
+	assert.Equal(t, uint32(1), getNow())
+
+	nano += int64(2 * time.Second)
+	assert.Equal(t, uint32(2001), getNow())
+}
+
+func TestNewMonoGetNow_Default_Second(t *testing.T) {
+	nano := int64(0)
+	getNow := newMonoGetNow(func() int64 { return nano }, newCacheOptions().accessTimeResolution)
+
+	nano += int64(999 * time.Millisecond)
+	assert.Equal(t, uint32(0), getNow())
+
+	nano += int64(time.Millisecond)
+	assert.Equal(t, uint32(1), getNow())
+}
+
+func TestNewLogicalGetNow(t *testing.T) {
+	getNow := newLogicalGetNow()
+	assert.Equal(t, uint32(1), getNow())
+	assert.Equal(t, uint32(2), getNow())
+	assert.Equal(t, uint32(3), getNow())
+
+	other := newLogicalGetNow()
+	assert.Equal(t, uint32(1), other())
+}
+
+func TestWithAccessTimeResolution_Panic(t *testing.T) {
+	assert.PanicsWithValue(t, "access time resolution must be > 0", func() {
+		newCacheOptions(WithAccessTimeResolution(0))
+	})
+}
+
+func TestAccessTimePolicy_No_Overflow(t *testing.T) {
+	p := AccessTimePolicy{}
+	assert.Equal(t, EvictionDrop, p.Decide(EvictionEntry{
+		Age:             1<<32 - 1,
+		SegmentTotal:    1 << 33,
+		SegmentTotalAge: 1 << 63,
+	}))
+	assert.Equal(t, EvictionRelocate, p.Decide(EvictionEntry{
+		Age:             1<<31 - 1,
+		SegmentTotal:    1 << 32,
+		SegmentTotalAge: 1 << 63,
+	}))
+}
+
+func TestSegment_Millisecond_Resolution_Distinguish_Burst(t *testing.T) {
+	const entrySize = entryHeaderSize + 8
+
+	run := func(resolution time.Duration) bool {
+		nano := int64(0)
+		s := newSegmentSize(entrySize * 4)
+		s.getNow = newMonoGetNow(func() int64 { return nano }, resolution)
+
+		// all in the same second, 10ms apart
+		for i := 0; i < 4; i++ {
+			nano += int64(10 * time.Millisecond)
+			s.put(uint32(40+i), []byte{1, 2, uint8(i)}, []byte{101, 102, 103, 100})
+		}
+
+		data := make([]byte, 100)
+		nano += int64(10 * time.Millisecond)
+		s.getAndApply(41, []byte{1, 2, 1}, data)
+
+		nano += int64(10 * time.Millisecond)
+		s.put(50, []byte{5, 5, 5}, []byte{101, 102, 103, 100})
+
+		assert.Equal(t, s.totalAge, s.getSumTotalAge())
+
+		_, ok := s.getAndApply(41, []byte{1, 2, 1}, data)
+		return ok
+	}
+
+	// with seconds, every entry has the same access time and 41 is not recognized as hot
+	assert.Equal(t, false, run(time.Second))
+	assert.Equal(t, true, run(time.Millisecond))
+}
+
+func TestCache_Logical_Access_Time(t *testing.T) {
+	c := New(1, 1024, WithLogicalAccessTime())
+	c.Put([]byte{1}, []byte{10})
+	c.Put([]byte{2}, []byte{20})
+	c.Get([]byte{1}, nil)
+
+	s, hash := c.getSegment([]byte{1})
+	assert.Equal(t, uint32(1), s.getHeader(uint32(hash)).accessTime)
+	assert.Equal(t, uint64(1+0), s.totalAge)
+
+	s.drainReads()
+	assert.Equal(t, uint32(3), s.getHeader(uint32(hash)).accessTime)
+	assert.Equal(t, uint64(0+1), s.totalAge)
+}
+
+func TestSegment_Access_Time_Wrap_Around(t *testing.T) {
+	const entrySize = entryHeaderSize + 8
+	s := newSegmentSize(entrySize * 4)
+	now := uint32(1<<32 - 3)
+	s.getNow = func() uint32 {
+		now++
+		return now
+	}
+
+	for i := 0; i < 4; i++ {
+		s.put(uint32(40+i), []byte{1, 2, uint8(i)}, []byte{101, 102, 103, 100})
+	}
+
+	// accessed after the access time wrapped around
+	data := make([]byte, 100)
+	s.getAndApply(40, []byte{1, 2, 0}, data)
+	assert.Less(t, s.getHeader(40).accessTime, uint32(10))
+
+	s.put(50, []byte{5, 5, 5}, []byte{101, 102, 103, 100})
+	assert.Equal(t, s.totalAge, s.getSumTotalAge())
+
+	_, ok := s.getAndApply(40, []byte{1, 2, 0}, data)
+	assert.Equal(t, true, ok)
+	_, ok = s.getAndApply(41, []byte{1, 2, 1}, data)
+	assert.Equal(t, false, ok)
+}
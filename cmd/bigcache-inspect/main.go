@@ -0,0 +1,382 @@
+// Command bigcache-inspect prints the content of a cache saved by bigcache.Open or of a log or a snapshot
+// written by bigcache.WAL, without opening the cache.
+//
+// Usage:
+//
+//	bigcache-inspect stats <path>
+//	bigcache-inspect keys [-all] [-grep regexp] <path>
+//	bigcache-inspect export [-all] [-grep regexp] <path>
+//
+// The path is either the directory of a cache or the path of a log or a snapshot file.
+// The cache must not be opened while inspected.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	_ "github.com/QuangTung97/bigcache" // registers the readers of the files
+	"github.com/QuangTung97/bigcache/internal/inspect"
+	"io"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+)
+
+const usage = `usage:
+  bigcache-inspect stats <path>
+  bigcache-inspect keys [-all] [-grep regexp] <path>
+  bigcache-inspect export [-all] [-grep regexp] <path>
+
+path is the directory of a cache or a log or snapshot file of a WAL`
+
+var errUsage = errors.New(usage)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdout io.Writer) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	cmd := args[0]
+	opts, err := parseOptions(cmd, args[1:])
+	if err != nil {
+		return err
+	}
+
+	switch cmd {
+	case "stats":
+		if opts.all || opts.pattern != nil {
+			return errUsage
+		}
+		return printStats(opts.path, stdout)
+	case "keys":
+		return printKeys(opts, stdout)
+	case "export":
+		return exportEntries(opts, stdout)
+	default:
+		return errUsage
+	}
+}
+
+func printKeys(opts *options, stdout io.Writer) error {
+	return walkEntries(opts.path, func(e *entry) error {
+		if !opts.match(e) {
+			return nil
+		}
+		_, err := fmt.Fprintln(stdout, formatKey(e.key))
+		return err
+	})
+}
+
+func exportEntries(opts *options, stdout io.Writer) error {
+	encoder := json.NewEncoder(stdout)
+	return walkEntries(opts.path, func(e *entry) error {
+		if !opts.match(e) {
+			return nil
+		}
+		return encoder.Encode(newExportedEntry(e))
+	})
+}
+
+// options are the flags and the path of a command
+type options struct {
+	path    string
+	all     bool           // include deleted and expired entries
+	pattern *regexp.Regexp // nil to match all keys
+}
+
+func parseOptions(cmd string, args []string) (*options, error) {
+	flags := flag.NewFlagSet(cmd, flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	all := flags.Bool("all", false, "include deleted and expired entries")
+	grep := flags.String("grep", "", "only the keys matching the regexp")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		return nil, errUsage
+	}
+
+	opts := &options{path: flags.Arg(0), all: *all}
+	if *grep != "" {
+		pattern, err := regexp.Compile(*grep)
+		if err != nil {
+			return nil, err
+		}
+		opts.pattern = pattern
+	}
+	return opts, nil
+}
+
+// match returns true if the entry is printed by keys and export
+func (o *options) match(e *entry) bool {
+	if !o.all && (e.isDead() || e.chunk) {
+		return false
+	}
+	return o.pattern == nil || o.pattern.Match(e.key)
+}
+
+// entry is an entry of a cache or a record of a WAL file
+type entry struct {
+	segment int // -1 for the records of WAL files
+	offset  int
+	key     []byte
+	value   []byte
+	ttl     time.Duration
+	deleted bool
+	expired bool
+	chunk   bool
+	flags   []string
+}
+
+func (e *entry) isDead() bool {
+	return e.deleted || e.expired
+}
+
+func isCacheDir(path string) bool {
+	_, err := os.Stat(filepath.Join(path, "bigcache.meta"))
+	return err == nil
+}
+
+func walkEntries(path string, fn func(e *entry) error) error {
+	if !isCacheDir(path) {
+		return walkWALFile(path, fn)
+	}
+
+	f, err := inspect.OpenCacheFile(path)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < f.NumSegments(); i++ {
+		err := f.WalkSegment(i, func(fe *inspect.Entry) error {
+			return fn(newCacheEntry(i, fe))
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newCacheEntry(segment int, fe *inspect.Entry) *entry {
+	e := &entry{
+		segment: segment,
+		offset:  fe.Offset,
+		key:     fe.Key,
+		value:   fe.Value,
+		ttl:     fe.TTL,
+		deleted: fe.Deleted,
+		expired: fe.Expired,
+		chunk:   fe.Chunk,
+	}
+	addFlag := func(set bool, name string) {
+		if set {
+			e.flags = append(e.flags, name)
+		}
+	}
+	addFlag(fe.Deleted, "deleted")
+	addFlag(fe.Expired, "expired")
+	addFlag(fe.Pinned, "pinned")
+	addFlag(fe.Negative, "negative")
+	addFlag(fe.Chunked, "chunked")
+	addFlag(fe.Chunk, "chunk")
+	addFlag(fe.Compressed, "compressed")
+	addFlag(fe.KeyID != 0, "encrypted")
+	addFlag(fe.Namespace != 0, "namespace:"+strconv.Itoa(int(fe.Namespace)))
+	return e
+}
+
+func walkWALFile(path string, fn func(e *entry) error) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	return inspect.ReadWALFile(path, func(r *inspect.WALRecord) error {
+		e := &entry{
+			segment: -1,
+			key:     r.Key,
+			value:   r.Value,
+			deleted: r.Delete,
+		}
+		if r.Delete {
+			e.flags = append(e.flags, "deleted")
+		}
+		if r.KeyID != 0 {
+			e.flags = append(e.flags, "encrypted")
+		}
+		if !r.ExpireAt.IsZero() {
+			e.ttl = time.Until(r.ExpireAt).Truncate(time.Second)
+			if e.ttl <= 0 {
+				e.ttl = 0
+				e.expired = true
+				e.flags = append(e.flags, "expired")
+			}
+		}
+		return fn(e)
+	})
+}
+
+// formatKey returns the key as is if printable, otherwise quoted
+func formatKey(key []byte) string {
+	s := string(key)
+	if utf8.ValidString(s) && strconv.Quote(s) == `"`+s+`"` {
+		return s
+	}
+	return strconv.Quote(s)
+}
+
+type exportedEntry struct {
+	Segment *int     `json:"segment,omitempty"`
+	Offset  *int     `json:"offset,omitempty"`
+	Key     []byte   `json:"key"`
+	Value   []byte   `json:"value"`
+	TTL     int64    `json:"ttl,omitempty"` // in seconds
+	Flags   []string `json:"flags,omitempty"`
+}
+
+func newExportedEntry(e *entry) exportedEntry {
+	result := exportedEntry{
+		Key:   e.key,
+		Value: e.value,
+		TTL:   int64(e.ttl / time.Second),
+		Flags: e.flags,
+	}
+	if e.segment >= 0 {
+		segment, offset := e.segment, e.offset
+		result.Segment = &segment
+		result.Offset = &offset
+	}
+	return result
+}
+
+// histogram counts sizes in power of two buckets, bucket i for the sizes in [2^(i-1), 2^i)
+type histogram [33]uint64
+
+func (h *histogram) add(size int) {
+	h[bits.Len32(uint32(size))]++
+}
+
+func (h *histogram) print(w io.Writer) {
+	for i, count := range h {
+		if count == 0 {
+			continue
+		}
+		low, high := 0, 0
+		if i > 0 {
+			low, high = 1<<(i-1), 1<<i-1
+		}
+		fmt.Fprintf(w, "  %d-%d\t%d\n", low, high, count)
+	}
+}
+
+type segmentStats struct {
+	capacity  int
+	used      int
+	entries   int
+	dead      int
+	deadBytes int
+}
+
+func (s segmentStats) print(w io.Writer, name string) {
+	fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%d\t%d\t%s\n", name, s.capacity, s.used, percent(s.used, s.capacity),
+		s.entries, s.dead, percent(s.deadBytes, s.used))
+}
+
+func percent(n int, total int) string {
+	if total == 0 {
+		return "0.0%"
+	}
+	return fmt.Sprintf("%.1f%%", float64(n)*100/float64(total))
+}
+
+func printStats(path string, stdout io.Writer) error {
+	if !isCacheDir(path) {
+		return printWALStats(path, stdout)
+	}
+
+	f, err := inspect.OpenCacheFile(path)
+	if err != nil {
+		return err
+	}
+
+	var keySizes, valueSizes histogram
+	var total segmentStats
+
+	w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "segments: %d, clean shutdown: %t\n\n", f.NumSegments(), f.IsClean())
+	fmt.Fprintln(w, "SEGMENT\tCAPACITY\tUSED\tOCCUPANCY\tENTRIES\tDEAD\tDEAD RATIO")
+	for i := 0; i < f.NumSegments(); i++ {
+		info := f.Segment(i)
+		s := segmentStats{capacity: info.Capacity, used: info.Used}
+		err := f.WalkSegment(i, func(e *inspect.Entry) error {
+			if e.Deleted || e.Expired {
+				s.dead++
+				s.deadBytes += e.Size
+				return nil
+			}
+			if e.Chunk {
+				return nil
+			}
+			s.entries++
+			keySizes.add(len(e.Key))
+			valueSizes.add(len(e.Value))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		s.print(w, strconv.Itoa(i))
+
+		total.capacity += s.capacity
+		total.used += s.used
+		total.entries += s.entries
+		total.dead += s.dead
+		total.deadBytes += s.deadBytes
+	}
+	total.print(w, "total")
+
+	printHistograms(w, &keySizes, &valueSizes)
+	return w.Flush()
+}
+
+func printWALStats(path string, stdout io.Writer) error {
+	var keySizes, valueSizes histogram
+	puts, deletes, expired := 0, 0, 0
+	err := walkWALFile(path, func(e *entry) error {
+		switch {
+		case e.deleted:
+			deletes++
+			return nil
+		case e.expired:
+			expired++
+		default:
+			puts++
+		}
+		keySizes.add(len(e.key))
+		valueSizes.add(len(e.value))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "puts: %d, expired puts: %d, deletes: %d\n", puts, expired, deletes)
+	printHistograms(w, &keySizes, &valueSizes)
+	return w.Flush()
+}
+
+func printHistograms(w io.Writer, keySizes *histogram, valueSizes *histogram) {
+	fmt.Fprintln(w, "\nkey sizes:")
+	keySizes.print(w)
+	fmt.Fprintln(w, "\nvalue sizes:")
+	valueSizes.print(w)
+}
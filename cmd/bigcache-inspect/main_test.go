@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/QuangTung97/bigcache"
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestCacheDir(t *testing.T) string {
+	if _, err := (bigcache.MmapAllocator{}).Alloc(0, 4096); err == bigcache.ErrMmapNotSupported {
+		t.Skip(err)
+	}
+
+	dir := t.TempDir()
+	c, err := bigcache.Open(dir, 2, 1<<14)
+	assert.Equal(t, nil, err)
+	for i := 0; i < 20; i++ {
+		c.Put([]byte(fmt.Sprintf("key:%02d", i)), []byte(fmt.Sprintf("value:%d", i)))
+	}
+	c.PutWithTTL([]byte("ttl"), []byte("value"), time.Hour)
+	c.Put([]byte("binary\x00"), []byte("value"))
+	assert.Equal(t, nil, c.Close())
+	return dir
+}
+
+func runTest(t *testing.T, args ...string) string {
+	var out bytes.Buffer
+	assert.Equal(t, nil, run(args, &out))
+	return out.String()
+}
+
+func TestRun_Stats(t *testing.T) {
+	out := runTest(t, "stats", newTestCacheDir(t))
+
+	assert.Contains(t, out, "segments: 2, clean shutdown: true")
+	assert.Contains(t, out, "SEGMENT  CAPACITY  USED")
+	assert.Regexp(t, `total\s+32768\s+\d+\s+[\d.]+%\s+22\s+0\s+0.0%`, out)
+	assert.Regexp(t, `key sizes:\n  2-3\s+1\n  4-7\s+21\n`, out)
+	assert.Regexp(t, `value sizes:\n  4-7\s+12\n  8-15\s+10\n`, out)
+}
+
+func TestRun_Keys(t *testing.T) {
+	dir := newTestCacheDir(t)
+
+	keys := strings.Split(strings.TrimSpace(runTest(t, "keys", dir)), "\n")
+	assert.Equal(t, 22, len(keys))
+	assert.Contains(t, keys, `"binary\x00"`)
+
+	keys = strings.Split(strings.TrimSpace(runTest(t, "keys", "-grep", "^key:1", dir)), "\n")
+	assert.ElementsMatch(t, []string{
+		"key:10", "key:11", "key:12", "key:13", "key:14",
+		"key:15", "key:16", "key:17", "key:18", "key:19",
+	}, keys)
+}
+
+func TestRun_Export(t *testing.T) {
+	out := runTest(t, "export", "-grep", "^ttl$", newTestCacheDir(t))
+
+	var e exportedEntry
+	assert.Equal(t, nil, json.Unmarshal([]byte(out), &e))
+	assert.Equal(t, "ttl", string(e.Key))
+	assert.Equal(t, "value", string(e.Value))
+	assert.Greater(t, e.TTL, int64(3590))
+	assert.NotNil(t, e.Segment)
+	assert.NotNil(t, e.Offset)
+	assert.Contains(t, out, `"value":"dmFsdWU="`)
+}
+
+func TestRun_WAL_File(t *testing.T) {
+	dir := t.TempDir()
+	w, err := bigcache.OpenWAL(dir, bigcache.New(2, 1<<14), 0, time.Hour)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, w.Put([]byte("key01"), []byte("value01")))
+	assert.Equal(t, nil, w.Put([]byte("key02"), []byte("value02")))
+	_, err = w.Delete([]byte("key01"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, w.Close())
+
+	path := filepath.Join(dir, "wal-0000000000000001.log")
+	assert.Equal(t, "key01\nkey02\n", runTest(t, "keys", path))
+	assert.Equal(t, "key01\nkey02\nkey01\n", runTest(t, "keys", "-all", path))
+	assert.Equal(t, `{"key":"a2V5MDI=","value":"dmFsdWUwMg=="}`+"\n", runTest(t, "export", "-grep", "02", path))
+	assert.Contains(t, runTest(t, "stats", path), "puts: 2, expired puts: 0, deletes: 1")
+}
+
+func TestRun_Usage(t *testing.T) {
+	assert.Equal(t, errUsage, run(nil, &bytes.Buffer{}))
+	assert.Equal(t, errUsage, run([]string{"unknown", "dir"}, &bytes.Buffer{}))
+	assert.Equal(t, errUsage, run([]string{"keys"}, &bytes.Buffer{}))
+	assert.Equal(t, errUsage, run([]string{"stats", "-grep", "a", "dir"}, &bytes.Buffer{}))
+	assert.Error(t, run([]string{"keys", filepath.Join(t.TempDir(), "not-found")}, &bytes.Buffer{}))
+}
@@ -0,0 +1,156 @@
+package bigcache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+)
+
+// KeyCodec converts keys of type K to the bytes stored in the cache
+type KeyCodec[K any] interface {
+	// AppendKey appends the encoded key to dst and returns the extended buffer
+	AppendKey(dst []byte, key K) []byte
+}
+
+// ValueCodec converts values of type V to and from the bytes stored in the cache
+type ValueCodec[V any] interface {
+	// AppendValue appends the encoded value to dst and returns the extended buffer
+	AppendValue(dst []byte, value V) ([]byte, error)
+
+	// DecodeValue decodes a value, data must not be retained after returning
+	DecodeValue(data []byte) (V, error)
+}
+
+// Integer is the set of integer types supported by IntegerCodec
+type Integer interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr
+}
+
+// ErrInvalidEncoding is returned when the cached bytes can not be decoded
+var ErrInvalidEncoding = errors.New("bigcache: invalid encoding")
+
+// StringCodec stores strings as their raw bytes
+type StringCodec struct {
+}
+
+var _ KeyCodec[string] = StringCodec{}
+var _ ValueCodec[string] = StringCodec{}
+
+// AppendKey ...
+func (StringCodec) AppendKey(dst []byte, key string) []byte {
+	return append(dst, key...)
+}
+
+// AppendValue ...
+func (StringCodec) AppendValue(dst []byte, value string) ([]byte, error) {
+	return append(dst, value...), nil
+}
+
+// DecodeValue ...
+func (StringCodec) DecodeValue(data []byte) (string, error) {
+	return string(data), nil
+}
+
+// IntegerCodec stores integers as 8 bytes in big endian order
+type IntegerCodec[T Integer] struct {
+}
+
+var _ KeyCodec[int64] = IntegerCodec[int64]{}
+var _ ValueCodec[int64] = IntegerCodec[int64]{}
+
+// AppendKey ...
+func (IntegerCodec[T]) AppendKey(dst []byte, key T) []byte {
+	var data [8]byte
+	binary.BigEndian.PutUint64(data[:], uint64(key))
+	return append(dst, data[:]...)
+}
+
+// AppendValue ...
+func (c IntegerCodec[T]) AppendValue(dst []byte, value T) ([]byte, error) {
+	return c.AppendKey(dst, value), nil
+}
+
+// DecodeValue ...
+func (IntegerCodec[T]) DecodeValue(data []byte) (T, error) {
+	if len(data) != 8 {
+		return 0, ErrInvalidEncoding
+	}
+	return T(binary.BigEndian.Uint64(data)), nil
+}
+
+// confusing-naming reports the methods of generic receivers as functions of the same name
+//revive:disable:confusing-naming
+
+// BinaryCodec stores fixed size values (see encoding/binary) in little endian order
+type BinaryCodec[V any] struct {
+}
+
+var _ ValueCodec[int32] = BinaryCodec[int32]{}
+
+// AppendValue ...
+func (BinaryCodec[V]) AppendValue(dst []byte, value V) ([]byte, error) {
+	w := appendWriter{buf: dst}
+	err := binary.Write(&w, binary.LittleEndian, &value)
+	return w.buf, err
+}
+
+// DecodeValue ...
+func (BinaryCodec[V]) DecodeValue(data []byte) (V, error) {
+	var value V
+	err := binary.Read(bytes.NewReader(data), binary.LittleEndian, &value)
+	return value, err
+}
+
+// JSONCodec stores values using encoding/json
+type JSONCodec[V any] struct {
+}
+
+var _ ValueCodec[struct{}] = JSONCodec[struct{}]{}
+
+// AppendValue ...
+func (JSONCodec[V]) AppendValue(dst []byte, value V) ([]byte, error) {
+	w := appendWriter{buf: dst}
+	err := json.NewEncoder(&w).Encode(value)
+	return w.buf, err
+}
+
+// DecodeValue ...
+func (JSONCodec[V]) DecodeValue(data []byte) (V, error) {
+	var value V
+	err := json.Unmarshal(data, &value)
+	return value, err
+}
+
+// GobCodec stores values using encoding/gob, every value is encoded with its own type information
+type GobCodec[V any] struct {
+}
+
+var _ ValueCodec[struct{}] = GobCodec[struct{}]{}
+
+// AppendValue ...
+func (GobCodec[V]) AppendValue(dst []byte, value V) ([]byte, error) {
+	w := appendWriter{buf: dst}
+	err := gob.NewEncoder(&w).Encode(&value)
+	return w.buf, err
+}
+
+// DecodeValue ...
+func (GobCodec[V]) DecodeValue(data []byte) (V, error) {
+	var value V
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&value)
+	return value, err
+}
+
+//revive:enable:confusing-naming
+
+type appendWriter struct {
+	buf []byte
+}
+
+func (w *appendWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	return len(p), nil
+}
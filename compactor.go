@@ -0,0 +1,84 @@
+package bigcache
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+const defaultCompactorStepSize = 64
+
+// Compactor is a background goroutine removing the holes left by deleted entries. When the ratio of
+// dead bytes in a segment reaches the threshold, live entries are moved forward in small steps,
+// the segment lock is held for at most stepSize entries at a time
+type Compactor struct {
+	cache     *Cache
+	threshold float64
+	stepSize  int
+	worker    *worker
+
+	compactedCount uint64
+}
+
+// NewCompactor creates a compactor checking the cache every interval,
+// threshold is the ratio of dead bytes in (0, 1], stepSize <= 0 means the default (64)
+func NewCompactor(cache *Cache, interval time.Duration, threshold float64, stepSize int) *Compactor {
+	if threshold <= 0 || threshold > 1 {
+		panic("compactor threshold must be in (0, 1]")
+	}
+	if stepSize <= 0 {
+		stepSize = defaultCompactorStepSize
+	}
+	c := &Compactor{
+		cache:     cache,
+		threshold: threshold,
+		stepSize:  stepSize,
+	}
+	c.worker = newWorker("compactor", interval, c.compact)
+	return c
+}
+
+// Start runs the compactor in a new goroutine until ctx is cancelled or Close is called
+func (c *Compactor) Start(ctx context.Context) {
+	c.worker.start(ctx)
+}
+
+// Close stops the compactor and waits for the goroutine to finish
+func (c *Compactor) Close() {
+	c.worker.close()
+}
+
+// GetCompactedCount returns the number of segment compactions completed
+func (c *Compactor) GetCompactedCount() uint64 {
+	return atomic.LoadUint64(&c.compactedCount)
+}
+
+// compact compacts every segment having enough dead bytes
+func (c *Compactor) compact(ctx context.Context) {
+	for i := range c.cache.segments {
+		seg := &c.cache.segments[i]
+
+		seg.mu.Lock()
+		started := seg.startCompaction(c.threshold)
+		seg.mu.Unlock()
+
+		if !started {
+			continue
+		}
+
+		for {
+			if ctx.Err() != nil {
+				return
+			}
+
+			seg.mu.Lock()
+			done := seg.compactStep(c.stepSize)
+			seg.mu.Unlock()
+
+			if done {
+				atomic.AddUint64(&c.compactedCount, 1)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package bigcache
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestCompactor_Compact(t *testing.T) {
+	c := New(2, 1<<16)
+
+	for i := 0; i < 200; i++ {
+		c.Put([]byte{1, 2, uint8(i)}, []byte{10, 20, 30, uint8(i)})
+	}
+	for i := 0; i < 150; i++ {
+		c.Delete([]byte{1, 2, uint8(i)})
+	}
+	assert.Equal(t, uint64(150*(entryHeaderSize+8)), c.GetDeadBytes())
+
+	compactor := NewCompactor(c, time.Second, 0.01, 7)
+	compactor.compact(context.Background())
+
+	assert.Equal(t, uint64(0), c.GetDeadBytes())
+	assert.Equal(t, uint64(50), c.GetTotal())
+	assert.Equal(t, uint64(2), compactor.GetCompactedCount())
+
+	value := make([]byte, 10)
+	for i := 150; i < 200; i++ {
+		n, ok := c.Get([]byte{1, 2, uint8(i)}, value)
+		assert.Equal(t, true, ok)
+		assert.Equal(t, []byte{10, 20, 30, uint8(i)}, value[:n])
+	}
+
+	compactor.compact(context.Background())
+	assert.Equal(t, uint64(2), compactor.GetCompactedCount())
+}
+
+func TestCompactor_Start_Close(t *testing.T) {
+	c := New(1, 1<<16)
+	c.Put([]byte{1, 2, 3}, []byte{10})
+	c.Delete([]byte{1, 2, 3})
+
+	compactor := NewCompactor(c, time.Millisecond, 0.0001, 0)
+	compactor.Start(context.Background())
+
+	assert.Eventually(t, func() bool {
+		return c.GetDeadBytes() == 0
+	}, time.Second, time.Millisecond)
+	compactor.Close()
+
+	assert.Equal(t, uint64(1), compactor.GetCompactedCount())
+}
+
+func TestNewCompactor_Panic(t *testing.T) {
+	c := New(1, 1024)
+	assert.PanicsWithValue(t, "compactor threshold must be in (0, 1]", func() {
+		NewCompactor(c, time.Second, 0, 0)
+	})
+	assert.PanicsWithValue(t, "compactor interval must be > 0", func() {
+		NewCompactor(c, 0, 0.5, 0)
+	})
+}
@@ -0,0 +1,120 @@
+package bigcache
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// lookupCompressed is set on the result of a segment lookup when the value is compressed,
+// it is never returned by Cache.Lookup
+const lookupCompressed LookupResult = 1 << 9
+
+const lookupFlagMask = lookupChunked | lookupCompressed | lookupKeyIDMask
+
+// Compressor compresses the values of the cache (see WithCompression)
+type Compressor interface {
+	// Compress appends the compressed data of src to dst
+	Compress(dst []byte, src []byte) ([]byte, error)
+	// Decompress appends the decompressed data of src to dst
+	Decompress(dst []byte, src []byte) ([]byte, error)
+}
+
+// FlateCompressor is a Compressor using compress/flate, the writers and readers are reused
+type FlateCompressor struct {
+	level   int
+	writers sync.Pool
+	readers sync.Pool
+}
+
+var _ Compressor = &FlateCompressor{}
+
+// NewFlateCompressor creates a FlateCompressor with a compression level of compress/flate
+func NewFlateCompressor(level int) *FlateCompressor {
+	if level < flate.HuffmanOnly || level > flate.BestCompression {
+		panic("invalid flate compression level")
+	}
+	return &FlateCompressor{level: level}
+}
+
+// Compress ...
+func (f *FlateCompressor) Compress(dst []byte, src []byte) ([]byte, error) {
+	w := &appendWriter{buf: dst}
+
+	fw, ok := f.writers.Get().(*flate.Writer)
+	if ok {
+		fw.Reset(w)
+	} else {
+		fw, _ = flate.NewWriter(w, f.level)
+	}
+
+	_, err := fw.Write(src)
+	if err == nil {
+		err = fw.Close()
+	}
+	f.writers.Put(fw)
+	return w.buf, err
+}
+
+// Decompress ...
+func (f *FlateCompressor) Decompress(dst []byte, src []byte) ([]byte, error) {
+	r := bytes.NewReader(src)
+
+	fr, ok := f.readers.Get().(io.ReadCloser)
+	if ok {
+		_ = fr.(flate.Resetter).Reset(r, nil)
+	} else {
+		fr = flate.NewReader(r)
+	}
+	defer f.readers.Put(fr)
+
+	for {
+		if len(dst) == cap(dst) {
+			dst = append(dst, 0)[:len(dst)]
+		}
+		n, err := fr.Read(dst[len(dst):cap(dst)])
+		dst = dst[:len(dst)+n]
+		if err == io.EOF {
+			return dst, nil
+		}
+		if err != nil {
+			return dst, err
+		}
+	}
+}
+
+// compressValue returns the compressed value in buf if compressing is enabled and worth it
+func (c *Cache) compressValue(value []byte, buf *[]byte) ([]byte, bool) {
+	if c.compressor == nil || len(value) < c.compressionThreshold {
+		return nil, false
+	}
+
+	// the value is copied so that it does not escape through the interface call
+	src := c.compressionPool.get()
+	*src = append((*src)[:0], value...)
+	compressed, err := c.compressor.Compress((*buf)[:0], *src)
+	c.compressionPool.put(src)
+
+	if compressed != nil {
+		*buf = compressed
+	}
+	if err != nil || len(compressed) >= len(value) {
+		return nil, false
+	}
+
+	atomic.AddUint64(&c.compressionRawBytes, uint64(len(value)))
+	atomic.AddUint64(&c.compressionStoredBytes, uint64(len(compressed)))
+	return compressed, true
+}
+
+// GetCompressionRawBytes returns the total size before compression of the values stored compressed
+func (c *Cache) GetCompressionRawBytes() uint64 {
+	return atomic.LoadUint64(&c.compressionRawBytes)
+}
+
+// GetCompressionStoredBytes returns the total size after compression of the values stored compressed
+func (c *Cache) GetCompressionStoredBytes() uint64 {
+	return atomic.LoadUint64(&c.compressionStoredBytes)
+}
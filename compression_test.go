@@ -0,0 +1,213 @@
+package bigcache
+
+import (
+	"compress/flate"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+)
+
+func compressibleValue(n int) []byte {
+	type item struct {
+		ID    int    `json:"id"`
+		Name  string `json:"name"`
+		Email string `json:"email"`
+	}
+
+	var items []item
+	for i := 0; ; i++ {
+		items = append(items, item{ID: i, Name: fmt.Sprintf("user %d", i), Email: fmt.Sprintf("user%d@example.com", i)})
+		data, _ := json.Marshal(items)
+		if len(data) >= n {
+			return data[:n]
+		}
+	}
+}
+
+func newCompressionCache(segmentSize int) *Cache {
+	return New(4, segmentSize, WithCompression(NewFlateCompressor(flate.DefaultCompression), 128))
+}
+
+func TestFlateCompressor(t *testing.T) {
+	f := NewFlateCompressor(flate.BestSpeed)
+	value := compressibleValue(2000)
+
+	for i := 0; i < 3; i++ {
+		compressed, err := f.Compress([]byte{1, 2}, value)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, []byte{1, 2}, compressed[:2])
+		assert.Less(t, len(compressed), len(value)/3)
+
+		data, err := f.Decompress([]byte{3}, compressed[2:])
+		assert.Equal(t, nil, err)
+		assert.Equal(t, append([]byte{3}, value...), data)
+	}
+
+	_, err := f.Decompress(nil, []byte{1, 2, 3})
+	assert.Error(t, err)
+
+	assert.PanicsWithValue(t, "invalid flate compression level", func() {
+		NewFlateCompressor(10)
+	})
+}
+
+func TestCache_Compression(t *testing.T) {
+	c := newCompressionCache(1 << 14)
+	value := compressibleValue(2000)
+
+	c.Put([]byte("key01"), value)
+	seg, hash := c.getSegment([]byte("key01"))
+	assert.Equal(t, true, seg.getHeader(uint32(hash)).isCompressed())
+	assert.Less(t, int(seg.getHeader(uint32(hash)).valLen), 1000)
+
+	data := make([]byte, 3000)
+	n, ok := c.Get([]byte("key01"), data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, value, data[:n])
+
+	// buffer smaller than the compressed value
+	n, ok = c.Get([]byte("key01"), data[:10])
+	assert.Equal(t, true, ok)
+	assert.Equal(t, 2000, n)
+	assert.Equal(t, value[:10], data[:10])
+
+	assert.Equal(t, uint64(2000), c.GetCompressionRawBytes())
+	assert.Equal(t, uint64(seg.getHeader(uint32(hash)).valLen), c.GetCompressionStoredBytes())
+
+	// replaced by a value below the threshold
+	c.Put([]byte("key01"), []byte("small"))
+	assert.Equal(t, false, seg.getHeader(uint32(hash)).isCompressed())
+	n, ok = c.Get([]byte("key01"), data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "small", string(data[:n]))
+}
+
+func TestCache_Compression_Not_Compressible(t *testing.T) {
+	c := newCompressionCache(1 << 14)
+	value := randomBytes(1000)
+
+	c.Put([]byte("key01"), value)
+	seg, hash := c.getSegment([]byte("key01"))
+	assert.Equal(t, false, seg.getHeader(uint32(hash)).isCompressed())
+
+	data := make([]byte, 1000)
+	n, ok := c.Get([]byte("key01"), data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, value, data[:n])
+	assert.Equal(t, uint64(0), c.GetCompressionRawBytes())
+}
+
+func TestCache_Compression_Chunked(t *testing.T) {
+	c := newCompressionCache(1 << 14)
+	value := compressibleValue(50000)
+
+	c.Put([]byte("page"), value)
+	seg, hash := c.getSegment([]byte("page"))
+	assert.Equal(t, true, seg.getHeader(uint32(hash)).isChunked())
+
+	data := make([]byte, 50000)
+	n, ok := c.Get([]byte("page"), data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, value, data[:n])
+
+	r, ok := c.OpenReader([]byte("page"))
+	assert.Equal(t, true, ok)
+	data, err := io.ReadAll(r)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, value, data)
+}
+
+func TestCache_Compression_Namespace(t *testing.T) {
+	c := newCompressionCache(1 << 14)
+	ns := c.Namespace("ns", 1<<14)
+	value := compressibleValue(2000)
+
+	ns.Put([]byte("key01"), value)
+	assert.Less(t, ns.GetUsedBytes(), 1000)
+
+	data := make([]byte, 3000)
+	n, ok := ns.Get([]byte("key01"), data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, value, data[:n])
+}
+
+func TestCache_Compression_Pinned(t *testing.T) {
+	c := newCompressionCache(1 << 14)
+	value := compressibleValue(2000)
+
+	assert.Equal(t, nil, c.PutPinned([]byte("key01"), value))
+	seg, hash := c.getSegment([]byte("key01"))
+	assert.Equal(t, true, seg.getHeader(uint32(hash)).isCompressed())
+	assert.Equal(t, true, seg.getHeader(uint32(hash)).isPinned())
+	assert.Less(t, c.GetPinnedBytes(), uint64(1000))
+
+	data := make([]byte, 3000)
+	n, ok := c.Get([]byte("key01"), data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, value, data[:n])
+}
+
+func runCompressionPut(b *testing.B, c *Cache, value []byte) {
+	b.SetBytes(int64(len(value)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		c.Put([]byte(fmt.Sprintf("key:%d", n%1000)), value)
+	}
+	b.StopTimer()
+	reportCompression(b, c)
+}
+
+func runCompressionGet(b *testing.B, c *Cache, value []byte) {
+	for i := 0; i < 1000; i++ {
+		c.Put([]byte(fmt.Sprintf("key:%d", i)), value)
+	}
+	data := make([]byte, len(value))
+
+	b.SetBytes(int64(len(value)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		c.Get([]byte(fmt.Sprintf("key:%d", n%1000)), data)
+	}
+	b.StopTimer()
+	reportCompression(b, c)
+}
+
+// reportCompression reports the ratio of memory saved by compression
+func reportCompression(b *testing.B, c *Cache) {
+	raw := c.GetCompressionRawBytes()
+	if raw == 0 {
+		b.ReportMetric(0, "saved")
+		return
+	}
+	b.ReportMetric(1-float64(c.GetCompressionStoredBytes())/float64(raw), "saved")
+}
+
+func BenchmarkCompressionPut(b *testing.B) {
+	value := compressibleValue(4096)
+	b.Run("raw", func(b *testing.B) {
+		runCompressionPut(b, New(16, 1<<20), value)
+	})
+	for _, level := range []int{flate.BestSpeed, flate.DefaultCompression, flate.BestCompression} {
+		b.Run(fmt.Sprintf("flate-%d", level), func(b *testing.B) {
+			c := New(16, 1<<20, WithCompression(NewFlateCompressor(level), 128))
+			runCompressionPut(b, c, value)
+		})
+	}
+}
+
+func BenchmarkCompressionGet(b *testing.B) {
+	value := compressibleValue(4096)
+	b.Run("raw", func(b *testing.B) {
+		runCompressionGet(b, New(16, 1<<20), value)
+	})
+	for _, level := range []int{flate.BestSpeed, flate.DefaultCompression, flate.BestCompression} {
+		b.Run(fmt.Sprintf("flate-%d", level), func(b *testing.B) {
+			c := New(16, 1<<20, WithCompression(NewFlateCompressor(level), 128))
+			runCompressionGet(b, c, value)
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package bigcache
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// the key id of the entry is stored in these bits of the result of a segment lookup,
+// they are never returned by Cache.Lookup
+const (
+	lookupKeyIDShift                = 16
+	lookupKeyIDMask    LookupResult = 0xff << lookupKeyIDShift
+	lookupEncodingMask              = lookupCompressed | lookupKeyIDMask
+)
+
+const encryptionNonceSize = 12
+
+var (
+	// ErrInvalidKeyID is returned when adding a key with id 0
+	ErrInvalidKeyID = errors.New("bigcache: key id must not be 0")
+	// ErrKeyIDExisted is returned when adding a key with an id already used, retired ids can not be reused
+	ErrKeyIDExisted = errors.New("bigcache: key id already existed")
+	// ErrKeyIDNotFound is returned when activating or retiring a key that does not exist
+	ErrKeyIDNotFound = errors.New("bigcache: key id not found")
+	// ErrRetireActiveKey is returned when retiring the active key
+	ErrRetireActiveKey = errors.New("bigcache: can not retire the active key")
+)
+
+// Keyring contains the AES-GCM keys used to encrypt the values of the cache (see WithEncryption).
+// Values are encrypted with the active key, each entry records the id of its key.
+// To rotate keys: add a new key, activate it, then retire the old key, the entries encrypted
+// with a retired key are treated as misses
+type Keyring struct {
+	mu    sync.Mutex
+	state atomic.Value // *keyringState, copied on write
+
+	usedIDs [256]bool // including retired ids
+}
+
+type keyringState struct {
+	keys   [256]*encryptionKey
+	active uint8
+}
+
+type encryptionKey struct {
+	aead cipher.AEAD
+}
+
+// NewKeyring creates a keyring with an active key, key must be 16, 24 or 32 bytes to select AES-128, AES-192 or AES-256
+func NewKeyring(id uint8, key []byte) (*Keyring, error) {
+	k := &Keyring{}
+	k.state.Store(&keyringState{})
+	if err := k.AddKey(id, key); err != nil {
+		return nil, err
+	}
+	if err := k.SetActiveKey(id); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// AddKey adds a key without activating it
+func (k *Keyring) AddKey(id uint8, key []byte) error {
+	if id == 0 {
+		return ErrInvalidKeyID
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+
+	encKey := &encryptionKey{aead: aead}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.usedIDs[id] {
+		return ErrKeyIDExisted
+	}
+	k.usedIDs[id] = true
+
+	state := *k.getState()
+	state.keys[id] = encKey
+	k.state.Store(&state)
+	return nil
+}
+
+// SetActiveKey uses the key for encrypting new values
+func (k *Keyring) SetActiveKey(id uint8) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	state := *k.getState()
+	if state.keys[id] == nil {
+		return ErrKeyIDNotFound
+	}
+	state.active = id
+	k.state.Store(&state)
+	return nil
+}
+
+// RetireKey removes the key, entries encrypted with it become misses
+func (k *Keyring) RetireKey(id uint8) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	state := *k.getState()
+	if state.keys[id] == nil {
+		return ErrKeyIDNotFound
+	}
+	if state.active == id {
+		return ErrRetireActiveKey
+	}
+	state.keys[id] = nil
+	k.state.Store(&state)
+	return nil
+}
+
+func (k *Keyring) getState() *keyringState {
+	return k.state.Load().(*keyringState)
+}
+
+func (k *Keyring) hasKey(id uint8) bool {
+	return k.getState().keys[id] != nil
+}
+
+// encrypt appends the nonce and the sealed value to dst, the key of the entry is authenticated
+// so that values can not be swapped between entries. The nonce is random for every value: a counter would
+// repeat nonces under the same key after a restart of a persistent cache (see Open)
+func (k *Keyring) encrypt(dst []byte, value []byte, entryKey []byte) ([]byte, uint8) {
+	state := k.getState()
+	encKey := state.keys[state.active]
+
+	// the nonce is written directly into dst so that it does not escape
+	begin := len(dst)
+	dst = append(dst, make([]byte, encryptionNonceSize)...)
+	if _, err := rand.Read(dst[begin:]); err != nil {
+		panic("bigcache: read random nonce: " + err.Error())
+	}
+
+	return encKey.aead.Seal(dst, dst[begin:], value, entryKey), state.active
+}
+
+// decrypt appends the opened data to dst
+func (k *Keyring) decrypt(dst []byte, id uint8, data []byte, entryKey []byte) ([]byte, error) {
+	encKey := k.getState().keys[id]
+	if encKey == nil {
+		return dst, ErrKeyIDNotFound
+	}
+	if len(data) < encryptionNonceSize {
+		return dst, ErrInvalidEncoding
+	}
+	return encKey.aead.Open(dst, data[:encryptionNonceSize], data[encryptionNonceSize:], entryKey)
+}
+
+// encryptValue returns the encrypted value in buf if encryption is enabled
+func (c *Cache) encryptValue(value []byte, key []byte, buf *[]byte) ([]byte, uint8) {
+	// the value and the key are copied so that they do not escape through the interface calls
+	src := c.compressionPool.get()
+	*src = append(append((*src)[:0], key...), value...)
+	encrypted, keyID := c.keyring.encrypt((*buf)[:0], (*src)[len(key):], (*src)[:len(key)])
+	c.compressionPool.put(src)
+
+	*buf = encrypted
+	return encrypted, keyID
+}
+
+func (r LookupResult) keyID() uint8 {
+	return uint8((r & lookupKeyIDMask) >> lookupKeyIDShift)
+}
+
+// isRetired returns true if the entry is encrypted with a retired key, or with any key if encryption is disabled
+func (s *segment) isRetired(header *entryHeader) bool {
+	if header.keyID == 0 {
+		return false
+	}
+	return s.keyring == nil || !s.keyring.hasKey(header.keyID)
+}
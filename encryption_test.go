@@ -0,0 +1,209 @@
+package bigcache
+
+import (
+	"bytes"
+	"compress/flate"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+)
+
+func newTestKeyring(t *testing.T) *Keyring {
+	k, err := NewKeyring(1, bytes.Repeat([]byte{1}, 32))
+	assert.Equal(t, nil, err)
+	return k
+}
+
+func TestKeyring_Errors(t *testing.T) {
+	_, err := NewKeyring(0, bytes.Repeat([]byte{1}, 32))
+	assert.Equal(t, ErrInvalidKeyID, err)
+
+	_, err = NewKeyring(1, []byte{1, 2, 3})
+	assert.Error(t, err)
+
+	k := newTestKeyring(t)
+	assert.Equal(t, ErrKeyIDExisted, k.AddKey(1, bytes.Repeat([]byte{2}, 16)))
+	assert.Equal(t, ErrKeyIDNotFound, k.SetActiveKey(2))
+	assert.Equal(t, ErrKeyIDNotFound, k.RetireKey(2))
+	assert.Equal(t, ErrRetireActiveKey, k.RetireKey(1))
+
+	assert.Equal(t, nil, k.AddKey(2, bytes.Repeat([]byte{2}, 16)))
+	assert.Equal(t, nil, k.SetActiveKey(2))
+	assert.Equal(t, nil, k.RetireKey(1))
+
+	// retired ids can not be reused
+	assert.Equal(t, ErrKeyIDExisted, k.AddKey(1, bytes.Repeat([]byte{1}, 32)))
+}
+
+func TestKeyring_Random_Nonce(t *testing.T) {
+	k := newTestKeyring(t)
+
+	data1, _ := k.encrypt(nil, []byte("value01"), []byte("key01"))
+	data2, _ := k.encrypt(nil, []byte("value01"), []byte("key01"))
+	assert.NotEqual(t, data1[:encryptionNonceSize], data2[:encryptionNonceSize])
+
+	for _, data := range [][]byte{data1, data2} {
+		value, err := k.decrypt(nil, 1, data, []byte("key01"))
+		assert.Equal(t, nil, err)
+		assert.Equal(t, "value01", string(value))
+	}
+}
+
+func TestCache_Encrypted_Entry_Without_Keyring(t *testing.T) {
+	c := New(1, 1<<14)
+
+	// e.g. restored from a cache opened with encryption
+	seg, hash := c.getSegment([]byte("key01"))
+	seg.putWithParams(uint32(hash), []byte("key01"), []byte("encrypted"), putParams{keyID: 1})
+
+	_, ok := c.Get([]byte("key01"), nil)
+	assert.Equal(t, false, ok)
+	assert.Equal(t, uint64(0), c.GetTotal())
+	assert.Equal(t, nil, c.Verify())
+}
+
+func TestCache_Encryption(t *testing.T) {
+	c := New(4, 1<<14, WithEncryption(newTestKeyring(t)))
+
+	c.Put([]byte("key01"), []byte("value01"))
+
+	seg, hash := c.getSegment([]byte("key01"))
+	offset := seg.kv[uint32(hash)]
+	header := seg.getHeader(uint32(hash))
+	assert.Equal(t, uint8(1), header.keyID)
+
+	stored := make([]byte, header.valLen)
+	seg.rb.readAt(stored, header.valueOffset(offset))
+	assert.Equal(t, false, bytes.Contains(stored, []byte("value01")))
+
+	data := make([]byte, 20)
+	n, ok := c.Get([]byte("key01"), data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "value01", string(data[:n]))
+
+	n, ok = c.Get([]byte("key01"), nil)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, 7, n)
+
+	r, ok := c.OpenReader([]byte("key01"))
+	assert.Equal(t, true, ok)
+	data, err := io.ReadAll(r)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "value01", string(data))
+}
+
+func TestCache_Encryption_Key_Rotation(t *testing.T) {
+	k := newTestKeyring(t)
+	c := New(4, 1<<14, WithEncryption(k))
+
+	c.Put([]byte("key01"), []byte("value01"))
+
+	assert.Equal(t, nil, k.AddKey(2, bytes.Repeat([]byte{2}, 32)))
+	assert.Equal(t, nil, k.SetActiveKey(2))
+	c.Put([]byte("key02"), []byte("value02"))
+
+	seg, hash := c.getSegment([]byte("key02"))
+	assert.Equal(t, uint8(2), seg.getHeader(uint32(hash)).keyID)
+
+	// the old key still decrypts
+	data := make([]byte, 20)
+	n, ok := c.Get([]byte("key01"), data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "value01", string(data[:n]))
+
+	assert.Equal(t, nil, k.RetireKey(1))
+
+	_, ok = c.Get([]byte("key01"), data)
+	assert.Equal(t, false, ok)
+	assert.Equal(t, uint64(1), c.GetTotal())
+
+	n, ok = c.Get([]byte("key02"), data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "value02", string(data[:n]))
+	assert.Equal(t, nil, c.Verify())
+}
+
+func TestCache_Encryption_Swapped_Values(t *testing.T) {
+	c := New(1, 1<<14, WithEncryption(newTestKeyring(t)))
+
+	c.Put([]byte("key01"), []byte("value01"))
+	c.Put([]byte("key02"), []byte("value02"))
+
+	// the value of key02 is copied into the entry of key01
+	seg := &c.segments[0]
+	_, hash1 := c.getSegment([]byte("key01"))
+	_, hash2 := c.getSegment([]byte("key02"))
+	header1 := seg.getHeader(uint32(hash1))
+	header2 := seg.getHeader(uint32(hash2))
+
+	value := make([]byte, header2.valLen)
+	seg.rb.readAt(value, header2.valueOffset(seg.kv[uint32(hash2)]))
+	seg.rb.writeAt(value, header1.valueOffset(seg.kv[uint32(hash1)]))
+
+	_, ok := c.Get([]byte("key01"), nil)
+	assert.Equal(t, false, ok)
+}
+
+func TestCache_Encryption_Compressed_Chunked(t *testing.T) {
+	c := New(4, 1<<14,
+		WithEncryption(newTestKeyring(t)),
+		WithCompression(NewFlateCompressor(flate.DefaultCompression), 128),
+	)
+	value := compressibleValue(50000)
+
+	c.Put([]byte("page"), value)
+	seg, hash := c.getSegment([]byte("page"))
+	header := seg.getHeader(uint32(hash))
+	assert.Equal(t, true, header.isChunked())
+	assert.Equal(t, true, header.isCompressed())
+	assert.Equal(t, uint8(1), header.keyID)
+
+	data := make([]byte, 50000)
+	n, ok := c.Get([]byte("page"), data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, value, data[:n])
+
+	r, ok := c.OpenReader([]byte("page"))
+	assert.Equal(t, true, ok)
+	data, err := io.ReadAll(r)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, value, data)
+}
+
+func TestCache_Encryption_Pinned_And_Stream(t *testing.T) {
+	c := New(4, 1<<14, WithEncryption(newTestKeyring(t)))
+
+	assert.Equal(t, nil, c.PutPinned([]byte("key01"), []byte("value01")))
+	assert.Equal(t, nil, c.PutFrom([]byte("key02"), bytes.NewReader([]byte("value02")), 7))
+
+	for _, key := range []string{"key01", "key02"} {
+		seg, hash := c.getSegment([]byte(key))
+		assert.Equal(t, uint8(1), seg.getHeader(uint32(hash)).keyID)
+	}
+
+	data := make([]byte, 20)
+	n, ok := c.Get([]byte("key01"), data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "value01", string(data[:n]))
+
+	n, ok = c.Get([]byte("key02"), data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "value02", string(data[:n]))
+}
+
+func TestCache_Encryption_No_Alloc(t *testing.T) {
+	if raceEnabled {
+		t.Skip("sync.Pool drops items randomly with race detector")
+	}
+	c := New(4, 1<<14, WithEncryption(newTestKeyring(t)))
+	key := []byte("key01")
+	value := []byte("value01")
+	c.Put(key, value)
+
+	data := make([]byte, 20)
+	allocs := testing.AllocsPerRun(100, func() {
+		c.Put(key, value)
+		c.Get(key, data)
+	})
+	assert.Equal(t, float64(0), allocs)
+}
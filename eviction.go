@@ -0,0 +1,68 @@
+package bigcache
+
+import "math/bits"
+
+// EvictionDecision is the decision of an EvictionPolicy for the entry at the head of a ring buffer
+type EvictionDecision int
+
+const (
+	// EvictionDrop removes the entry from the cache
+	EvictionDrop EvictionDecision = iota
+	// EvictionRelocate moves the entry to the tail of the ring buffer, keeping it in the cache
+	EvictionRelocate
+)
+
+// EvictionEntry contains the information of the entry at the head of a ring buffer
+type EvictionEntry struct {
+	// Age is the time since the entry was last written or read, in ticks of the access time resolution
+	Age uint32
+	// Referenced is true if the entry was read since it was written or last relocated
+	Referenced bool
+
+	// SegmentTotal is the number of entries in the segment
+	SegmentTotal uint64
+	// SegmentTotalAge is the sum of the ages of entries in the segment
+	SegmentTotalAge uint64
+}
+
+// EvictionPolicy decides whether to drop or relocate entries when a segment needs space.
+// Regardless of the policy, deleted entries are always dropped and at most
+// maxConsecutiveEvacuation entries are relocated in a row.
+type EvictionPolicy interface {
+	Decide(entry EvictionEntry) EvictionDecision
+}
+
+// FIFOPolicy always drops the oldest written entry
+type FIFOPolicy struct {
+}
+
+// Decide ...
+func (FIFOPolicy) Decide(EvictionEntry) EvictionDecision {
+	return EvictionDrop
+}
+
+// AccessTimePolicy relocates entries accessed more recently than the segment average, this is the default policy
+type AccessTimePolicy struct {
+}
+
+// Decide ...
+func (AccessTimePolicy) Decide(e EvictionEntry) EvictionDecision {
+	// compare age > totalAge / total without losing precision or overflowing
+	hi, lo := bits.Mul64(e.SegmentTotal, uint64(e.Age))
+	if hi != 0 || lo > e.SegmentTotalAge {
+		return EvictionDrop
+	}
+	return EvictionRelocate
+}
+
+// ClockPolicy is the CLOCK (second chance) policy, entries read since the last pass are relocated once
+type ClockPolicy struct {
+}
+
+// Decide ...
+func (ClockPolicy) Decide(e EvictionEntry) EvictionDecision {
+	if e.Referenced {
+		return EvictionRelocate
+	}
+	return EvictionDrop
+}
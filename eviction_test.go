@@ -0,0 +1,125 @@
+package bigcache
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/QuangTung97/bigcache/memhash"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"testing"
+)
+
+func newSegmentPolicy(bufSize int, policy EvictionPolicy) *segment {
+	s := &segment{}
+	initSegment(s, bufSize, newCacheOptions(WithEvictionPolicy(policy)))
+	s.getNow = monoGetNow(0)
+	return s
+}
+
+func TestAccessTimePolicy(t *testing.T) {
+	p := AccessTimePolicy{}
+	assert.Equal(t, EvictionDrop, p.Decide(EvictionEntry{
+		Age: 11, SegmentTotal: 2, SegmentTotalAge: 20,
+	}))
+	assert.Equal(t, EvictionRelocate, p.Decide(EvictionEntry{
+		Age: 10, SegmentTotal: 2, SegmentTotalAge: 20,
+	}))
+}
+
+func TestClockPolicy(t *testing.T) {
+	p := ClockPolicy{}
+	assert.Equal(t, EvictionDrop, p.Decide(EvictionEntry{Age: 100}))
+	assert.Equal(t, EvictionRelocate, p.Decide(EvictionEntry{Referenced: true}))
+}
+
+func TestSegment_Put_Evacuate_FIFO(t *testing.T) {
+	const entrySize = entryHeaderSize + 8
+	s := newSegmentPolicy(entrySize*3, FIFOPolicy{})
+
+	s.put(40, []byte{1, 2, 0}, []byte{101, 102, 103, 100})
+	s.put(41, []byte{1, 2, 1}, []byte{101, 102, 103, 101})
+	s.put(42, []byte{1, 2, 2}, []byte{101, 102, 103, 102})
+
+	data := make([]byte, 100)
+	s.getAndApply(40, []byte{1, 2, 0}, data)
+
+	s.put(43, []byte{1, 2, 3}, []byte{101, 102, 103, 103})
+
+	_, ok := s.getAndApply(40, []byte{1, 2, 0}, data)
+	assert.Equal(t, false, ok)
+	_, ok = s.getAndApply(41, []byte{1, 2, 1}, data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, uint64(3), s.getTotal())
+	assert.Equal(t, s.totalAge, s.getSumTotalAge())
+}
+
+func TestSegment_Put_Evacuate_Clock(t *testing.T) {
+	const entrySize = entryHeaderSize + 8
+	s := newSegmentPolicy(entrySize*3, ClockPolicy{})
+
+	s.put(40, []byte{1, 2, 0}, []byte{101, 102, 103, 100})
+	s.put(41, []byte{1, 2, 1}, []byte{101, 102, 103, 101})
+	s.put(42, []byte{1, 2, 2}, []byte{101, 102, 103, 102})
+
+	data := make([]byte, 100)
+	s.getAndApply(40, []byte{1, 2, 0}, data)
+	assert.Equal(t, entryFlagReferenced, s.getHeader(40).flags)
+
+	s.put(43, []byte{1, 2, 3}, []byte{101, 102, 103, 103})
+
+	_, ok := s.getAndApply(41, []byte{1, 2, 1}, data)
+	assert.Equal(t, false, ok)
+
+	assert.Equal(t, uint8(0), s.getHeader(40).flags)
+
+	// second chance is used up, 40 is evicted by the next put
+	s.put(44, []byte{1, 2, 4}, []byte{101, 102, 103, 104})
+	s.put(45, []byte{1, 2, 5}, []byte{101, 102, 103, 105})
+
+	_, ok = s.getAndApply(40, []byte{1, 2, 0}, data)
+	assert.Equal(t, false, ok)
+	assert.Equal(t, uint64(3), s.getTotal())
+	assert.Equal(t, s.totalAge, s.getSumTotalAge())
+}
+
+func simulateHitRatio(policy EvictionPolicy) float64 {
+	const keyCount = 20000
+	const valueSize = 64
+
+	s := newSegmentPolicy(200*(entryHeaderSize+8+valueSize), policy)
+
+	r := rand.New(rand.NewSource(1234))
+	zipf := rand.NewZipf(r, 1.1, 1, keyCount-1)
+
+	var key [8]byte
+	value := make([]byte, valueSize)
+	placeholder := make([]byte, valueSize)
+
+	hitCount := 0
+	const accessCount = 200000
+	for i := 0; i < accessCount; i++ {
+		binary.LittleEndian.PutUint64(key[:], zipf.Uint64())
+		hash := uint32(memhash.Hash(key[:]))
+
+		_, ok := s.getAndApply(hash, key[:], placeholder)
+		if ok {
+			hitCount++
+			continue
+		}
+		s.put(hash, key[:], value)
+	}
+	return float64(hitCount) / accessCount
+}
+
+func TestEvictionPolicy_Hit_Ratio(t *testing.T) {
+	fifo := simulateHitRatio(FIFOPolicy{})
+	accessTime := simulateHitRatio(AccessTimePolicy{})
+	clock := simulateHitRatio(ClockPolicy{})
+
+	fmt.Println("FIFO:", fifo)
+	fmt.Println("ACCESS TIME:", accessTime)
+	fmt.Println("CLOCK:", clock)
+
+	assert.Greater(t, accessTime, fifo)
+	assert.Greater(t, clock, fifo)
+}
@@ -0,0 +1,161 @@
+package bigcache
+
+import (
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// ExportFormat is the format of the entries written by Cache.Export and read by Cache.Import
+type ExportFormat int
+
+const (
+	// ExportJSONLines writes an entry per line as a JSON object with the base64 encoded key and value
+	// and the remaining time to live in seconds, omitted if the entry never expires:
+	// {"key":"a2V5","value":"dmFsdWU=","ttl":60}
+	ExportJSONLines ExportFormat = iota
+	// ExportCSV writes the header key,value,ttl then an entry per row with the base64 encoded key and value
+	// and the remaining time to live in seconds, zero if the entry never expires
+	ExportCSV
+)
+
+// ErrInvalidExportFormat is returned by Export and Import for unknown formats
+var ErrInvalidExportFormat = errors.New("bigcache: invalid export format")
+
+var csvExportHeader = []string{"key", "value", "ttl"}
+
+type exportedEntry struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+	TTL   int64  `json:"ttl,omitempty"`
+}
+
+// Export writes the live entries of the cache to w with their decoded values. Tags, the entries of namespaces
+// and negative entries are not exported, pinned entries are imported as normal entries.
+// The cache is not locked during the export, entries put or deleted meanwhile may be skipped
+func (c *Cache) Export(w io.Writer, format ExportFormat) error {
+	switch format {
+	case ExportJSONLines:
+		encoder := json.NewEncoder(w)
+		return c.scanEntries(func(key []byte, value []byte, ttl time.Duration) error {
+			return encoder.Encode(exportedEntry{
+				Key:   key,
+				Value: value,
+				TTL:   int64(ttl / time.Second),
+			})
+		})
+
+	case ExportCSV:
+		writer := csv.NewWriter(w)
+		if err := writer.Write(csvExportHeader); err != nil {
+			return err
+		}
+		err := c.scanEntries(func(key []byte, value []byte, ttl time.Duration) error {
+			return writer.Write([]string{
+				base64.StdEncoding.EncodeToString(key),
+				base64.StdEncoding.EncodeToString(value),
+				strconv.FormatInt(int64(ttl/time.Second), 10),
+			})
+		})
+		if err != nil {
+			return err
+		}
+		writer.Flush()
+		return writer.Error()
+
+	default:
+		return ErrInvalidExportFormat
+	}
+}
+
+// Import puts the entries written by Export in format, the entries with a time to live expire after
+// the remaining time at the export. Returns the number of entries put, stops at the first invalid entry
+func (c *Cache) Import(r io.Reader, format ExportFormat) (int, error) {
+	switch format {
+	case ExportJSONLines:
+		return c.importJSONLines(r)
+	case ExportCSV:
+		return c.importCSV(r)
+	default:
+		return 0, ErrInvalidExportFormat
+	}
+}
+
+func (c *Cache) importJSONLines(r io.Reader) (int, error) {
+	decoder := json.NewDecoder(r)
+	for count := 0; ; count++ {
+		var e exportedEntry
+		err := decoder.Decode(&e)
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, fmt.Errorf("bigcache: import entry %d: %w", count+1, err)
+		}
+		if err := c.importEntry(e); err != nil {
+			return count, fmt.Errorf("bigcache: import entry %d: %w", count+1, err)
+		}
+	}
+}
+
+func (c *Cache) importCSV(r io.Reader) (int, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = len(csvExportHeader)
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return 0, fmt.Errorf("bigcache: import header: %w", err)
+	}
+	for i, name := range csvExportHeader {
+		if header[i] != name {
+			return 0, fmt.Errorf("bigcache: import header: expected column %q, got %q", name, header[i])
+		}
+	}
+
+	for count := 0; ; count++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return count, nil
+		}
+		if err == nil {
+			err = c.importCSVRecord(record)
+		}
+		if err != nil {
+			return count, fmt.Errorf("bigcache: import entry %d: %w", count+1, err)
+		}
+	}
+}
+
+func (c *Cache) importCSVRecord(record []string) error {
+	key, err := base64.StdEncoding.DecodeString(record[0])
+	if err != nil {
+		return err
+	}
+	value, err := base64.StdEncoding.DecodeString(record[1])
+	if err != nil {
+		return err
+	}
+	ttl, err := strconv.ParseInt(record[2], 10, 64)
+	if err != nil {
+		return err
+	}
+	return c.importEntry(exportedEntry{Key: key, Value: value, TTL: ttl})
+}
+
+func (c *Cache) importEntry(e exportedEntry) error {
+	switch {
+	case e.TTL < 0:
+		return errors.New("negative ttl")
+	case e.TTL == 0:
+		c.Put(e.Key, e.Value)
+	default:
+		c.PutWithTTL(e.Key, e.Value, time.Duration(e.TTL)*time.Second)
+	}
+	return nil
+}
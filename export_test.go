@@ -0,0 +1,168 @@
+package bigcache
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newExportTestCache() *Cache {
+	c := New(4, 1<<16)
+	putTestEntries(c, 0, 100)
+	c.Delete([]byte("key:10"))
+	c.PutWithTTL([]byte("ttl"), []byte("value"), time.Hour)
+	c.Put([]byte("binary\x00,\n\""), []byte("\xff\x00,\"\n"))
+	c.Put([]byte("big"), randomBytes(50000))
+	c.Put([]byte("empty"), nil)
+	c.PutNegative([]byte("negative"), time.Hour)
+	c.Namespace("ns", 1<<10).Put([]byte("ns:key"), []byte("value"))
+	return c
+}
+
+func assertImported(t *testing.T, src *Cache, dst *Cache) {
+	t.Helper()
+
+	assert.Equal(t, src.GetTotal()-2, dst.GetTotal()) // except the negative entry and the namespace entry
+	assertTestEntries(t, dst, 0, 10)
+	assertTestEntries(t, dst, 11, 100)
+	_, ok := dst.Get([]byte("key:10"), nil)
+	assert.Equal(t, false, ok)
+	_, ok = dst.Get([]byte("negative"), nil)
+	assert.Equal(t, false, ok)
+	_, ok = dst.Get([]byte("ns:key"), nil)
+	assert.Equal(t, false, ok)
+
+	for _, key := range []string{"ttl", "binary\x00,\n\"", "big", "empty"} {
+		expected := make([]byte, 50000)
+		n, ok := src.Get([]byte(key), expected)
+		assert.Equal(t, true, ok)
+
+		data := make([]byte, 50000)
+		m, ok := dst.Get([]byte(key), data)
+		assert.Equal(t, true, ok, key)
+		assert.Equal(t, expected[:n], data[:m], key)
+	}
+
+	seg, hash := dst.getSegment([]byte("ttl"))
+	assert.Greater(t, seg.getHeader(uint32(hash)).expireAt, dst.getExpireNow()+3500)
+	seg, hash = dst.getSegment([]byte("key:1"))
+	assert.Equal(t, uint32(0), seg.getHeader(uint32(hash)).expireAt)
+}
+
+func TestCache_Export_Import_JSON_Lines(t *testing.T) {
+	src := newExportTestCache()
+
+	var buf bytes.Buffer
+	assert.Equal(t, nil, src.Export(&buf, ExportJSONLines))
+	assert.Equal(t, 103, strings.Count(buf.String(), "\n"))
+	assert.Contains(t, buf.String(), `{"key":"a2V5OjE=","value":"dmFsdWU6MQ=="}`+"\n")
+	assert.Regexp(t, `{"key":"dHRs","value":"dmFsdWU=","ttl":3[56]\d\d}`, buf.String())
+
+	dst := New(4, 1<<16)
+	n, err := dst.Import(&buf, ExportJSONLines)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 103, n)
+	assertImported(t, src, dst)
+}
+
+func TestCache_Export_Import_CSV(t *testing.T) {
+	src := newExportTestCache()
+
+	var buf bytes.Buffer
+	assert.Equal(t, nil, src.Export(&buf, ExportCSV))
+	assert.Equal(t, true, strings.HasPrefix(buf.String(), "key,value,ttl\n"))
+	assert.Contains(t, buf.String(), "\na2V5OjE=,dmFsdWU6MQ==,0\n")
+
+	dst := New(4, 1<<16)
+	n, err := dst.Import(&buf, ExportCSV)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 103, n)
+	assertImported(t, src, dst)
+}
+
+func TestCache_Export_Encrypted(t *testing.T) {
+	keyring, err := NewKeyring(1, bytes.Repeat([]byte{1}, 32))
+	assert.Equal(t, nil, err)
+
+	src := New(4, 1<<16,
+		WithEncryption(keyring),
+		WithCompression(NewFlateCompressor(flate.DefaultCompression), 0),
+	)
+	putTestEntries(src, 0, 100)
+
+	var buf bytes.Buffer
+	assert.Equal(t, nil, src.Export(&buf, ExportJSONLines))
+
+	// the values are exported decoded
+	dst := New(4, 1<<16)
+	n, err := dst.Import(&buf, ExportJSONLines)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 100, n)
+	assertTestEntries(t, dst, 0, 100)
+}
+
+func TestCache_Export_Not_Counted_As_Access(t *testing.T) {
+	c := New(4, 1<<16, WithChecksum())
+	putTestEntries(c, 0, 100)
+	c.Put([]byte("big"), randomBytes(50000))
+
+	seg, hash := c.getSegment([]byte("key:1"))
+	accessTime := seg.getHeader(uint32(hash)).accessTime
+
+	assert.Equal(t, nil, c.Export(&bytes.Buffer{}, ExportJSONLines))
+
+	assert.Equal(t, uint64(0), c.GetAccessCount())
+	assert.Equal(t, uint64(0), c.GetHitCount())
+	for i := range c.segments {
+		assert.Equal(t, uint32(0), atomic.LoadUint32(&c.segments[i].readCount))
+	}
+	assert.Equal(t, accessTime, seg.getHeader(uint32(hash)).accessTime)
+	assert.Equal(t, uint8(0), seg.getHeader(uint32(hash)).flags&entryFlagReferenced)
+}
+
+func TestCache_Import_Invalid(t *testing.T) {
+	c := New(4, 1<<16)
+
+	n, err := c.Import(strings.NewReader(`{"key":"a2V5MQ==","value":""}`+"\n"+`{"key":"!"}`), ExportJSONLines)
+	assert.Equal(t, 1, n)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "bigcache: import entry 2: ")
+
+	_, err = c.Import(strings.NewReader(`{"key":"a2V5MQ==","ttl":-1}`), ExportJSONLines)
+	assert.EqualError(t, err, "bigcache: import entry 1: negative ttl")
+
+	_, err = c.Import(strings.NewReader("key,ttl\n"), ExportCSV)
+	assert.Error(t, err)
+
+	_, err = c.Import(strings.NewReader("key,val,ttl\n"), ExportCSV)
+	assert.EqualError(t, err, `bigcache: import header: expected column "value", got "val"`)
+
+	n, err = c.Import(strings.NewReader("key,value,ttl\na2V5MQ==,,0\na2V5Mg==,,x\n"), ExportCSV)
+	assert.Equal(t, 1, n)
+	assert.Error(t, err)
+	assert.Equal(t, uint64(1), c.GetTotal())
+
+	_, err = c.Import(strings.NewReader(""), ExportFormat(10))
+	assert.Equal(t, ErrInvalidExportFormat, err)
+	assert.Equal(t, ErrInvalidExportFormat, c.Export(&bytes.Buffer{}, ExportFormat(10)))
+}
+
+func TestCache_Export_Write_Error(t *testing.T) {
+	c := New(4, 1<<16)
+	putTestEntries(c, 0, 10)
+
+	err := c.Export(errorWriter{}, ExportJSONLines)
+	assert.EqualError(t, err, "write error")
+}
+
+type errorWriter struct {
+}
+
+func (errorWriter) Write([]byte) (int, error) {
+	return 0, fmt.Errorf("write error")
+}
@@ -0,0 +1,212 @@
+package bigcache
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/QuangTung97/bigcache/internal/inspect"
+	"io"
+	"os"
+	"time"
+	"unsafe"
+)
+
+func init() {
+	inspect.Register(inspect.Readers{
+		OpenCacheFile: openCacheFile,
+		ReadWALFile:   readWALFile,
+	})
+}
+
+// cacheFile reads the files of a cache saved in a directory by Close or Sync (see Open) without opening
+// the cache, for cmd/bigcache-inspect
+type cacheFile struct {
+	allocator *FileAllocator // only for the paths of the files
+	meta      *persistMeta
+	now       uint32 // the expire clock at the time of the inspection
+}
+
+var _ inspect.CacheFile = &cacheFile{}
+
+func openCacheFile(dir string) (inspect.CacheFile, error) {
+	meta, err := readPersistMeta(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &cacheFile{
+		allocator: NewFileAllocator(dir),
+		meta:      meta,
+		now:       restoreGetNow(func() uint32 { return 0 }, meta)(),
+	}, nil
+}
+
+// IsClean ...
+func (f *cacheFile) IsClean() bool {
+	return f.meta.clean
+}
+
+// NumSegments ...
+func (f *cacheFile) NumSegments() int {
+	return len(f.meta.segments)
+}
+
+// Segment ...
+func (f *cacheFile) Segment(index int) inspect.SegmentInfo {
+	return inspect.SegmentInfo{
+		Capacity: f.meta.segmentSize,
+		Used:     f.meta.segments[index].size,
+	}
+}
+
+// WalkSegment ...
+func (f *cacheFile) WalkSegment(index int, fn func(e *inspect.Entry) error) error {
+	file, err := os.Open(f.allocator.segmentPath(index))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = file.Close() }()
+
+	if err := f.checkSegmentFile(index, file); err != nil {
+		return err
+	}
+
+	r := fileRingReader{file: file, size: f.meta.segmentSize}
+	state := f.meta.segments[index]
+	var e inspect.Entry
+	var data []byte
+	for pos := 0; pos < state.size; pos += e.Size {
+		offset := (state.begin + pos) % f.meta.segmentSize
+		e, data, err = f.readEntry(r, offset, state.size-pos, data)
+		if err != nil {
+			return fmt.Errorf("bigcache: segment %d: %w", index, err)
+		}
+		if err := fn(&e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkSegmentFile returns an error if the file of the segment does not match the meta file
+func (f *cacheFile) checkSegmentFile(index int, file *os.File) error {
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	state := f.meta.segments[index]
+	size := f.meta.segmentSize
+	if info.Size() != int64(size) || state.begin >= size || state.size > size {
+		return fmt.Errorf("bigcache: segment %d does not match the meta file", index)
+	}
+	return nil
+}
+
+// readEntry reads the entry at offset into data, remaining is the size of the entries from offset to the tail
+func (f *cacheFile) readEntry(r fileRingReader, offset int, remaining int, data []byte) (inspect.Entry, []byte, error) {
+	var headerData [maxEntryHeaderSize]byte
+	header := (*entryHeader)(unsafe.Pointer(&headerData[0]))
+	if err := r.readHeader(&headerData, offset); err != nil {
+		return inspect.Entry{}, data, err
+	}
+	size := header.entrySize()
+	if header.valLen > header.valCap || size > remaining {
+		return inspect.Entry{}, data, fmt.Errorf("invalid entry header at offset %d", offset)
+	}
+
+	n := int(header.keyLen) + int(header.valLen)
+	if cap(data) < n {
+		data = make([]byte, n)
+	}
+	data = data[:n]
+	if err := r.readAt(data[:header.keyLen], header.keyOffset(offset)); err != nil {
+		return inspect.Entry{}, data, err
+	}
+	if err := r.readAt(data[header.keyLen:], header.valueOffset(offset)); err != nil {
+		return inspect.Entry{}, data, err
+	}
+
+	e := inspect.Entry{
+		Offset:     offset,
+		Size:       size,
+		Key:        data[:header.keyLen],
+		Value:      data[header.keyLen:],
+		Expired:    header.isExpired(f.now),
+		Deleted:    header.deleted,
+		Pinned:     header.isPinned(),
+		Negative:   header.isNegative(),
+		Chunked:    header.isChunked(),
+		Chunk:      header.flags&entryFlagChunk != 0,
+		Compressed: header.isCompressed(),
+		KeyID:      header.keyID,
+		Namespace:  header.namespace,
+		TagCount:   int(header.tagCount),
+	}
+	if header.expireAt != 0 && !e.Expired {
+		e.TTL = time.Duration(header.expireAt-f.now) * time.Second
+	}
+	return e, data, nil
+}
+
+type fileRingReader struct {
+	file *os.File
+	size int
+}
+
+func (r fileRingReader) readAt(data []byte, offset int) error {
+	offset = offset % r.size
+	firstPart := len(data)
+	if offset+firstPart > r.size {
+		firstPart = r.size - offset
+	}
+	if _, err := r.file.ReadAt(data[:firstPart], int64(offset)); err != nil {
+		return err
+	}
+	_, err := r.file.ReadAt(data[firstPart:], 0)
+	return err
+}
+
+// readHeader is the same as ringBuf.readHeader but for a segment file
+func (r fileRingReader) readHeader(headerData *[maxEntryHeaderSize]byte, offset int) error {
+	if err := r.readAt(headerData[:entryHeaderSize], offset); err != nil {
+		return err
+	}
+	flags := (*entryHeader)(unsafe.Pointer(&headerData[0])).flags
+	if err := r.readAt(headerData[entryHeaderSize:headerSizeOf(flags)], offset+entryHeaderSize); err != nil {
+		return err
+	}
+	unpackHeader(headerData)
+	return nil
+}
+
+func readWALFile(path string, fn func(r *inspect.WALRecord) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = file.Close() }()
+
+	reader := bufio.NewReader(file)
+	var payload []byte
+	for {
+		payload, err = readWALRecord(reader, payload)
+		if err == io.EOF || err == io.ErrUnexpectedEOF || err == errInvalidWALRecord {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		record := decodeWALPayload(payload)
+		r := inspect.WALRecord{
+			Delete: record.op == walOpDelete,
+			Key:    record.key,
+			Value:  record.value,
+			KeyID:  record.keyID,
+		}
+		if record.expireAt != 0 {
+			r.ExpireAt = time.Unix(record.expireAt, 0)
+		}
+		if err := fn(&r); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package bigcache
+
+import (
+	"fmt"
+	"github.com/QuangTung97/bigcache/internal/inspect"
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCacheFile_WalkSegment(t *testing.T) {
+	dir := t.TempDir()
+
+	c := openTestCache(t, dir)
+	putTestEntries(c, 0, 100)
+	c.Delete([]byte("key:10"))
+	c.PutWithTTL([]byte("ttl"), []byte("value"), time.Hour)
+	assert.Equal(t, nil, c.PutPinned([]byte("pinned"), []byte("value")))
+	c.Namespace("ns", 1<<10).Put([]byte("ns:key"), []byte("value"))
+	assert.Equal(t, nil, c.Close())
+
+	f, err := openCacheFile(dir)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, f.IsClean())
+	assert.Equal(t, 4, f.NumSegments())
+
+	entries := map[string]inspect.Entry{}
+	values := map[string]string{}
+	used := 0
+	for i := 0; i < f.NumSegments(); i++ {
+		info := f.Segment(i)
+		assert.Equal(t, 1<<14, info.Capacity)
+		used += info.Used
+
+		size := 0
+		err := f.WalkSegment(i, func(e *inspect.Entry) error {
+			size += e.Size
+			if e.Deleted {
+				return nil
+			}
+			entries[string(e.Key)] = *e
+			values[string(e.Key)] = string(e.Value)
+			return nil
+		})
+		assert.Equal(t, nil, err)
+		assert.Equal(t, info.Used, size)
+	}
+	assert.Greater(t, used, 0)
+
+	// deleted entries are removed when closed
+	assert.Equal(t, 102, len(entries))
+	for i := 0; i < 100; i++ {
+		if i == 10 {
+			continue
+		}
+		key := fmt.Sprintf("key:%d", i)
+		assert.Equal(t, fmt.Sprintf("value:%d", i), values[key])
+		assert.Equal(t, time.Duration(0), entries[key].TTL)
+	}
+	_, ok := entries["key:10"]
+	assert.Equal(t, false, ok)
+
+	assert.Greater(t, entries["ttl"].TTL, 59*time.Minute)
+	assert.Equal(t, false, entries["ttl"].Expired)
+	assert.Equal(t, true, entries["pinned"].Pinned)
+	assert.Equal(t, uint16(1), entries["ns:key"].Namespace)
+	assert.Equal(t, uint16(0), entries["ttl"].Namespace)
+}
+
+func TestCacheFile_Stop_Walking(t *testing.T) {
+	dir := t.TempDir()
+
+	c := openTestCache(t, dir)
+	putTestEntries(c, 0, 100)
+	assert.Equal(t, nil, c.Close())
+
+	f, err := openCacheFile(dir)
+	assert.Equal(t, nil, err)
+
+	stopErr := fmt.Errorf("stop")
+	count := 0
+	err = f.WalkSegment(0, func(e *inspect.Entry) error {
+		count++
+		return stopErr
+	})
+	assert.Equal(t, stopErr, err)
+	assert.Equal(t, 1, count)
+}
+
+func TestCacheFile_Not_Found(t *testing.T) {
+	_, err := openCacheFile(t.TempDir())
+	assert.Error(t, err)
+}
+
+func TestReadWALFile(t *testing.T) {
+	dir := t.TempDir()
+
+	w, _ := openTestWAL(t, dir)
+	assert.Equal(t, nil, w.Put([]byte("key01"), []byte("value01")))
+	assert.Equal(t, nil, w.PutWithTTL([]byte("key02"), []byte("value02"), time.Hour))
+	_, err := w.Delete([]byte("key01"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, w.Close())
+
+	var records []inspect.WALRecord
+	err = readWALFile(filepath.Join(dir, "wal-0000000000000001.log"), func(r *inspect.WALRecord) error {
+		records = append(records, inspect.WALRecord{
+			Delete:   r.Delete,
+			Key:      append([]byte(nil), r.Key...),
+			Value:    append([]byte(nil), r.Value...),
+			ExpireAt: r.ExpireAt,
+		})
+		return nil
+	})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 3, len(records))
+
+	assert.Equal(t, "key01", string(records[0].Key))
+	assert.Equal(t, "value01", string(records[0].Value))
+	assert.Equal(t, true, records[0].ExpireAt.IsZero())
+
+	assert.Equal(t, "key02", string(records[1].Key))
+	assert.Greater(t, time.Until(records[1].ExpireAt), 59*time.Minute)
+
+	assert.Equal(t, true, records[2].Delete)
+	assert.Equal(t, "key01", string(records[2].Key))
+}
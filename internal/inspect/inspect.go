@@ -0,0 +1,98 @@
+// Package inspect reads the files of a cache saved by bigcache.Open and of the logs and snapshots written
+// by bigcache.WAL without opening the cache, for cmd/bigcache-inspect. The file formats are internal to
+// bigcache: the package bigcache implements the readers and registers them when imported
+package inspect
+
+import (
+	"errors"
+	"time"
+)
+
+// CacheFile reads the files of a cache saved in a directory. The files must not be written during the inspection
+type CacheFile interface {
+	// IsClean returns true if the cache was closed, otherwise the state is from the last Sync
+	IsClean() bool
+
+	// NumSegments returns the number of segments
+	NumSegments() int
+
+	// Segment returns the info of the segment at index
+	Segment(index int) SegmentInfo
+
+	// WalkSegment calls fn with the entries of the segment at index from the head to the tail of its
+	// ring buffer, including deleted and expired entries. The entry and its slices are only valid during the call
+	WalkSegment(index int, fn func(e *Entry) error) error
+}
+
+// SegmentInfo describes the ring buffer of a segment in a cache file
+type SegmentInfo struct {
+	Capacity int // the size of the ring buffer
+	Used     int // the bytes used by entries, including deleted entries
+}
+
+// Entry is an entry read from a ring buffer of a cache file
+type Entry struct {
+	Offset int // the offset in the ring buffer
+	Size   int // the size in the ring buffer, including the header
+
+	Key   []byte
+	Value []byte // as stored, can be compressed, encrypted or the manifest of a chunked value
+
+	TTL     time.Duration // the remaining time to live, zero if the entry never expires
+	Expired bool
+	Deleted bool
+
+	Pinned     bool
+	Negative   bool
+	Chunked    bool // the value is the manifest of the chunks
+	Chunk      bool // the entry is a chunk of another value
+	Compressed bool
+	KeyID      uint8  // the id of the encryption key, zero if not encrypted
+	Namespace  uint16 // the id of the namespace, zero for the entries of the Cache itself
+	TagCount   int
+}
+
+// WALRecord is a record of a log or a snapshot written by WAL
+type WALRecord struct {
+	Delete   bool
+	Key      []byte
+	Value    []byte
+	ExpireAt time.Time // zero if the entry never expires
+	KeyID    uint8     // the id of the key encrypting Value, 0 if not encrypted
+}
+
+// Readers are the readers of the file formats, registered by the package bigcache
+type Readers struct {
+	// OpenCacheFile reads the meta file of the cache saved in dir
+	OpenCacheFile func(dir string) (CacheFile, error)
+
+	// ReadWALFile calls fn with the records of a log or a snapshot, stops at the first incomplete
+	// or invalid record. The record and its slices are only valid during the call
+	ReadWALFile func(path string, fn func(r *WALRecord) error) error
+}
+
+var errNotRegistered = errors.New("inspect: the package bigcache is not imported")
+
+var readers Readers
+
+// Register sets the readers, called by the package bigcache when initialized
+func Register(r Readers) {
+	readers = r
+}
+
+// OpenCacheFile reads the meta file of the cache saved in dir
+func OpenCacheFile(dir string) (CacheFile, error) {
+	if readers.OpenCacheFile == nil {
+		return nil, errNotRegistered
+	}
+	return readers.OpenCacheFile(dir)
+}
+
+// ReadWALFile calls fn with the records of a log or a snapshot written by WAL, stops at the first incomplete
+// or invalid record. The record and its slices are only valid during the call
+func ReadWALFile(path string, fn func(r *WALRecord) error) error {
+	if readers.ReadWALFile == nil {
+		return errNotRegistered
+	}
+	return readers.ReadWALFile(path, fn)
+}
@@ -0,0 +1,423 @@
+package bigcache
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+const tagSize = 8
+
+const maxTagsPerEntry = 255
+
+const defaultMaxInvalidatedTags = 1 << 16
+
+const defaultMaxInvalidatedPrefixes = 1 << 12
+
+// maxRecentTags is the size of the recent tags of an invalidation table, when full they are merged into the tags
+const maxRecentTags = 256
+
+// invalidator records the invalidated tags and key prefixes with the epoch of the invalidation.
+// Every entry stores the epoch at the time it was put, an entry is invalidated if one of its tags or
+// one of the prefixes of its key is invalidated with a greater epoch. Entries are checked lazily
+// when they are read or evacuated, so an invalidation does not scan the segments.
+// The recorded tags and prefixes are bounded by maxTags and maxPrefixes (see pruneTags and prunePrefixes).
+// For a persistent cache the invalidations are also appended to log
+type invalidator struct {
+	epoch uint32
+
+	mu    sync.Mutex   // serializes the invalidations
+	table atomic.Value // *invalidationTable, read without locking
+
+	maxTags     int
+	maxPrefixes int
+
+	// liveEpochs returns the minimum epochs of the entries in the segments,
+	// nil while the log is replayed because the entries are not restored yet
+	liveEpochs func() liveEpochs
+
+	log *invalidationLog // nil if the cache is not persistent
+}
+
+// invalidationTable is an immutable snapshot of the invalidations, every invalidation stores a new table.
+// The tags of the last invalidations are kept in recentTags, copied on each invalidation and merged into tags
+// when full, so the cost of copying the tags is amortized
+type invalidationTable struct {
+	tags       map[uint64]uint32 // tag => epoch
+	recentTags map[uint64]uint32 // newer than tags
+	tagFloor   uint32            // the epoch of the newest dropped tag, tagged entries put before it are invalidated
+
+	prefixes     map[prefixGroup]map[string]uint32 // prefix => epoch
+	prefixCount  int
+	prefixFloors map[keySpace]uint32 // the epoch of the newest dropped prefix of each key space
+}
+
+// prefixGroup groups the invalidated prefixes by key space and length
+type prefixGroup struct {
+	space  keySpace
+	length int
+}
+
+// liveEpochs is the minimum epochs of the entries of a cache, math.MaxUint32 if there is no entry
+type liveEpochs struct {
+	tagged uint32              // of the entries with tags
+	spaces map[keySpace]uint32 // of the entries of each key space, absent if the key space has no entry
+}
+
+func newInvalidator() *invalidator {
+	inv := &invalidator{
+		maxTags:     defaultMaxInvalidatedTags,
+		maxPrefixes: defaultMaxInvalidatedPrefixes,
+	}
+	inv.table.Store(&invalidationTable{
+		tags:         map[uint64]uint32{},
+		recentTags:   map[uint64]uint32{},
+		prefixes:     map[prefixGroup]map[string]uint32{},
+		prefixFloors: map[keySpace]uint32{},
+	})
+	return inv
+}
+
+func (inv *invalidator) getEpoch() uint32 {
+	return atomic.LoadUint32(&inv.epoch)
+}
+
+func (inv *invalidator) getTable() *invalidationTable {
+	return inv.table.Load().(*invalidationTable)
+}
+
+func (inv *invalidator) invalidateTag(tag uint64) {
+	inv.mu.Lock()
+	epoch := atomic.AddUint32(&inv.epoch, 1)
+	inv.setTag(tag, epoch)
+	inv.log.appendTag(tag, epoch)
+	inv.mu.Unlock()
+}
+
+func (inv *invalidator) setTag(tag uint64, epoch uint32) {
+	table := *inv.getTable()
+	table.recentTags = copyTags(table.recentTags)
+	table.recentTags[tag] = epoch
+	if len(table.recentTags) >= maxRecentTags {
+		table.tags = mergeTags(table.tags, table.recentTags)
+		table.recentTags = map[uint64]uint32{}
+	}
+	if len(table.tags)+len(table.recentTags) > inv.maxTags {
+		inv.pruneTags(&table)
+	}
+	inv.table.Store(&table)
+}
+
+// pruneTags drops the tags older than all live tagged entries, they no longer invalidate any entry.
+// If more than half of maxTags remain, the older half is dropped: the entries with tags put before the newest
+// dropped tag can no longer be checked against the dropped tags, they are all invalidated by raising tagFloor
+func (inv *invalidator) pruneTags(table *invalidationTable) {
+	tags := mergeTags(table.tags, table.recentTags)
+	table.tags = tags
+	table.recentTags = map[uint64]uint32{}
+
+	if inv.liveEpochs != nil {
+		minEpoch := inv.liveEpochs().tagged
+		for tag, epoch := range tags {
+			if epoch <= minEpoch {
+				delete(tags, tag)
+			}
+		}
+	}
+	if len(tags) <= inv.maxTags/2 {
+		return
+	}
+
+	epochs := make([]uint32, 0, len(tags))
+	for _, epoch := range tags {
+		epochs = append(epochs, epoch)
+	}
+	sort.Slice(epochs, func(i, j int) bool { return epochs[i] < epochs[j] })
+
+	floor := epochs[len(epochs)/2]
+	for tag, epoch := range tags {
+		if epoch <= floor {
+			delete(tags, tag)
+		}
+	}
+	table.tagFloor = floor
+	inv.log.appendTagFloor(floor)
+}
+
+func (t *invalidationTable) getTagEpoch(tag uint64) (uint32, bool) {
+	if epoch, ok := t.recentTags[tag]; ok {
+		return epoch, true
+	}
+	epoch, ok := t.tags[tag]
+	return epoch, ok
+}
+
+func copyTags(tags map[uint64]uint32) map[uint64]uint32 {
+	result := make(map[uint64]uint32, len(tags)+1)
+	for tag, epoch := range tags {
+		result[tag] = epoch
+	}
+	return result
+}
+
+// mergeTags returns a new map of tags with the newer tags
+func mergeTags(tags map[uint64]uint32, newer map[uint64]uint32) map[uint64]uint32 {
+	result := make(map[uint64]uint32, len(tags)+len(newer))
+	for tag, epoch := range tags {
+		result[tag] = epoch
+	}
+	for tag, epoch := range newer {
+		result[tag] = epoch
+	}
+	return result
+}
+
+func (inv *invalidator) invalidatePrefix(space keySpace, prefix []byte) {
+	inv.mu.Lock()
+	epoch := atomic.AddUint32(&inv.epoch, 1)
+	inv.setPrefix(space, prefix, epoch)
+	inv.log.appendPrefix(space, prefix, epoch)
+	inv.mu.Unlock()
+}
+
+func (inv *invalidator) setPrefix(space keySpace, prefix []byte, epoch uint32) {
+	table := *inv.getTable()
+	table.prefixes = copyPrefixGroups(table.prefixes)
+
+	group := prefixGroup{space: space, length: len(prefix)}
+	prefixes := make(map[string]uint32, len(table.prefixes[group])+1)
+	for p, e := range table.prefixes[group] {
+		prefixes[p] = e
+	}
+	if _, existed := prefixes[string(prefix)]; !existed {
+		table.prefixCount++
+	}
+	prefixes[string(prefix)] = epoch
+	table.prefixes[group] = prefixes
+
+	if table.prefixCount > inv.maxPrefixes {
+		inv.prunePrefixes(&table)
+	}
+	inv.table.Store(&table)
+}
+
+// prunePrefixes drops the prefixes older than all live entries of their key spaces, the same as pruneTags.
+// If more than half of maxPrefixes remain, the older half is dropped and the entries put before the newest
+// dropped prefix of their key space are invalidated by raising the floor of the key space
+func (inv *invalidator) prunePrefixes(table *invalidationTable) {
+	if inv.liveEpochs != nil {
+		live := inv.liveEpochs()
+		table.filterPrefixes(func(space keySpace, epoch uint32) bool {
+			minEpoch, ok := live.spaces[space]
+			return ok && epoch > minEpoch
+		})
+	}
+	if table.prefixCount > inv.maxPrefixes/2 {
+		inv.raisePrefixFloors(table)
+	}
+}
+
+// raisePrefixFloors drops the older half of the prefixes and raises the floors of their key spaces
+func (inv *invalidator) raisePrefixFloors(table *invalidationTable) {
+	epochs := make([]uint32, 0, table.prefixCount)
+	for _, prefixes := range table.prefixes {
+		for _, epoch := range prefixes {
+			epochs = append(epochs, epoch)
+		}
+	}
+	sort.Slice(epochs, func(i, j int) bool { return epochs[i] < epochs[j] })
+
+	floor := epochs[len(epochs)/2]
+	floors := make(map[keySpace]uint32, len(table.prefixFloors))
+	for space, epoch := range table.prefixFloors {
+		floors[space] = epoch
+	}
+	table.filterPrefixes(func(space keySpace, epoch uint32) bool {
+		if epoch > floor {
+			return true
+		}
+		if epoch > floors[space] {
+			floors[space] = epoch
+		}
+		return false
+	})
+	for space, epoch := range floors {
+		if epoch != table.prefixFloors[space] {
+			inv.log.appendPrefixFloor(space, epoch)
+		}
+	}
+	table.prefixFloors = floors
+}
+
+// filterPrefixes keeps only the prefixes for which keep returns true, the maps are not modified in place
+func (t *invalidationTable) filterPrefixes(keep func(space keySpace, epoch uint32) bool) {
+	result := make(map[prefixGroup]map[string]uint32, len(t.prefixes))
+	count := 0
+	for group, prefixes := range t.prefixes {
+		kept := map[string]uint32{}
+		for prefix, epoch := range prefixes {
+			if keep(group.space, epoch) {
+				kept[prefix] = epoch
+			}
+		}
+		if len(kept) > 0 {
+			result[group] = kept
+			count += len(kept)
+		}
+	}
+	t.prefixes = result
+	t.prefixCount = count
+}
+
+func copyPrefixGroups(groups map[prefixGroup]map[string]uint32) map[prefixGroup]map[string]uint32 {
+	result := make(map[prefixGroup]map[string]uint32, len(groups)+1)
+	for group, prefixes := range groups {
+		result[group] = prefixes
+	}
+	return result
+}
+
+// minLiveEpochs scans the headers of the live entries of all segments
+func (c *Cache) minLiveEpochs() liveEpochs {
+	live := liveEpochs{
+		tagged: math.MaxUint32,
+		spaces: map[keySpace]uint32{},
+	}
+	for i := range c.segments {
+		c.segments[i].collectLiveEpochs(&live)
+	}
+	return live
+}
+
+func (s *segment) collectLiveEpochs(live *liveEpochs) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var headerData [maxEntryHeaderSize]byte
+	header := (*entryHeader)(unsafe.Pointer(&headerData[0]))
+	for _, offset := range s.kv {
+		s.rb.readHeader(&headerData, offset)
+		if header.tagCount > 0 && header.epoch < live.tagged {
+			live.tagged = header.epoch
+		}
+		space := header.keySpace()
+		if minEpoch, ok := live.spaces[space]; !ok || header.epoch < minEpoch {
+			live.spaces[space] = header.epoch
+		}
+	}
+}
+
+func (c *Cache) hashTags(tags []string) []uint64 {
+	if len(tags) > maxTagsPerEntry {
+		panic("too many tags")
+	}
+	hashes := make([]uint64, len(tags))
+	for i, tag := range tags {
+		hashes[i] = c.hashString(tag)
+	}
+	return hashes
+}
+
+// PutWithTags is the same as Put but attaches tags to the entry, the entry is removed by InvalidateTag
+// of any of its tags
+func (c *Cache) PutWithTags(key []byte, value []byte, tags ...string) {
+	seg, hash := c.getSegment(key)
+	params := putParams{tags: c.hashTags(tags)}
+	c.putEntry(seg, hash, key, value, params)
+}
+
+// InvalidateTag removes all entries put with the tag before this call, in O(1):
+// the entries are not removed immediately but become misses and are reclaimed lazily.
+// The last 65536 invalidated tags are recorded, when exceeded the tags older than all entries with tags
+// are dropped. If that is not enough, the older half is dropped and all entries with tags put before
+// the dropped tags become misses
+func (c *Cache) InvalidateTag(tag string) {
+	c.invalidator.invalidateTag(c.hashString(tag))
+}
+
+// DeletePrefix removes all entries whose keys start with prefix put before this call, in O(1)
+// the same as InvalidateTag. Each distinct prefix length adds a map lookup to the checks of entries.
+// The last 4096 deleted prefixes are recorded, when exceeded they are dropped the same way as the tags
+// of InvalidateTag, but the entries of the key space put before a dropped prefix become misses
+func (c *Cache) DeletePrefix(prefix []byte) {
+	c.invalidator.invalidatePrefix(keySpace{}, prefix)
+}
+
+// isInvalidated returns true if the entry is invalidated by a tag or a prefix of its key, or encrypted with
+// a retired key. key is the key of the entry or nil to read it from the ring buffer
+func (s *segment) isInvalidated(header *entryHeader, offset int, key []byte) bool {
+	if s.isRetired(header) {
+		return true
+	}
+
+	inv := s.invalidator
+	if header.epoch == inv.getEpoch() {
+		return false
+	}
+
+	table := inv.getTable()
+	if header.tagCount > 0 && s.isTagInvalidated(table, header, offset) {
+		return true
+	}
+	if len(table.prefixes) == 0 && len(table.prefixFloors) == 0 {
+		return false
+	}
+	return s.isPrefixInvalidated(table, header, offset, key)
+}
+
+func (s *segment) isPrefixInvalidated(table *invalidationTable, header *entryHeader, offset int, key []byte) bool {
+	space := header.keySpace()
+	if header.epoch < table.prefixFloors[space] {
+		return true
+	}
+	if key == nil {
+		key = make([]byte, header.keyLen)
+		s.rb.readAt(key, header.keyOffset(offset))
+	}
+	for group, prefixes := range table.prefixes {
+		if group.space != space || group.length > len(key) {
+			continue
+		}
+		epoch, ok := prefixes[string(key[:group.length])]
+		if ok && epoch > header.epoch {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *segment) isTagInvalidated(table *invalidationTable, header *entryHeader, offset int) bool {
+	if header.epoch < table.tagFloor {
+		return true
+	}
+
+	var tagData [tagSize]byte
+	tagOffset := header.keyOffset(offset) + int(header.keyLen)
+	for i := 0; i < int(header.tagCount); i++ {
+		s.rb.readAt(tagData[:], tagOffset+i*tagSize)
+		epoch, ok := table.getTagEpoch(binary.LittleEndian.Uint64(tagData[:]))
+		if ok && epoch > header.epoch {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *segment) appendTags(tags []uint64) {
+	var tagData [tagSize]byte
+	for _, tag := range tags {
+		binary.LittleEndian.PutUint64(tagData[:], tag)
+		s.rb.append(tagData[:])
+	}
+}
+
+func (s *segment) writeTags(tags []uint64, offset int) {
+	var tagData [tagSize]byte
+	for i, tag := range tags {
+		binary.LittleEndian.PutUint64(tagData[:], tag)
+		s.rb.writeAt(tagData[:], offset+i*tagSize)
+	}
+}
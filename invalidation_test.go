@@ -0,0 +1,320 @@
+package bigcache
+
+import (
+	"context"
+	"fmt"
+	"github.com/QuangTung97/bigcache/memhash"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSegment_Put_With_Tags(t *testing.T) {
+	s := newSegment()
+	s.getNow = monoGetNow(100)
+
+	tags := []uint64{memhash.HashString("user:1"), memhash.HashString("profile")}
+	s.putWithParams(40, []byte{1, 2, 3}, []byte{10, 11, 12, 13, 14}, putParams{tags: tags})
+
+	assert.Equal(t, &entryHeader{
+		hash:       40,
+		accessTime: 101,
+		keyLen:     3,
+		flags:      entryFlagExtended,
+		valLen:     5,
+		valCap:     5,
+		tagCount:   2,
+	}, s.getHeader(40))
+	assert.Equal(t, entryHeaderSize+entryExtendedSize+3+2*tagSize+5, s.rb.size)
+
+	data := make([]byte, 10)
+	n, ok := s.getAndApply(40, []byte{1, 2, 3}, data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, []byte{10, 11, 12, 13, 14}, data[:n])
+}
+
+func TestSegment_Put_With_Tags_Existing_Same_Tag_Count(t *testing.T) {
+	s := newSegment()
+
+	tag1 := []uint64{memhash.HashString("tag1")}
+	tag2 := []uint64{memhash.HashString("tag2")}
+	s.putWithParams(40, []byte{1, 2, 3}, []byte{10, 11, 12, 13, 14}, putParams{tags: tag1})
+	s.putWithParams(40, []byte{1, 2, 3}, []byte{20, 21}, putParams{tags: tag2})
+	assert.Equal(t, uint64(0), s.getDeadBytes())
+
+	s.invalidator.invalidateTag(tag1[0])
+
+	data := make([]byte, 10)
+	n, ok := s.getAndApply(40, []byte{1, 2, 3}, data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, []byte{20, 21}, data[:n])
+
+	s.invalidator.invalidateTag(tag2[0])
+	_, ok = s.getAndApply(40, []byte{1, 2, 3}, data)
+	assert.Equal(t, false, ok)
+	assert.Equal(t, uint64(0), s.getTotal())
+}
+
+func TestSegment_Put_With_Tags_Existing_Different_Tag_Count(t *testing.T) {
+	s := newSegment()
+
+	tags := []uint64{memhash.HashString("tag1"), memhash.HashString("tag2")}
+	s.putWithParams(40, []byte{1, 2, 3}, []byte{10, 11, 12, 13, 14}, putParams{tags: tags})
+	s.put(40, []byte{1, 2, 3}, []byte{20, 21})
+	assert.Equal(t, uint64(entryHeaderSize+entryExtendedSize+3+2*tagSize+5), s.getDeadBytes())
+
+	s.invalidator.invalidateTag(tags[0])
+
+	data := make([]byte, 10)
+	n, ok := s.getAndApply(40, []byte{1, 2, 3}, data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, []byte{20, 21}, data[:n])
+}
+
+func TestSegment_Evacuate_Drop_Invalidated(t *testing.T) {
+	s := newSegmentSize(3 * (entryHeaderSize + entryExtendedSize + 4 + tagSize + 4))
+
+	tags := []uint64{memhash.HashString("tag")}
+	s.putWithParams(40, []byte{1, 2, 3, 4}, []byte{10, 11, 12, 13}, putParams{tags: tags})
+	s.putWithParams(41, []byte{2, 2, 3, 4}, []byte{10, 11, 12, 13}, putParams{tags: tags})
+	s.putWithParams(42, []byte{3, 2, 3, 4}, []byte{10, 11, 12, 13}, putParams{tags: tags})
+
+	data := make([]byte, 10)
+	s.getAndApply(40, []byte{1, 2, 3, 4}, data)
+	s.getAndApply(41, []byte{2, 2, 3, 4}, data)
+
+	s.invalidator.invalidateTag(tags[0])
+	s.put(43, []byte{4, 2, 3, 4}, []byte{10, 11, 12, 13, 14, 15, 16, 17})
+
+	// the recently used head is dropped instead of relocated
+	_, existed := s.kv[40]
+	assert.Equal(t, false, existed)
+	assert.Equal(t, uint64(3), s.getTotal())
+
+	_, ok := s.getAndApply(43, []byte{4, 2, 3, 4}, data)
+	assert.Equal(t, true, ok)
+}
+
+func TestSegment_Sweep_Invalidated(t *testing.T) {
+	s := newSegment()
+
+	tags := []uint64{memhash.HashString("tag")}
+	s.putWithParams(40, []byte{1, 2, 3, 4}, []byte{10}, putParams{tags: tags})
+	s.put(41, []byte{2, 2, 3, 4}, []byte{10})
+	s.putWithParams(42, []byte{3, 2, 3, 4}, []byte{10}, putParams{tags: tags})
+
+	s.invalidator.invalidateTag(tags[0])
+
+	deleted, done := s.sweepExpired(10)
+	assert.Equal(t, 2, deleted)
+	assert.Equal(t, true, done)
+	assert.Equal(t, uint64(1), s.getTotal())
+	assert.Equal(t, uint64(0), s.getExpiredCount())
+}
+
+func TestCache_Invalidate_Tag(t *testing.T) {
+	c := New(4, 1<<16)
+
+	c.PutWithTags([]byte("user:1:profile"), []byte("profile"), "user:1")
+	c.PutWithTags([]byte("user:1:friends"), []byte("friends"), "user:1", "friends")
+	c.PutWithTags([]byte("user:2:profile"), []byte("profile"), "user:2")
+	c.Put([]byte("global"), []byte("value"))
+
+	c.InvalidateTag("user:1")
+
+	data := make([]byte, 20)
+	_, ok := c.Get([]byte("user:1:profile"), data)
+	assert.Equal(t, false, ok)
+	_, ok = c.Get([]byte("user:1:friends"), data)
+	assert.Equal(t, false, ok)
+
+	n, ok := c.Get([]byte("user:2:profile"), data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "profile", string(data[:n]))
+
+	n, ok = c.Get([]byte("global"), data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "value", string(data[:n]))
+
+	assert.Equal(t, uint64(2), c.GetTotal())
+	assert.Equal(t, false, c.Delete([]byte("user:1:profile")))
+}
+
+func TestCache_Invalidate_Tag_Put_After(t *testing.T) {
+	c := New(4, 1<<16)
+
+	c.PutWithTags([]byte("key01"), []byte("value01"), "tag")
+	c.InvalidateTag("tag")
+	c.PutWithTags([]byte("key01"), []byte("value02"), "tag")
+
+	data := make([]byte, 20)
+	n, ok := c.Get([]byte("key01"), data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "value02", string(data[:n]))
+}
+
+func TestCache_Invalidate_Tag_Pruned(t *testing.T) {
+	c := New(4, 1<<16)
+	c.invalidator.maxTags = 4
+
+	c.PutWithTags([]byte("old"), []byte("value"), "other")
+	c.Put([]byte("untagged"), []byte("value"))
+	for i := 0; i < 5; i++ {
+		c.InvalidateTag(fmt.Sprintf("tag:%d", i))
+	}
+	c.PutWithTags([]byte("new"), []byte("value"), "other")
+
+	table := c.invalidator.getTable()
+	assert.Equal(t, 2, len(table.tags)+len(table.recentTags))
+	assert.Equal(t, uint32(3), table.tagFloor)
+
+	// the entries with tags put before the dropped tags are invalidated
+	_, ok := c.Get([]byte("old"), nil)
+	assert.Equal(t, false, ok)
+	_, ok = c.Get([]byte("untagged"), nil)
+	assert.Equal(t, true, ok)
+	_, ok = c.Get([]byte("new"), nil)
+	assert.Equal(t, true, ok)
+
+	c.InvalidateTag("tag:4")
+	_, ok = c.Get([]byte("new"), nil)
+	assert.Equal(t, true, ok)
+}
+
+func TestCache_Invalidate_Tag_Pruned_Without_Live_Entries(t *testing.T) {
+	c := New(4, 1<<16)
+	c.invalidator.maxTags = 4
+
+	c.PutWithTags([]byte("old"), []byte("value"), "tag:0")
+	for i := 0; i < 3; i++ {
+		c.InvalidateTag(fmt.Sprintf("tag:%d", i))
+	}
+	_, ok := c.Get([]byte("old"), nil)
+	assert.Equal(t, false, ok)
+
+	c.PutWithTags([]byte("new"), []byte("value"), "other")
+	for i := 3; i < 5; i++ {
+		c.InvalidateTag(fmt.Sprintf("tag:%d", i))
+	}
+
+	// the tags older than the live tagged entries are dropped without raising the floor
+	table := c.invalidator.getTable()
+	assert.Equal(t, 2, len(table.tags)+len(table.recentTags))
+	assert.Equal(t, uint32(0), table.tagFloor)
+
+	_, ok = c.Get([]byte("new"), nil)
+	assert.Equal(t, true, ok)
+}
+
+func TestCache_Invalidate_Tag_Merge_Recent(t *testing.T) {
+	c := New(4, 1<<16)
+
+	c.PutWithTags([]byte("key01"), []byte("value"), "tag:0")
+	for i := 0; i < maxRecentTags+10; i++ {
+		c.InvalidateTag(fmt.Sprintf("tag:%d", i+1))
+	}
+
+	table := c.invalidator.getTable()
+	assert.Equal(t, maxRecentTags, len(table.tags))
+	assert.Equal(t, 10, len(table.recentTags))
+
+	_, ok := c.Get([]byte("key01"), nil)
+	assert.Equal(t, true, ok)
+
+	c.InvalidateTag("tag:0")
+	_, ok = c.Get([]byte("key01"), nil)
+	assert.Equal(t, false, ok)
+}
+
+func TestCache_Delete_Prefix_Pruned(t *testing.T) {
+	c := New(4, 1<<16)
+	c.invalidator.maxPrefixes = 4
+
+	c.Put([]byte("old:1"), []byte("value"))
+	c.Put([]byte("other"), []byte("value"))
+	ns := c.Namespace("ns", 1<<10)
+	ns.Put([]byte("old:2"), []byte("value"))
+
+	for i := 0; i < 5; i++ {
+		c.DeletePrefix([]byte(fmt.Sprintf("prefix:%d", i)))
+	}
+	c.Put([]byte("new"), []byte("value"))
+
+	table := c.invalidator.getTable()
+	assert.Equal(t, 2, table.prefixCount)
+	assert.Equal(t, map[keySpace]uint32{{}: 3}, table.prefixFloors)
+
+	// the entries of the key space put before the dropped prefixes are invalidated
+	_, ok := c.Get([]byte("old:1"), nil)
+	assert.Equal(t, false, ok)
+	_, ok = c.Get([]byte("other"), nil)
+	assert.Equal(t, false, ok)
+	_, ok = c.Get([]byte("new"), nil)
+	assert.Equal(t, true, ok)
+	_, ok = ns.Get([]byte("old:2"), nil)
+	assert.Equal(t, true, ok)
+}
+
+func TestCache_Delete_Prefix_Pruned_Without_Live_Entries(t *testing.T) {
+	c := New(4, 1<<16)
+	c.invalidator.maxPrefixes = 4
+
+	c.Put([]byte("prefix:0:key"), []byte("value"))
+	for i := 0; i < 3; i++ {
+		c.DeletePrefix([]byte(fmt.Sprintf("prefix:%d", i)))
+	}
+	c.Delete([]byte("prefix:0:key"))
+	c.Put([]byte("key"), []byte("value"))
+	for i := 3; i < 5; i++ {
+		c.DeletePrefix([]byte(fmt.Sprintf("prefix:%d", i)))
+	}
+
+	// the prefixes older than the live entries of the key space are dropped without raising the floor
+	table := c.invalidator.getTable()
+	assert.Equal(t, 2, table.prefixCount)
+	assert.Equal(t, 0, len(table.prefixFloors))
+
+	_, ok := c.Get([]byte("key"), nil)
+	assert.Equal(t, true, ok)
+}
+
+func TestCache_Delete_Prefix(t *testing.T) {
+	c := New(4, 1<<16)
+
+	c.Put([]byte("user:1:profile"), []byte("profile"))
+	c.Put([]byte("user:1:friends"), []byte("friends"))
+	c.Put([]byte("user:10:profile"), []byte("profile"))
+	c.Put([]byte("user:"), []byte("short"))
+
+	c.DeletePrefix([]byte("user:1:"))
+
+	data := make([]byte, 20)
+	_, ok := c.Get([]byte("user:1:profile"), data)
+	assert.Equal(t, false, ok)
+	_, ok = c.Get([]byte("user:1:friends"), data)
+	assert.Equal(t, false, ok)
+
+	_, ok = c.Get([]byte("user:10:profile"), data)
+	assert.Equal(t, true, ok)
+	_, ok = c.Get([]byte("user:"), data)
+	assert.Equal(t, true, ok)
+
+	c.Put([]byte("user:1:profile"), []byte("new"))
+	n, ok := c.Get([]byte("user:1:profile"), data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "new", string(data[:n]))
+}
+
+func TestCache_Delete_Prefix_Swept_By_Janitor(t *testing.T) {
+	c := New(1, 1<<16)
+
+	c.Put([]byte("a:1"), []byte("v"))
+	c.Put([]byte("a:2"), []byte("v"))
+	c.Put([]byte("b:1"), []byte("v"))
+
+	c.DeletePrefix([]byte("a:"))
+
+	j := NewJanitor(c, 1, 100)
+	j.sweep(context.Background())
+	assert.Equal(t, uint64(2), j.GetSweptCount())
+	assert.Equal(t, uint64(1), c.GetTotal())
+}
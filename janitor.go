@@ -0,0 +1,68 @@
+package bigcache
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+const defaultJanitorStepSize = 64
+
+// Janitor is a background goroutine periodically removing expired and invalidated entries, each segment is
+// walked in small steps, the segment lock is held for at most stepSize entries at a time
+type Janitor struct {
+	cache    *Cache
+	stepSize int
+	worker   *worker
+
+	sweptCount uint64
+}
+
+// NewJanitor creates a janitor sweeping the cache every interval, stepSize <= 0 means the default (64)
+func NewJanitor(cache *Cache, interval time.Duration, stepSize int) *Janitor {
+	if stepSize <= 0 {
+		stepSize = defaultJanitorStepSize
+	}
+	j := &Janitor{
+		cache:    cache,
+		stepSize: stepSize,
+	}
+	j.worker = newWorker("janitor", interval, j.sweep)
+	return j
+}
+
+// Start runs the janitor in a new goroutine until ctx is cancelled or Close is called
+func (j *Janitor) Start(ctx context.Context) {
+	j.worker.start(ctx)
+}
+
+// Close stops the janitor and waits for the goroutine to finish
+func (j *Janitor) Close() {
+	j.worker.close()
+}
+
+// GetSweptCount returns the number of expired and invalidated entries removed by the janitor
+func (j *Janitor) GetSweptCount() uint64 {
+	return atomic.LoadUint64(&j.sweptCount)
+}
+
+// sweep walks all segments once
+func (j *Janitor) sweep(ctx context.Context) {
+	for i := range j.cache.segments {
+		seg := &j.cache.segments[i]
+		for {
+			if ctx.Err() != nil {
+				return
+			}
+
+			seg.mu.Lock()
+			deleted, done := seg.sweepExpired(j.stepSize)
+			seg.mu.Unlock()
+
+			atomic.AddUint64(&j.sweptCount, uint64(deleted))
+			if done {
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package bigcache
+
+import (
+	"context"
+	"github.com/QuangTung97/bigcache/bigcachetest"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestJanitor_Sweep(t *testing.T) {
+	clock := bigcachetest.NewFakeClock(0)
+	c := New(4, 1<<16, WithClock(clock))
+
+	for i := 0; i < 100; i++ {
+		ttl := time.Duration(0)
+		if i%4 == 0 {
+			ttl = 10 * time.Second
+		}
+		c.PutWithTTL([]byte{1, 2, uint8(i)}, []byte{10, 20, 30}, ttl)
+	}
+
+	j := NewJanitor(c, time.Second, 3)
+
+	j.sweep(context.Background())
+	assert.Equal(t, uint64(0), j.GetSweptCount())
+	assert.Equal(t, uint64(100), c.GetTotal())
+
+	clock.Advance(10 * time.Second)
+
+	j.sweep(context.Background())
+	assert.Equal(t, uint64(25), j.GetSweptCount())
+	assert.Equal(t, uint64(75), c.GetTotal())
+	assert.Equal(t, uint64(25), c.GetExpiredCount())
+
+	for i := range c.segments {
+		assert.Equal(t, len(c.segments[i].kv), int(c.segments[i].getTotal()))
+	}
+}
+
+func TestJanitor_Start_Close(t *testing.T) {
+	clock := bigcachetest.NewFakeClock(0)
+	c := New(2, 1<<16, WithClock(clock))
+	c.PutWithTTL([]byte{1, 2, 3}, []byte{10}, time.Second)
+	clock.Advance(time.Second)
+
+	j := NewJanitor(c, time.Millisecond, 0)
+	j.Start(context.Background())
+
+	assert.Eventually(t, func() bool {
+		return j.GetSweptCount() == 1
+	}, time.Second, time.Millisecond)
+
+	j.Close()
+	j.Close()
+	assert.Equal(t, uint64(0), c.GetTotal())
+}
+
+func TestJanitor_Stop_By_Context(t *testing.T) {
+	c := New(2, 1<<16)
+	j := NewJanitor(c, time.Millisecond, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	j.Start(ctx)
+	cancel()
+	j.Close()
+
+	assert.PanicsWithValue(t, "janitor already started", func() {
+		j.Start(context.Background())
+	})
+}
+
+func TestJanitor_Close_Without_Start(t *testing.T) {
+	j := NewJanitor(New(1, 1024), time.Second, 0)
+	j.Close()
+	assert.Equal(t, defaultJanitorStepSize, j.stepSize)
+}
+
+func TestNewJanitor_Panic(t *testing.T) {
+	assert.PanicsWithValue(t, "janitor interval must be > 0", func() {
+		NewJanitor(New(1, 1024), 0, 0)
+	})
+}
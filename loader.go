@@ -0,0 +1,195 @@
+package bigcache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrNotFound is returned by LoadingCache.GetOrLoad when the key is known to not exist (see Cache.PutNegative)
+var ErrNotFound = errors.New("bigcache: key not found")
+
+const defaultLoadTimeout = 30 * time.Second
+
+// LoaderFunc loads the value of a key from the source of truth
+type LoaderFunc func(ctx context.Context, key []byte) ([]byte, error)
+
+// LoadingCache loads missing values through the loader and refreshes stale values in the background:
+// after staleTTL, GetOrLoad returns the stale value immediately and triggers one asynchronous refresh,
+// after ttl the entry is a miss and is loaded synchronously. Concurrent loads of the same key are merged
+type LoadingCache struct {
+	cache    *Cache
+	loader   LoaderFunc
+	staleTTL time.Duration
+	ttl      time.Duration
+
+	loadTimeout time.Duration
+
+	refreshCount uint64
+
+	mu    sync.Mutex
+	calls map[string]*loadCall
+	wg    sync.WaitGroup
+}
+
+type loadCall struct {
+	done  chan struct{}
+	value []byte
+	err   error
+}
+
+// LoadingOption configures a LoadingCache
+type LoadingOption func(l *LoadingCache)
+
+// WithLoadTimeout limits the duration of each load, the load is detached from the callers
+// so the timeout is the only way to cancel a hung loader, default 30 seconds
+func WithLoadTimeout(d time.Duration) LoadingOption {
+	return func(l *LoadingCache) {
+		l.loadTimeout = d
+	}
+}
+
+// NewLoadingCache ...
+func NewLoadingCache(
+	cache *Cache, loader LoaderFunc, staleTTL time.Duration, ttl time.Duration, opts ...LoadingOption,
+) *LoadingCache {
+	if staleTTL <= 0 || ttl <= 0 || staleTTL > ttl {
+		panic("must be 0 < staleTTL <= ttl")
+	}
+	l := &LoadingCache{
+		cache:       cache,
+		loader:      loader,
+		staleTTL:    staleTTL,
+		ttl:         ttl,
+		loadTimeout: defaultLoadTimeout,
+		calls:       map[string]*loadCall{},
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	if l.loadTimeout <= 0 {
+		panic("load timeout must be positive")
+	}
+	return l
+}
+
+// GetOrLoad returns the cached value or loads it through the loader,
+// the value returned by a load is shared between concurrent callers and must not be modified.
+// A negative entry returns ErrNotFound without calling the loader
+func (l *LoadingCache) GetOrLoad(ctx context.Context, key []byte) ([]byte, error) {
+	value, result := l.lookup(key)
+	switch result {
+	case LookupHit:
+		return value, nil
+	case LookupStale:
+		l.refresh(key)
+		return value, nil
+	case LookupNegative:
+		return nil, ErrNotFound
+	default:
+	}
+
+	call, _ := l.getOrStartCall(ctx, key)
+	select {
+	case <-call.done:
+		return call.value, call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Close waits for the background refreshes to finish
+func (l *LoadingCache) Close() {
+	l.wg.Wait()
+}
+
+// GetRefreshCount returns the number of background refreshes started
+func (l *LoadingCache) GetRefreshCount() uint64 {
+	return atomic.LoadUint64(&l.refreshCount)
+}
+
+func (l *LoadingCache) lookup(key []byte) ([]byte, LookupResult) {
+	var buf [defaultTypedBufferSize]byte
+	value := buf[:]
+	for {
+		n, result := l.cache.Lookup(key, value)
+		if !result.IsFound() {
+			return nil, result
+		}
+		if n <= len(value) {
+			data := make([]byte, n)
+			copy(data, value[:n])
+			return data, result
+		}
+		value = make([]byte, n)
+	}
+}
+
+func (l *LoadingCache) refresh(key []byte) {
+	_, started := l.getOrStartCall(context.Background(), key)
+	if started {
+		atomic.AddUint64(&l.refreshCount, 1)
+	}
+}
+
+// getOrStartCall returns the running load of the key or starts a new one in the background
+func (l *LoadingCache) getOrStartCall(ctx context.Context, key []byte) (*loadCall, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if call, ok := l.calls[string(key)]; ok {
+		return call, false
+	}
+
+	call := &loadCall{done: make(chan struct{})}
+	keyStr := string(key)
+	l.calls[keyStr] = call
+
+	// the load must not be cancelled by the first caller, but must not hold the call forever
+	ctx, cancel := context.WithTimeout(detachedContext{parent: ctx}, l.loadTimeout)
+
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		defer cancel()
+		l.load(ctx, keyStr, call)
+	}()
+	return call, true
+}
+
+func (l *LoadingCache) load(ctx context.Context, key string, call *loadCall) {
+	keyBytes := []byte(key)
+	call.value, call.err = l.loader(ctx, keyBytes)
+	if call.err == nil {
+		l.cache.PutWithStaleTTL(keyBytes, call.value, l.staleTTL, l.ttl)
+	}
+
+	l.mu.Lock()
+	delete(l.calls, key)
+	l.mu.Unlock()
+
+	close(call.done)
+}
+
+// detachedContext keeps the values of the parent but not its deadline and cancellation
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
@@ -0,0 +1,205 @@
+package bigcache
+
+import (
+	"context"
+	"errors"
+	"github.com/QuangTung97/bigcache/bigcachetest"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type loaderTest struct {
+	clock *bigcachetest.FakeClock
+	cache *Cache
+
+	calls   int32
+	release chan struct{}
+	err     error
+}
+
+func newLoaderTest() *loaderTest {
+	clock := bigcachetest.NewFakeClock(0)
+	return &loaderTest{
+		clock: clock,
+		cache: New(4, 1<<14, WithClock(clock)),
+	}
+}
+
+func (l *loaderTest) load(_ context.Context, key []byte) ([]byte, error) {
+	n := atomic.AddInt32(&l.calls, 1)
+	if l.release != nil {
+		<-l.release
+	}
+	if l.err != nil {
+		return nil, l.err
+	}
+	return append([]byte(string(key)+":"), byte('0'+n)), nil
+}
+
+func TestCache_Put_With_Stale_TTL(t *testing.T) {
+	clock := bigcachetest.NewFakeClock(0)
+	c := New(4, 12345, WithClock(clock))
+	c.PutWithStaleTTL([]byte("key01"), []byte("value01"), 5*time.Second, 10*time.Second)
+
+	value := make([]byte, 20)
+
+	n, result := c.Lookup([]byte("key01"), value)
+	assert.Equal(t, LookupHit, result)
+	assert.Equal(t, "value01", string(value[:n]))
+
+	clock.Advance(5 * time.Second)
+	n, result = c.Lookup([]byte("key01"), value)
+	assert.Equal(t, LookupStale, result)
+	assert.Equal(t, "value01", string(value[:n]))
+
+	n, ok := c.Get([]byte("key01"), value)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "value01", string(value[:n]))
+
+	clock.Advance(5 * time.Second)
+	_, result = c.Lookup([]byte("key01"), value)
+	assert.Equal(t, LookupMiss, result)
+
+	assert.Equal(t, "stale", LookupStale.String())
+}
+
+func TestLoadingCache_Load_And_Refresh(t *testing.T) {
+	l := newLoaderTest()
+	lc := NewLoadingCache(l.cache, l.load, 5*time.Second, 10*time.Second)
+	ctx := context.Background()
+
+	value, err := lc.GetOrLoad(ctx, []byte("key"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "key:1", string(value))
+
+	value, err = lc.GetOrLoad(ctx, []byte("key"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "key:1", string(value))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&l.calls))
+
+	// stale: the old value is returned and refreshed in background
+	l.clock.Advance(5 * time.Second)
+	l.release = make(chan struct{})
+
+	value, err = lc.GetOrLoad(ctx, []byte("key"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "key:1", string(value))
+
+	value, err = lc.GetOrLoad(ctx, []byte("key"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "key:1", string(value))
+
+	close(l.release)
+	lc.Close()
+
+	assert.Equal(t, int32(2), atomic.LoadInt32(&l.calls))
+	assert.Equal(t, uint64(1), lc.GetRefreshCount())
+
+	value, err = lc.GetOrLoad(ctx, []byte("key"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "key:2", string(value))
+
+	// expired: loaded synchronously
+	l.clock.Advance(10 * time.Second)
+	value, err = lc.GetOrLoad(ctx, []byte("key"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "key:3", string(value))
+}
+
+func TestLoadingCache_Merge_Concurrent_Loads(t *testing.T) {
+	l := newLoaderTest()
+	l.release = make(chan struct{})
+	lc := NewLoadingCache(l.cache, l.load, 5*time.Second, 10*time.Second)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, err := lc.GetOrLoad(context.Background(), []byte("key"))
+			assert.Equal(t, nil, err)
+			assert.Equal(t, "key:1", string(value))
+		}()
+	}
+
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&l.calls) == 1
+	}, time.Second, time.Millisecond)
+
+	close(l.release)
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&l.calls))
+}
+
+func TestLoadingCache_Load_Error(t *testing.T) {
+	l := newLoaderTest()
+	l.err = errors.New("load error")
+	lc := NewLoadingCache(l.cache, l.load, 5*time.Second, 10*time.Second)
+
+	value, err := lc.GetOrLoad(context.Background(), []byte("key"))
+	assert.Equal(t, l.err, err)
+	assert.Nil(t, value)
+	assert.Equal(t, uint64(0), l.cache.GetTotal())
+}
+
+func TestLoadingCache_Context_Cancelled(t *testing.T) {
+	l := newLoaderTest()
+	l.release = make(chan struct{})
+	lc := NewLoadingCache(l.cache, l.load, 5*time.Second, 10*time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := lc.GetOrLoad(ctx, []byte("key"))
+	assert.Equal(t, context.Canceled, err)
+
+	close(l.release)
+	lc.Close()
+
+	// the load still finishes and fills the cache
+	value, err := lc.GetOrLoad(context.Background(), []byte("key"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "key:1", string(value))
+}
+
+func TestLoadingCache_Negative(t *testing.T) {
+	l := newLoaderTest()
+	lc := NewLoadingCache(l.cache, l.load, 5*time.Second, 10*time.Second)
+	l.cache.PutNegative([]byte("key"), 10*time.Second)
+
+	value, err := lc.GetOrLoad(context.Background(), []byte("key"))
+	assert.Equal(t, ErrNotFound, err)
+	assert.Nil(t, value)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&l.calls))
+}
+
+func TestLoadingCache_Load_Timeout(t *testing.T) {
+	l := newLoaderTest()
+	loader := func(ctx context.Context, key []byte) ([]byte, error) {
+		atomic.AddInt32(&l.calls, 1)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	lc := NewLoadingCache(l.cache, loader, 5*time.Second, 10*time.Second, WithLoadTimeout(10*time.Millisecond))
+
+	_, err := lc.GetOrLoad(context.Background(), []byte("key"))
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	// the timed out load released its call, the next caller loads again
+	_, err = lc.GetOrLoad(context.Background(), []byte("key"))
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&l.calls))
+	lc.Close()
+}
+
+func TestNewLoadingCache_Panic(t *testing.T) {
+	assert.PanicsWithValue(t, "must be 0 < staleTTL <= ttl", func() {
+		NewLoadingCache(New(1, 1024), nil, 10*time.Second, 5*time.Second)
+	})
+	assert.PanicsWithValue(t, "load timeout must be positive", func() {
+		NewLoadingCache(New(1, 1024), nil, 5*time.Second, 10*time.Second, WithLoadTimeout(0))
+	})
+}
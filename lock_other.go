@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd
+
+package bigcache
+
+import "os"
+
+// lockFile does not lock, Open fails anyway without mmap support
+func lockFile(*os.File) error {
+	return nil
+}
@@ -0,0 +1,143 @@
+package bigcache
+
+import (
+	"time"
+)
+
+// LookupResult is the result of Cache.Lookup
+type LookupResult int
+
+const (
+	// LookupMiss means the key is not in the cache
+	LookupMiss LookupResult = iota
+	// LookupHit means the key is found with its value
+	LookupHit
+	// LookupNegative means the key is known to not exist (see Cache.PutNegative)
+	LookupNegative
+	// LookupStale means the key is found with its value but the value should be refreshed (see Cache.PutWithStaleTTL)
+	LookupStale
+)
+
+// String ...
+func (r LookupResult) String() string {
+	switch r {
+	case LookupHit:
+		return "hit"
+	case LookupNegative:
+		return "negative"
+	case LookupStale:
+		return "stale"
+	default:
+		return "miss"
+	}
+}
+
+// IsFound returns true if the value is found, including stale values
+func (r LookupResult) IsFound() bool {
+	return r == LookupHit || r == LookupStale
+}
+
+// PutNegative caches that the key does not exist, the entry only contains the key and expires after ttl,
+// ttl <= 0 means never expire. A later Put of the same key replaces the negative entry
+func (c *Cache) PutNegative(key []byte, ttl time.Duration) {
+	seg, hash := c.getSegment(key)
+	params := putParams{
+		expireAt: c.computeExpireAt(ttl),
+		flags:    entryFlagNegative,
+	}
+
+	c.putIntoSegment(seg, hash, key, nil, params)
+}
+
+// Lookup is the same as Get but distinguishes between a miss and a negative entry
+func (c *Cache) Lookup(key []byte, value []byte) (int, LookupResult) {
+	seg, hash := c.getSegment(key)
+	return c.lookupEntry(seg, hash, keySpace{}, key, value)
+}
+
+// LookupString is the same as Lookup but with string key, without allocation
+func (c *Cache) LookupString(key string, value []byte) (int, LookupResult) {
+	seg, hash := c.getSegmentString(key)
+	return c.lookupEntry(seg, hash, keySpace{}, stringBytes(key), value)
+}
+
+// GetNegativeHitCount returns the number of lookups found negative entries
+func (c *Cache) GetNegativeHitCount() uint64 {
+	count := uint64(0)
+	for i := range c.segments {
+		count += c.segments[i].getNegativeHitCount()
+	}
+	return count
+}
+
+// entryLookup is a key looked up in its segment
+type entryLookup struct {
+	seg   *segment
+	hash  uint64
+	space keySpace
+	key   []byte
+}
+
+// lookupEncodedValue decodes the value found with n bytes of stored data, result contains the encoding bits
+func (c *Cache) lookupEncodedValue(l entryLookup, value []byte, n int, result LookupResult) (int, LookupResult) {
+	// the stored value is copied so that the value buffer does not escape through the interface calls
+	src := c.compressionPool.get()
+	defer c.compressionPool.put(src)
+
+	if !c.copyStoredValue(l, value, n, result, src) {
+		// replaced concurrently
+		return c.lookupEntry(l.seg, l.hash, l.space, l.key, value)
+	}
+	data := *src
+	key := l.key
+
+	if keyID := result.keyID(); keyID != 0 {
+		if c.keyring == nil {
+			return 0, LookupMiss
+		}
+		buf := c.compressionPool.get()
+		defer c.compressionPool.put(buf)
+
+		// the key is copied for the same reason as the value
+		*buf = append((*buf)[:0], key...)
+		decrypted, err := c.keyring.decrypt((*buf)[len(key):], keyID, data, (*buf)[:len(key)])
+		if err != nil {
+			return 0, LookupMiss
+		}
+		data = decrypted
+	}
+
+	if result&lookupCompressed != 0 {
+		if c.compressor == nil {
+			return 0, LookupMiss
+		}
+		buf := c.compressionPool.get()
+		defer c.compressionPool.put(buf)
+
+		decompressed, err := c.compressor.Decompress((*buf)[:0], data)
+		*buf = decompressed[:0]
+		if err != nil {
+			return 0, LookupMiss
+		}
+		data = decompressed
+	}
+
+	copy(value, data)
+	return len(data), result &^ lookupFlagMask
+}
+
+// copyStoredValue copies the n bytes of stored data into src, from value if it was big enough
+// or looked up again, returns false if the entry was replaced since
+func (c *Cache) copyStoredValue(l entryLookup, value []byte, n int, result LookupResult, src *[]byte) bool {
+	if n <= len(value) {
+		*src = append((*src)[:0], value[:n]...)
+		return true
+	}
+
+	if cap(*src) < n {
+		*src = make([]byte, n)
+	}
+	*src = (*src)[:n]
+	storedLen, storedResult := c.lookupStored(l.seg, l.hash, l.space, l.key, *src)
+	return storedResult == result && storedLen == n
+}
@@ -0,0 +1,95 @@
+package bigcache
+
+import (
+	"github.com/QuangTung97/bigcache/bigcachetest"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestSegment_Put_Negative(t *testing.T) {
+	s := newSegment()
+	s.getNow = monoGetNow(100)
+
+	s.putWithParams(40, []byte{1, 2, 3}, nil, putParams{flags: entryFlagNegative})
+
+	assert.Equal(t, &entryHeader{
+		hash:       40,
+		accessTime: 101,
+		keyLen:     3,
+		flags:      entryFlagNegative,
+		valLen:     0,
+		valCap:     1,
+	}, s.getHeader(40))
+	assert.Equal(t, entryHeaderSize+4, s.rb.size)
+	assert.Equal(t, uint64(1), s.getTotal())
+
+	data := make([]byte, 10)
+	n, result, _ := s.getShared(40, keySpace{}, []byte{1, 2, 3}, data)
+	assert.Equal(t, LookupNegative, result)
+	assert.Equal(t, 0, n)
+
+	n, ok := s.getAndApply(40, []byte{1, 2, 3}, data)
+	assert.Equal(t, false, ok)
+	assert.Equal(t, 0, n)
+
+	assert.Equal(t, uint64(2), s.getNegativeHitCount())
+	assert.Equal(t, uint64(0), s.getHitCount())
+}
+
+func TestSegment_Put_Replace_Negative(t *testing.T) {
+	s := newSegment()
+
+	s.putWithParams(40, []byte{1, 2, 3, 4}, nil, putParams{flags: entryFlagNegative})
+	s.put(40, []byte{1, 2, 3, 4}, []byte{10, 11, 12, 13})
+
+	data := make([]byte, 10)
+	n, result, _ := s.getShared(40, keySpace{}, []byte{1, 2, 3, 4}, data)
+	assert.Equal(t, LookupHit, result)
+	assert.Equal(t, []byte{10, 11, 12, 13}, data[:n])
+	assert.Equal(t, uint8(0), s.getHeader(40).flags)
+
+	s.putWithParams(40, []byte{1, 2, 3, 4}, nil, putParams{flags: entryFlagNegative})
+	_, result, _ = s.getShared(40, keySpace{}, []byte{1, 2, 3, 4}, data)
+	assert.Equal(t, LookupNegative, result)
+	assert.Equal(t, uint64(1), s.getTotal())
+}
+
+func TestCache_Put_Negative(t *testing.T) {
+	clock := bigcachetest.NewFakeClock(0)
+	c := New(4, 12345, WithClock(clock))
+
+	c.PutNegative([]byte("key01"), 10*time.Second)
+	c.Put([]byte("key02"), []byte("value02"))
+
+	value := make([]byte, 20)
+
+	n, result := c.Lookup([]byte("key01"), value)
+	assert.Equal(t, LookupNegative, result)
+	assert.Equal(t, 0, n)
+
+	n, ok := c.Get([]byte("key01"), value)
+	assert.Equal(t, false, ok)
+	assert.Equal(t, 0, n)
+
+	n, result = c.LookupString("key02", value)
+	assert.Equal(t, LookupHit, result)
+	assert.Equal(t, "value02", string(value[:n]))
+
+	_, result = c.Lookup([]byte("key03"), value)
+	assert.Equal(t, LookupMiss, result)
+
+	clock.Advance(10 * time.Second)
+	_, result = c.Lookup([]byte("key01"), value)
+	assert.Equal(t, LookupMiss, result)
+
+	assert.Equal(t, uint64(2), c.GetNegativeHitCount())
+	assert.Equal(t, uint64(1), c.GetHitCount())
+	assert.Equal(t, uint64(1), c.GetTotal())
+}
+
+func TestLookupResult_String(t *testing.T) {
+	assert.Equal(t, "miss", LookupMiss.String())
+	assert.Equal(t, "hit", LookupHit.String())
+	assert.Equal(t, "negative", LookupNegative.String())
+}
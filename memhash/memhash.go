@@ -1,6 +1,10 @@
 package memhash
 
-import "unsafe"
+import (
+	"encoding/binary"
+	"math/bits"
+	"unsafe"
+)
 
 // NanoTime returns the current time in nanoseconds from a monotonic clock.
 //go:linkname NanoTime runtime.nanotime
@@ -22,3 +26,54 @@ func Hash(data []byte) uint64 {
 	ss := (*stringStruct)(unsafe.Pointer(&data))
 	return uint64(memhash(ss.str, 0, uintptr(ss.len)))
 }
+
+// HashString is the same as Hash but for string, without converting to []byte.
+func HashString(s string) uint64 {
+	ss := (*stringStruct)(unsafe.Pointer(&s))
+	return uint64(memhash(ss.str, 0, uintptr(ss.len)))
+}
+
+const (
+	seededPrime1 = 0xa0761d6478bd642f
+	seededPrime2 = 0xe7037ed1a0b428db
+	seededPrime3 = 0x8ebc6af09c88c6e3
+)
+
+// SeededHash is a hash function depending only on the seed and the data, unlike Hash it can be used
+// as a persistent hash. It is slower than Hash.
+func SeededHash(seed uint64, data []byte) uint64 {
+	h := seed ^ seededPrime1 ^ uint64(len(data))*seededPrime3
+	for len(data) >= 16 {
+		h = seededMix(binary.LittleEndian.Uint64(data)^seededPrime2, binary.LittleEndian.Uint64(data[8:])^h)
+		data = data[16:]
+	}
+	if len(data) >= 8 {
+		h = seededMix(binary.LittleEndian.Uint64(data)^seededPrime2, h^seededPrime1)
+		data = data[8:]
+	}
+
+	tail := uint64(0)
+	for i := len(data) - 1; i >= 0; i-- {
+		tail = tail<<8 | uint64(data[i])
+	}
+	h = seededMix(tail^seededPrime2, h^seededPrime3)
+	return seededMix(h^seededPrime1, seededPrime2)
+}
+
+// SeededHashString is the same as SeededHash but for string, without converting to []byte.
+func SeededHashString(seed uint64, s string) uint64 {
+	ss := (*stringStruct)(unsafe.Pointer(&s))
+	data := sliceStruct{data: ss.str, len: ss.len, cap: ss.len}
+	return SeededHash(seed, *(*[]byte)(unsafe.Pointer(&data)))
+}
+
+type sliceStruct struct {
+	data unsafe.Pointer
+	len  int
+	cap  int
+}
+
+func seededMix(a, b uint64) uint64 {
+	hi, lo := bits.Mul64(a, b)
+	return hi ^ lo
+}
@@ -0,0 +1,22 @@
+package memhash
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestHashString(t *testing.T) {
+	assert.Equal(t, Hash([]byte("some-key")), HashString("some-key"))
+	assert.Equal(t, Hash(nil), HashString(""))
+}
+
+func TestSeededHash(t *testing.T) {
+	assert.Equal(t, SeededHash(1, []byte("some-key")), SeededHashString(1, "some-key"))
+	assert.Equal(t, SeededHash(1, nil), SeededHashString(1, ""))
+	assert.NotEqual(t, SeededHash(1, []byte("some-key")), SeededHash(2, []byte("some-key")))
+	assert.NotEqual(t, SeededHash(1, []byte("some-key")), SeededHash(1, []byte("some-kez")))
+	assert.NotEqual(t, SeededHash(1, []byte{0}), SeededHash(1, []byte{0, 0}))
+
+	// must not change between processes and versions
+	assert.Equal(t, uint64(0xb7a6edc410ab3287), SeededHash(1, []byte("a key longer than sixteen bytes")))
+}
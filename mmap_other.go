@@ -0,0 +1,18 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd
+
+package bigcache
+
+import "os"
+
+func mmapAnonymous(int) ([]byte, error) {
+	return nil, ErrMmapNotSupported
+}
+
+func mmapFile(*os.File, int) ([]byte, error) {
+	return nil, ErrMmapNotSupported
+}
+
+func munmap([]byte) error {
+	return ErrMmapNotSupported
+}
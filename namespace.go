@@ -0,0 +1,232 @@
+package bigcache
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const maxNamespaces = 1<<16 - 1
+
+// Namespace is a logical cache inside a Cache with its own memory quota, the bytes of its entries are
+// accounted across all segments. When a segment needs space, entries of namespaces over their quota are
+// evicted first, so a noisy namespace can not evict everything else.
+//
+// The id of the namespace is stored in the headers of its entries and mixed into the hashes of its keys,
+// the keys of a namespace never collide with keys of other namespaces nor with keys of the Cache itself
+type Namespace struct {
+	cache *Cache
+	name  string
+	id    uint16
+	quota int64
+
+	usedBytes int64
+}
+
+// keySpace separates the entries with the same key bytes, it is stored in the header of an entry
+// and compared with the key. Entries of the Cache itself have the zero key space,
+// the chunks of big values have their own key space in each namespace
+type keySpace struct {
+	namespace uint16
+	chunk     bool
+}
+
+// mixHash returns the hash of a key of the key space, the same key has different hashes in different key spaces
+func (k keySpace) mixHash(hash uint64) uint64 {
+	if k == (keySpace{}) {
+		return hash
+	}
+	salt := uint64(k.namespace)
+	if k.chunk {
+		salt |= 1 << 16
+	}
+	return (hash ^ salt) * 0x9e3779b97f4a7c15
+}
+
+// chunkSpace returns the key space of the chunks of the values of the key space
+func (k keySpace) chunkSpace() keySpace {
+	return keySpace{namespace: k.namespace, chunk: true}
+}
+
+func (h *entryHeader) keySpace() keySpace {
+	return keySpace{namespace: h.namespace, chunk: h.flags&entryFlagChunk != 0}
+}
+
+// namespaceRegistry is shared by the cache and all segments, namespace ids start from 1,
+// entries of the Cache itself have id 0 and are not accounted
+type namespaceRegistry struct {
+	mu     sync.Mutex
+	byName map[string]*Namespace
+	byID   atomic.Value // []*Namespace indexed by id, copied on write
+
+	// the used bytes of the ids not created yet, of the entries restored by Open
+	pendingUsage map[uint16]int64
+
+	overQuotaCount int32
+}
+
+func newNamespaceRegistry() *namespaceRegistry {
+	r := &namespaceRegistry{
+		byName:       map[string]*Namespace{},
+		pendingUsage: map[uint16]int64{},
+	}
+	r.byID.Store([]*Namespace{nil})
+	return r
+}
+
+// get returns the namespace of the id, nil for id 0 and the ids not created yet
+func (r *namespaceRegistry) get(id uint16) *Namespace {
+	list := r.byID.Load().([]*Namespace)
+	if int(id) >= len(list) {
+		return nil
+	}
+	return list[id]
+}
+
+func (r *namespaceRegistry) hasOverQuota() bool {
+	return atomic.LoadInt32(&r.overQuotaCount) > 0
+}
+
+func (r *namespaceRegistry) addUsage(id uint16, delta int) {
+	if id == 0 {
+		return
+	}
+	if ns := r.get(id); ns != nil {
+		ns.addUsage(delta)
+		return
+	}
+
+	r.mu.Lock()
+	ns := r.get(id)
+	if ns == nil {
+		r.pendingUsage[id] += int64(delta)
+	}
+	r.mu.Unlock()
+
+	if ns != nil {
+		ns.addUsage(delta)
+	}
+}
+
+// Namespace returns the namespace with the name, creating it with quotaBytes if not existed.
+// The quota of an existing namespace is not changed
+func (c *Cache) Namespace(name string, quotaBytes int) *Namespace {
+	if quotaBytes <= 0 {
+		panic("namespace quota must be > 0")
+	}
+
+	r := c.namespaces
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if ns, ok := r.byName[name]; ok {
+		return ns
+	}
+
+	list := r.byID.Load().([]*Namespace)
+	if len(list) > maxNamespaces {
+		panic("too many namespaces")
+	}
+
+	ns := &Namespace{
+		cache: c,
+		name:  name,
+		id:    uint16(len(list)),
+		quota: int64(quotaBytes),
+	}
+	if pending := r.pendingUsage[ns.id]; pending != 0 {
+		delete(r.pendingUsage, ns.id)
+		ns.addUsage(int(pending))
+	}
+
+	newList := make([]*Namespace, len(list)+1)
+	copy(newList, list)
+	newList[ns.id] = ns
+
+	r.byID.Store(newList)
+	r.byName[name] = ns
+	return ns
+}
+
+// Name ...
+func (n *Namespace) Name() string {
+	return n.name
+}
+
+// GetQuota returns the quota in bytes
+func (n *Namespace) GetQuota() int {
+	return int(n.quota)
+}
+
+// GetUsedBytes returns the total size of the entries of the namespace in all segments
+func (n *Namespace) GetUsedBytes() int {
+	return int(atomic.LoadInt64(&n.usedBytes))
+}
+
+func (n *Namespace) isOverQuota() bool {
+	return atomic.LoadInt64(&n.usedBytes) > n.quota
+}
+
+func (n *Namespace) addUsage(delta int) {
+	used := atomic.AddInt64(&n.usedBytes, int64(delta))
+	wasOver := used-int64(delta) > n.quota
+	isOver := used > n.quota
+	if wasOver == isOver {
+		return
+	}
+	if isOver {
+		atomic.AddInt32(&n.cache.namespaces.overQuotaCount, 1)
+	} else {
+		atomic.AddInt32(&n.cache.namespaces.overQuotaCount, -1)
+	}
+}
+
+func (n *Namespace) keySpace() keySpace {
+	return keySpace{namespace: n.id}
+}
+
+// Put ...
+func (n *Namespace) Put(key []byte, value []byte) {
+	n.putWithParams(key, value, putParams{})
+}
+
+// PutWithTTL is the same as Cache.PutWithTTL
+func (n *Namespace) PutWithTTL(key []byte, value []byte, ttl time.Duration) {
+	n.putWithParams(key, value, putParams{expireAt: n.cache.computeExpireAt(ttl)})
+}
+
+func (n *Namespace) putWithParams(key []byte, value []byte, params putParams) {
+	params.namespace = n.id
+	seg, hash := n.cache.getSpaceSegment(n.keySpace(), key)
+	n.cache.putEntry(seg, hash, key, value, params)
+}
+
+// Get is the same as Cache.Get
+func (n *Namespace) Get(key []byte, value []byte) (int, bool) {
+	space := n.keySpace()
+	seg, hash := n.cache.getSpaceSegment(space, key)
+	num, result := n.cache.lookupEntry(seg, hash, space, key, value)
+	return num, result.IsFound()
+}
+
+// Delete ...
+func (n *Namespace) Delete(key []byte) bool {
+	space := n.keySpace()
+	seg, hash := n.cache.getSpaceSegment(space, key)
+	return n.cache.deleteKey(seg, hash, space, key)
+}
+
+// DeletePrefix is the same as Cache.DeletePrefix but only for the keys of the namespace
+func (n *Namespace) DeletePrefix(prefix []byte) {
+	n.cache.invalidator.invalidatePrefix(n.keySpace(), prefix)
+}
+
+// decideQuotaEviction is used while any namespace is over quota: entries of namespaces over quota
+// are dropped and other entries are relocated
+func (s *segment) decideQuotaEviction(header *entryHeader) EvictionDecision {
+	ns := s.namespaces.get(header.namespace)
+	if ns != nil && ns.isOverQuota() {
+		return EvictionDrop
+	}
+	return EvictionRelocate
+}
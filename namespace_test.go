@@ -0,0 +1,199 @@
+package bigcache
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestCache_Namespace_Separate_Keys(t *testing.T) {
+	c := New(4, 1<<16)
+	ns1 := c.Namespace("ns1", 1<<10)
+	ns2 := c.Namespace("ns2", 1<<10)
+
+	assert.Same(t, ns1, c.Namespace("ns1", 1<<20))
+	assert.Equal(t, "ns1", ns1.Name())
+	assert.Equal(t, 1<<10, ns1.GetQuota())
+
+	c.Put([]byte("key01"), []byte("value00"))
+	ns1.Put([]byte("key01"), []byte("value01"))
+	ns2.Put([]byte("key01"), []byte("value02"))
+
+	data := make([]byte, 20)
+	n, ok := c.Get([]byte("key01"), data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "value00", string(data[:n]))
+
+	n, ok = ns1.Get([]byte("key01"), data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "value01", string(data[:n]))
+
+	n, ok = ns2.Get([]byte("key01"), data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "value02", string(data[:n]))
+
+	assert.Equal(t, true, ns1.Delete([]byte("key01")))
+	_, ok = ns1.Get([]byte("key01"), data)
+	assert.Equal(t, false, ok)
+	_, ok = ns2.Get([]byte("key01"), data)
+	assert.Equal(t, true, ok)
+	_, ok = c.Get([]byte("key01"), data)
+	assert.Equal(t, true, ok)
+}
+
+func TestCache_Namespace_Binary_Keys(t *testing.T) {
+	c := New(4, 1<<16)
+	ns := c.Namespace("ns", 1<<10)
+
+	// the key of the Cache starts with the bytes of the former namespace prefix
+	c.Put([]byte("\xff\x00\x01key01"), []byte("value00"))
+	ns.Put([]byte("key01"), []byte("value01"))
+
+	data := make([]byte, 20)
+	n, ok := c.Get([]byte("\xff\x00\x01key01"), data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "value00", string(data[:n]))
+
+	n, ok = ns.Get([]byte("key01"), data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "value01", string(data[:n]))
+
+	c.DeletePrefix([]byte("key"))
+	_, ok = ns.Get([]byte("key01"), data)
+	assert.Equal(t, true, ok)
+
+	assert.Equal(t, false, c.Delete([]byte("key01")))
+	_, ok = ns.Get([]byte("key01"), data)
+	assert.Equal(t, true, ok)
+}
+
+func TestCache_Namespace_Used_Bytes(t *testing.T) {
+	c := New(4, 1<<16)
+	ns := c.Namespace("ns", 1<<10)
+
+	entrySize := entryHeaderSize + entryExtendedSize + 5 + 7
+	ns.Put([]byte("key01"), []byte("value01"))
+	assert.Equal(t, entrySize, ns.GetUsedBytes())
+
+	ns.Put([]byte("key02"), []byte("value02"))
+	assert.Equal(t, 2*entrySize, ns.GetUsedBytes())
+
+	// replace in place
+	ns.Put([]byte("key01"), []byte("value1"))
+	assert.Equal(t, 2*entrySize, ns.GetUsedBytes())
+
+	// replace with a bigger value
+	ns.Put([]byte("key01"), []byte("value0123"))
+	assert.Equal(t, entrySize+entryHeaderSize+entryExtendedSize+5+11, ns.GetUsedBytes()) // aligned to 4
+
+	ns.Delete([]byte("key01"))
+	ns.Delete([]byte("key02"))
+	assert.Equal(t, 0, ns.GetUsedBytes())
+
+	c.Put([]byte("key01"), []byte("value01"))
+	assert.Equal(t, 0, ns.GetUsedBytes())
+}
+
+func TestCache_Namespace_Over_Quota(t *testing.T) {
+	c := New(1, 1<<10)
+	ns := c.Namespace("ns", 100)
+
+	ns.Put([]byte("key01"), make([]byte, 50))
+	assert.Equal(t, false, ns.isOverQuota())
+	assert.Equal(t, false, c.namespaces.hasOverQuota())
+
+	ns.Put([]byte("key02"), make([]byte, 50))
+	assert.Equal(t, true, ns.isOverQuota())
+	assert.Equal(t, true, c.namespaces.hasOverQuota())
+
+	ns.Delete([]byte("key02"))
+	assert.Equal(t, false, ns.isOverQuota())
+	assert.Equal(t, false, c.namespaces.hasOverQuota())
+}
+
+func TestCache_Namespace_Noisy_Does_Not_Evict_Others(t *testing.T) {
+	c := New(1, 1<<16, WithEvictionPolicy(FIFOPolicy{}))
+	quiet := c.Namespace("quiet", 1<<15)
+	noisy := c.Namespace("noisy", 1<<14)
+
+	value := make([]byte, 100)
+	for i := 0; i < 100; i++ {
+		quiet.Put([]byte(fmt.Sprintf("key:%d", i)), value)
+	}
+	for i := 0; i < 5000; i++ {
+		noisy.Put([]byte(fmt.Sprintf("key:%d", i)), value)
+	}
+
+	data := make([]byte, 200)
+	for i := 0; i < 100; i++ {
+		_, ok := quiet.Get([]byte(fmt.Sprintf("key:%d", i)), data)
+		assert.Equal(t, true, ok, i)
+	}
+	assert.Equal(t, true, quiet.GetUsedBytes() < 1<<15)
+}
+
+func TestSegment_Holds_Over_Quota_Bytes(t *testing.T) {
+	c := New(2, 1<<12)
+	ns := c.Namespace("ns", 100)
+
+	// puts into one segment until the namespace is over quota
+	var seg *segment
+	for i := 0; !ns.isOverQuota(); i++ {
+		key := []byte(fmt.Sprintf("key:%d", i))
+		s, _ := c.getSpaceSegment(ns.keySpace(), key)
+		if seg == nil {
+			seg = s
+		}
+		if s == seg {
+			ns.Put(key, make([]byte, 20))
+		}
+	}
+
+	other := &c.segments[0]
+	if other == seg {
+		other = &c.segments[1]
+	}
+	assert.Equal(t, true, seg.holdsOverQuotaBytes())
+	assert.Equal(t, false, other.holdsOverQuotaBytes())
+	assert.Equal(t, ns.GetUsedBytes(), seg.namespaceBytes[ns.id])
+	assert.Equal(t, 0, len(other.namespaceBytes))
+}
+
+func TestCache_Namespace_Noisy_Without_Quota(t *testing.T) {
+	c := New(1, 1<<16, WithEvictionPolicy(FIFOPolicy{}))
+	quiet := c.Namespace("quiet", 1<<15)
+
+	value := make([]byte, 100)
+	for i := 0; i < 100; i++ {
+		quiet.Put([]byte(fmt.Sprintf("key:%d", i)), value)
+	}
+	for i := 0; i < 5000; i++ {
+		c.Put([]byte(fmt.Sprintf("key:%d", i)), value)
+	}
+	assert.Equal(t, 0, quiet.GetUsedBytes())
+}
+
+func TestCache_Namespace_Delete_Prefix(t *testing.T) {
+	c := New(4, 1<<16)
+	ns1 := c.Namespace("ns1", 1<<10)
+	ns2 := c.Namespace("ns2", 1<<10)
+
+	ns1.Put([]byte("user:1"), []byte("value"))
+	ns2.Put([]byte("user:1"), []byte("value"))
+
+	ns1.DeletePrefix([]byte("user:"))
+
+	data := make([]byte, 20)
+	_, ok := ns1.Get([]byte("user:1"), data)
+	assert.Equal(t, false, ok)
+	_, ok = ns2.Get([]byte("user:1"), data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, 0, ns1.GetUsedBytes())
+}
+
+func TestCache_Namespace_Panic(t *testing.T) {
+	c := New(4, 1<<16)
+	assert.PanicsWithValue(t, "namespace quota must be > 0", func() {
+		c.Namespace("ns", 0)
+	})
+}
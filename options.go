@@ -0,0 +1,146 @@
+package bigcache
+
+import "time"
+
+// Option configures the cache
+type Option func(opts *cacheOptions)
+
+type cacheOptions struct {
+	evictionPolicy EvictionPolicy
+	tinyLFU        bool
+
+	clock                Clock
+	accessTimeResolution time.Duration
+	logicalAccessTime    bool
+
+	maxPinnedBytes int
+
+	compressor           Compressor
+	compressionThreshold int
+
+	checksum bool
+	keyring  *Keyring
+
+	allocator Allocator
+
+	// computed from the options, shared by all segments
+	getExpireNow func() uint32
+	invalidator  *invalidator
+	namespaces   *namespaceRegistry
+}
+
+func newCacheOptions(options ...Option) *cacheOptions {
+	opts := &cacheOptions{
+		evictionPolicy:       AccessTimePolicy{},
+		clock:                monoClock{},
+		accessTimeResolution: time.Second,
+		allocator:            HeapAllocator{},
+	}
+	for _, o := range options {
+		o(opts)
+	}
+	opts.getExpireNow = newMonoGetNow(opts.clock.NanoTime, time.Second)
+	opts.invalidator = newInvalidator()
+	opts.namespaces = newNamespaceRegistry()
+	return opts
+}
+
+// WithEvictionPolicy configures the policy used when segments need space, default AccessTimePolicy
+func WithEvictionPolicy(policy EvictionPolicy) Option {
+	return func(opts *cacheOptions) {
+		opts.evictionPolicy = policy
+	}
+}
+
+// WithTinyLFUAdmission enables the TinyLFU admission filter: when a segment is full, a new key is only
+// admitted if it is accessed more frequently than the entry it would evict
+func WithTinyLFUAdmission() Option {
+	return func(opts *cacheOptions) {
+		opts.tinyLFU = true
+	}
+}
+
+// WithAccessTimeResolution configures the resolution of the access times used for eviction, default 1 second.
+// A smaller resolution distinguishes entries accessed in short bursts but the ages of idle entries wrap around sooner
+func WithAccessTimeResolution(d time.Duration) Option {
+	return func(opts *cacheOptions) {
+		if d <= 0 {
+			panic("access time resolution must be > 0")
+		}
+		opts.accessTimeResolution = d
+	}
+}
+
+// WithLogicalAccessTime uses a per segment counter increased on every put and get as the access time
+// instead of the monotonic clock
+func WithLogicalAccessTime() Option {
+	return func(opts *cacheOptions) {
+		opts.logicalAccessTime = true
+	}
+}
+
+// WithClock replaces the monotonic clock of the cache, useful for testing (see package bigcachetest)
+func WithClock(clock Clock) Option {
+	return func(opts *cacheOptions) {
+		opts.clock = clock
+	}
+}
+
+// WithMaxPinnedBytes configures the maximum size of pinned entries per segment (see Cache.PutPinned),
+// default a quarter of the segment size. It is capped at half of the segment size
+func WithMaxPinnedBytes(n int) Option {
+	return func(opts *cacheOptions) {
+		if n <= 0 {
+			panic("max pinned bytes must be > 0")
+		}
+		opts.maxPinnedBytes = n
+	}
+}
+
+// WithCompression compresses values of at least threshold bytes, a value is stored raw if it is not
+// smaller after compressing. Values put by PutFrom are not compressed
+func WithCompression(compressor Compressor, threshold int) Option {
+	return func(opts *cacheOptions) {
+		opts.compressor = compressor
+		opts.compressionThreshold = threshold
+	}
+}
+
+// WithChecksum stores a CRC32C checksum of the key and the value with each entry, verified by every lookup.
+// Corrupted entries are treated as misses and removed (see Cache.GetCorruptedCount)
+func WithChecksum() Option {
+	return func(opts *cacheOptions) {
+		opts.checksum = true
+	}
+}
+
+// WithEncryption encrypts the values with the active key of the keyring using AES-GCM, after compressing
+func WithEncryption(keyring *Keyring) Option {
+	return func(opts *cacheOptions) {
+		opts.keyring = keyring
+	}
+}
+
+// WithAllocator configures the allocator of the ring buffers, default HeapAllocator.
+// Use MmapAllocator or NewFileAllocator to keep big caches outside the Go heap, Close releases the buffers
+func WithAllocator(allocator Allocator) Option {
+	return func(opts *cacheOptions) {
+		opts.allocator = allocator
+	}
+}
+
+func (opts *cacheOptions) getMaxPinnedBytes(segmentSize int) int {
+	return computeMaxPinnedBytes(opts.maxPinnedBytes, segmentSize)
+}
+
+// computeMaxPinnedBytes returns the maximum size of pinned entries of a segment for the configured value n,
+// 0 for the default
+func computeMaxPinnedBytes(n int, segmentSize int) int {
+	if n == 0 {
+		return segmentSize / 4
+	}
+	if n > segmentSize/2 {
+		return segmentSize / 2
+	}
+	return n
+}
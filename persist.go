@@ -0,0 +1,824 @@
+package bigcache
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+const (
+	persistMagic             = "BIGCACHE"
+	persistVersion           = 3
+	persistMetaFile          = "bigcache.meta"
+	persistInvalidationsFile = "bigcache.invalidations"
+	persistLockFile          = "bigcache.lock"
+
+	persistMetaHeaderSize   = 8 + 4 + 4 + 4 + 4 + 8 + 8 + 4 + 4 + 8
+	persistSegmentStateSize = 8 + 8
+	persistMaxSegments      = 1 << 20
+)
+
+const persistFlagClean = 1
+
+// the options changing how entries are stored, a persisted cache must be opened with the same options
+const (
+	persistOptionCompression uint32 = 1 << iota
+	persistOptionEncryption
+	persistOptionChecksum
+)
+
+var persistCRCTable = crc32.MakeTable(crc32.Castagnoli)
+
+var errInvalidPersistMeta = errors.New("bigcache: invalid meta file")
+
+// ErrOptionsMismatch is returned by Open when the compression, the encryption or the checksums are not
+// enabled the same as when the cache was created
+var ErrOptionsMismatch = errors.New("bigcache: options do not match the persisted cache")
+
+// ErrDirLocked is returned by Open when the directory is used by a cache not closed yet, in this process or another
+var ErrDirLocked = errors.New("bigcache: directory is locked by another cache")
+
+// persistMeta is the content of the meta file of a persistent cache:
+// magic | version | flags | options | segment count | segment size | hash seed | expire now | epoch | saved at |
+// begin and size of every segment | crc32c of the previous bytes
+type persistMeta struct {
+	clean       bool
+	options     uint32
+	segmentSize int
+	hashSeed    uint64
+	expireNow   uint32 // the value of the expire clock when saved
+	epoch       uint32 // the epoch of the invalidator when saved
+	savedAt     int64  // unix seconds
+	segments    []persistSegmentState
+
+	loaded bool // read from the meta file, not persisted
+}
+
+type persistSegmentState struct {
+	begin int
+	size  int
+}
+
+type persistState struct {
+	dir       string
+	allocator *FileAllocator
+	options   uint32
+	lock      *os.File // holds the lock of dir until Close
+}
+
+// Open opens the cache persisted in the directory dir or creates it if not existed. The ring buffers are
+// mapped to files in dir (see NewFileAllocator) and keys are hashed with a seed saved in dir, so that a
+// restarted process serves the entries put before Close immediately, the index is rebuilt by scanning
+// the ring buffers. Options are applied as for New, except WithAllocator.
+// The directory is locked until Close, Open returns ErrDirLocked if it is already locked.
+//
+// numSegments and segmentSize are only used when creating: the cache is created again if the number
+// of segments changed, the segment size is kept (including after Resize). Returns ErrOptionsMismatch if
+// WithCompression, WithEncryption or WithChecksum are not used the same as when the cache was created.
+// If the previous process did not call Close, the state saved by the last Sync is restored and the entries
+// are checked, an entry with an invalid header or that does not match its checksum (see WithChecksum) is
+// dropped with the entries after it in its segment. Without checksums only the headers are checked, the values
+// overwritten after the last Sync can not be detected.
+//
+// The tags and prefixes invalidated are appended to a file in dir, they still apply to the entries restored
+// after a crash. Not persisted: the namespaces (namespaces must be created in the same order to find their
+// entries, the used bytes of their restored entries are accounted when created) and the access times
+// used for eviction
+func Open(dir string, numSegments int, segmentSize int, options ...Option) (*Cache, error) {
+	if numSegments < 1 {
+		panic("numSegments must not be < 1")
+	}
+	numSegments = nextPowerOfTwo(numSegments)
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	lock, err := lockDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := newCacheOptions(options...)
+	allocator := NewFileAllocator(dir)
+	opts.allocator = allocator
+
+	meta, buffers, err := loadRingBufs(dir, allocator, numSegments, segmentSize, opts)
+	if err != nil {
+		_ = lock.Close()
+		return nil, err
+	}
+	if meta.loaded {
+		opts.getExpireNow = restoreGetNow(opts.getExpireNow, meta)
+	}
+
+	c := newCache(numSegments, meta.segmentSize, opts, func(index int) []byte {
+		return buffers[index]
+	})
+	c.seededHash = true
+	c.hashSeed = meta.hashSeed
+	c.persist = &persistState{
+		dir:       dir,
+		allocator: allocator,
+		options:   meta.options,
+		lock:      lock,
+	}
+
+	if err := c.openPersistent(meta); err != nil {
+		_ = c.freeRingBufs()
+		_ = lock.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// loadRingBufs maps the ring buffers saved in dir or creates them, meta.loaded is true if they are saved
+func loadRingBufs(
+	dir string, allocator *FileAllocator, numSegments int, segmentSize int, opts *cacheOptions,
+) (*persistMeta, [][]byte, error) {
+	meta, buffers, err := openRingBufs(dir, allocator, numSegments)
+	if err != nil {
+		return nil, nil, err
+	}
+	if buffers != nil {
+		if meta.options != opts.persistOptions() {
+			freeBuffers(allocator, buffers)
+			return nil, nil, ErrOptionsMismatch
+		}
+		meta.loaded = true
+		return meta, buffers, nil
+	}
+
+	meta, err = newPersistMeta(numSegments, segmentSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	meta.options = opts.persistOptions()
+	buffers, err = createRingBufs(allocator, numSegments, segmentSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	return meta, buffers, nil
+}
+
+// openPersistent restores the entries of a loaded meta, then marks the saved state as not closed until Close
+func (c *Cache) openPersistent(meta *persistMeta) error {
+	// the invalidations since the last Close apply to the entries restored after a crash
+	if meta.loaded && !meta.clean {
+		if err := c.invalidator.replayLog(c.persist.dir); err != nil {
+			return err
+		}
+	}
+	if err := c.invalidator.openLog(c.persist.dir); err != nil {
+		return err
+	}
+	if meta.loaded {
+		c.restore(meta)
+	}
+	if err := c.Sync(); err != nil {
+		_ = c.invalidator.closeLog()
+		return err
+	}
+	return nil
+}
+
+// lockDir takes the exclusive lock of dir, released by closing the returned file
+func lockDir(dir string) (*os.File, error) {
+	file, err := os.OpenFile(filepath.Join(dir, persistLockFile), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(file); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// Sync writes the ring buffers to their files and saves their state, for a cache opened with Open.
+// After a crash, the next Open restores the state saved by the last Sync. The file of invalidations is
+// rewritten with the recorded invalidations only, an error of appending to it since the previous Sync
+// is returned. Does nothing for caches created by New
+func (c *Cache) Sync() error {
+	if c.persist == nil {
+		return nil
+	}
+	meta := c.snapshotPersistMeta()
+	if err := c.persist.allocator.Sync(); err != nil {
+		return err
+	}
+	if err := writePersistMeta(c.persist.dir, meta); err != nil {
+		return err
+	}
+	return c.invalidator.rewriteLog()
+}
+
+func (c *Cache) closePersistent() error {
+	// the invalidations are not kept after Close
+	c.sweepAll()
+	meta := c.snapshotPersistMeta()
+	meta.clean = true
+
+	err := c.persist.allocator.Sync()
+	if freeErr := c.freeRingBufs(); err == nil {
+		err = freeErr
+	}
+	if err == nil {
+		err = writePersistMeta(c.persist.dir, meta)
+	}
+	// the invalidated entries are removed, the invalidations are not needed by the next Open
+	if err == nil {
+		err = c.invalidator.truncateLog()
+	}
+	if closeErr := c.invalidator.closeLog(); err == nil {
+		err = closeErr
+	}
+	if closeErr := c.persist.lock.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// sweepAll deletes the expired and invalidated entries of all segments
+func (c *Cache) sweepAll() {
+	for i := range c.segments {
+		seg := &c.segments[i]
+		seg.mu.Lock()
+		seg.sweepPos = seg.rb.getBeginPos()
+		seg.sweepExpired(math.MaxInt32)
+		seg.mu.Unlock()
+	}
+}
+
+func (c *Cache) snapshotPersistMeta() *persistMeta {
+	meta := &persistMeta{
+		options:   c.persist.options,
+		hashSeed:  c.hashSeed,
+		expireNow: c.getExpireNow(),
+		epoch:     c.invalidator.getEpoch(),
+		savedAt:   time.Now().Unix(),
+		segments:  make([]persistSegmentState, len(c.segments)),
+	}
+	for i := range c.segments {
+		seg := &c.segments[i]
+		seg.mu.Lock()
+		meta.segmentSize = len(seg.rb.data)
+		meta.segments[i] = persistSegmentState{
+			begin: seg.rb.getBegin(),
+			size:  seg.rb.size,
+		}
+		seg.mu.Unlock()
+	}
+	return meta
+}
+
+// restore rebuilds the segments from the ring buffers loaded from the files
+func (c *Cache) restore(meta *persistMeta) {
+	epoch := meta.epoch
+	if logEpoch := c.invalidator.getEpoch(); logEpoch > epoch {
+		epoch = logEpoch
+	}
+	generation := uint64(0)
+	for i := range c.segments {
+		state := meta.segments[i]
+		seg := &c.segments[i]
+		// without checksums only the headers are checked
+		verify := !meta.clean && c.persist.options&persistOptionChecksum != 0
+		// an entry must be found at its place by its key
+		isValid := func(header *entryHeader, offset int, key []byte) bool {
+			keySeg, hash := c.getSpaceSegment(header.keySpace(), key)
+			if keySeg != seg || uint32(hash) != header.hash {
+				return false
+			}
+			return !verify || header.deleted || !seg.isCorrupted(header, offset)
+		}
+		maxEpoch, maxGeneration := seg.restore(state.begin, state.size, isValid)
+		if maxEpoch > epoch {
+			epoch = maxEpoch
+		}
+		if maxGeneration > generation {
+			generation = maxGeneration
+		}
+	}
+	atomic.StoreUint32(&c.invalidator.epoch, epoch)
+	atomic.StoreUint64(&c.chunkGeneration, generation)
+}
+
+// restore rebuilds the index from the entries between begin and begin + size of a ring buffer loaded from
+// a file. Access times are reset. An entry is invalid if its header is invalid or isValid returns false,
+// the ring buffer is truncated at the first invalid entry.
+// The entries encrypted with a key not in the keyring are deleted.
+// Returns the max epoch and the max chunk generation of the entries
+func (s *segment) restore(
+	begin int, size int, isValid func(header *entryHeader, offset int, key []byte) bool,
+) (maxEpoch uint32, maxGeneration uint64) {
+	if begin < 0 || begin >= len(s.rb.data) || size < 0 || size > len(s.rb.data) {
+		return 0, 0
+	}
+	s.rb.begin = begin
+	s.rb.beginPos = uint64(begin)
+	s.rb.size = 0
+
+	var headerData [maxEntryHeaderSize]byte
+	header := (*entryHeader)(unsafe.Pointer(&headerData[0]))
+	var key []byte
+
+	for s.rb.size < size {
+		offset := s.rb.getEnd()
+		s.rb.readHeader(&headerData, offset)
+		if !s.isRestorable(&headerData, offset, size-s.rb.size, &key, isValid) {
+			break
+		}
+		s.rb.appendEmpty(header.entrySize())
+
+		if !s.restoreEntry(&headerData, offset) {
+			continue
+		}
+		if header.epoch > maxEpoch {
+			maxEpoch = header.epoch
+		}
+		if generation := s.getChunkGeneration(header, offset); generation > maxGeneration {
+			maxGeneration = generation
+		}
+	}
+	return maxEpoch, maxGeneration
+}
+
+// isRestorable checks the entry at offset of at most maxSize bytes, key is the buffer of its key
+func (s *segment) isRestorable(
+	headerData *[maxEntryHeaderSize]byte, offset int, maxSize int, key *[]byte,
+	isValid func(header *entryHeader, offset int, key []byte) bool,
+) bool {
+	header := (*entryHeader)(unsafe.Pointer(&headerData[0]))
+	if !isValidHeader(headerData) || header.entrySize() > maxSize {
+		return false
+	}
+	if cap(*key) < int(header.keyLen) {
+		*key = make([]byte, header.keyLen)
+	}
+	*key = (*key)[:header.keyLen]
+	s.rb.readAt(*key, header.keyOffset(offset))
+	return isValid(header, offset, *key)
+}
+
+// restoreEntry adds the valid entry at offset to the index, returns false if the entry is deleted
+func (s *segment) restoreEntry(headerData *[maxEntryHeaderSize]byte, offset int) bool {
+	header := (*entryHeader)(unsafe.Pointer(&headerData[0]))
+	entrySize := header.entrySize()
+
+	if !header.deleted && s.isRetired(header) {
+		header.deleted = true
+		s.rb.writeHeader(headerData, offset)
+	}
+	if header.deleted {
+		atomic.AddUint64(&s.deadBytes, uint64(entrySize))
+		return false
+	}
+
+	if oldOffset, ok := s.kv[header.hash]; ok {
+		// only after a crash, the later entry is kept
+		var oldHeaderData [maxEntryHeaderSize]byte
+		s.rb.readHeader(&oldHeaderData, oldOffset)
+		s.deleteEntry((*entryHeader)(unsafe.Pointer(&oldHeaderData[0])), &oldHeaderData, oldOffset)
+	}
+
+	header.accessTime = 0
+	s.rb.writeHeader(headerData, offset)
+
+	s.kv[header.hash] = offset
+	atomic.AddUint64(&s.total, 1)
+	s.accountEntry(header, entrySize)
+	return true
+}
+
+// isValidHeader checks the fields of a restored header that can not be checked by the size of the entry
+func isValidHeader(headerData *[maxEntryHeaderSize]byte) bool {
+	header := (*entryHeader)(unsafe.Pointer(&headerData[0]))
+	deleted := headerData[unsafe.Offsetof(header.deleted)]
+	if deleted > 1 || header.valLen > header.valCap {
+		return false
+	}
+	if header.isChunked() && header.flags&entryFlagChunk != 0 {
+		return false
+	}
+	return header.flags&entryFlagChunk == 0 || int(header.keyLen) == chunkKeySize
+}
+
+// getChunkGeneration returns the generation of a chunk or a chunk manifest, zero for other entries
+func (s *segment) getChunkGeneration(header *entryHeader, offset int) uint64 {
+	if header.flags&entryFlagChunk != 0 && int(header.keyLen) == chunkKeySize {
+		var key [chunkKeySize]byte
+		s.rb.readAt(key[:], header.keyOffset(offset))
+		return binary.BigEndian.Uint64(key[:])
+	}
+	if header.isChunked() && header.valLen == chunkManifestSize {
+		var data [chunkManifestSize]byte
+		s.rb.readAt(data[:], header.valueOffset(offset))
+		return decodeChunkManifest(data[:]).generation
+	}
+	return 0
+}
+
+func (o *cacheOptions) persistOptions() uint32 {
+	options := uint32(0)
+	if o.compressor != nil {
+		options |= persistOptionCompression
+	}
+	if o.keyring != nil {
+		options |= persistOptionEncryption
+	}
+	if o.checksum {
+		options |= persistOptionChecksum
+	}
+	return options
+}
+
+// restoreGetNow continues the expire clock from the saved value plus the wall time elapsed since saved
+func restoreGetNow(getNow func() uint32, meta *persistMeta) func() uint32 {
+	elapsed := time.Now().Unix() - meta.savedAt
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	base := meta.expireNow + uint32(elapsed)
+	return func() uint32 {
+		return base + getNow()
+	}
+}
+
+// openRingBufs maps the files of the segments saved in dir, returns nil buffers if the cache must be created
+func openRingBufs(dir string, allocator *FileAllocator, numSegments int) (*persistMeta, [][]byte, error) {
+	meta, err := readPersistMeta(dir)
+	if errors.Is(err, os.ErrNotExist) || errors.Is(err, errInvalidPersistMeta) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(meta.segments) != numSegments {
+		return nil, nil, nil
+	}
+
+	buffers := make([][]byte, 0, numSegments)
+	for i := 0; i < numSegments; i++ {
+		data, err := allocator.mapExisting(i, meta.segmentSize)
+		if err != nil {
+			// missing or truncated files, the cache is created again
+			freeBuffers(allocator, buffers)
+			return nil, nil, nil
+		}
+		buffers = append(buffers, data)
+	}
+	return meta, buffers, nil
+}
+
+func createRingBufs(allocator *FileAllocator, numSegments int, segmentSize int) ([][]byte, error) {
+	buffers := make([][]byte, 0, numSegments)
+	for i := 0; i < numSegments; i++ {
+		data, err := allocator.Alloc(i, segmentSize)
+		if err != nil {
+			freeBuffers(allocator, buffers)
+			return nil, err
+		}
+		buffers = append(buffers, data)
+	}
+	return buffers, nil
+}
+
+func freeBuffers(allocator *FileAllocator, buffers [][]byte) {
+	for index, data := range buffers {
+		_ = allocator.Free(index, data)
+	}
+}
+
+func newPersistMeta(numSegments int, segmentSize int) (*persistMeta, error) {
+	var seed [8]byte
+	if _, err := rand.Read(seed[:]); err != nil {
+		return nil, err
+	}
+	return &persistMeta{
+		segmentSize: segmentSize,
+		hashSeed:    binary.LittleEndian.Uint64(seed[:]),
+		savedAt:     time.Now().Unix(),
+		segments:    make([]persistSegmentState, numSegments),
+	}, nil
+}
+
+func (m *persistMeta) encode() []byte {
+	data := make([]byte, persistMetaHeaderSize+len(m.segments)*persistSegmentStateSize+4)
+
+	flags := uint32(0)
+	if m.clean {
+		flags |= persistFlagClean
+	}
+
+	copy(data, persistMagic)
+	binary.LittleEndian.PutUint32(data[8:], persistVersion)
+	binary.LittleEndian.PutUint32(data[12:], flags)
+	binary.LittleEndian.PutUint32(data[16:], m.options)
+	binary.LittleEndian.PutUint32(data[20:], uint32(len(m.segments)))
+	binary.LittleEndian.PutUint64(data[24:], uint64(m.segmentSize))
+	binary.LittleEndian.PutUint64(data[32:], m.hashSeed)
+	binary.LittleEndian.PutUint32(data[40:], m.expireNow)
+	binary.LittleEndian.PutUint32(data[44:], m.epoch)
+	binary.LittleEndian.PutUint64(data[48:], uint64(m.savedAt))
+
+	for i, state := range m.segments {
+		offset := persistMetaHeaderSize + i*persistSegmentStateSize
+		binary.LittleEndian.PutUint64(data[offset:], uint64(state.begin))
+		binary.LittleEndian.PutUint64(data[offset+8:], uint64(state.size))
+	}
+
+	n := len(data) - 4
+	binary.LittleEndian.PutUint32(data[n:], crc32.Checksum(data[:n], persistCRCTable))
+	return data
+}
+
+func decodePersistMeta(data []byte) (*persistMeta, error) {
+	if len(data) < persistMetaHeaderSize+4 || string(data[:8]) != persistMagic {
+		return nil, errInvalidPersistMeta
+	}
+	n := len(data) - 4
+	if crc32.Checksum(data[:n], persistCRCTable) != binary.LittleEndian.Uint32(data[n:]) {
+		return nil, fmt.Errorf("%w: checksum mismatch", errInvalidPersistMeta)
+	}
+	if version := binary.LittleEndian.Uint32(data[8:]); version != persistVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", errInvalidPersistMeta, version)
+	}
+
+	count := int(binary.LittleEndian.Uint32(data[20:]))
+	if count > persistMaxSegments || n != persistMetaHeaderSize+count*persistSegmentStateSize {
+		return nil, fmt.Errorf("%w: invalid segment count %d", errInvalidPersistMeta, count)
+	}
+
+	m := &persistMeta{
+		clean:       binary.LittleEndian.Uint32(data[12:])&persistFlagClean != 0,
+		options:     binary.LittleEndian.Uint32(data[16:]),
+		segmentSize: int(binary.LittleEndian.Uint64(data[24:])),
+		hashSeed:    binary.LittleEndian.Uint64(data[32:]),
+		expireNow:   binary.LittleEndian.Uint32(data[40:]),
+		epoch:       binary.LittleEndian.Uint32(data[44:]),
+		savedAt:     int64(binary.LittleEndian.Uint64(data[48:])),
+		segments:    make([]persistSegmentState, count),
+	}
+	for i := range m.segments {
+		offset := persistMetaHeaderSize + i*persistSegmentStateSize
+		m.segments[i] = persistSegmentState{
+			begin: int(binary.LittleEndian.Uint64(data[offset:])),
+			size:  int(binary.LittleEndian.Uint64(data[offset+8:])),
+		}
+	}
+	return m, nil
+}
+
+func readPersistMeta(dir string) (*persistMeta, error) {
+	data, err := os.ReadFile(filepath.Join(dir, persistMetaFile))
+	if err != nil {
+		return nil, err
+	}
+	return decodePersistMeta(data)
+}
+
+// writePersistMeta replaces the meta file atomically
+func writePersistMeta(dir string, meta *persistMeta) error {
+	return replaceFile(filepath.Join(dir, persistMetaFile), meta.encode())
+}
+
+// replaceFile replaces the file of path with data atomically
+func replaceFile(path string, data []byte) error {
+	tmpPath := path + ".tmp"
+
+	file, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+const (
+	invalidationRecordTag uint8 = iota + 1
+	invalidationRecordPrefix
+	invalidationRecordTagFloor
+	invalidationRecordPrefixFloor
+)
+
+// crc32c of the next bytes | epoch | kind | namespace | data length, followed by the data
+const invalidationRecordHeaderSize = 4 + 4 + 1 + 2 + 2
+
+// invalidationLog is the file of the invalidations of a persistent cache: the invalidated tags and prefixes
+// are appended with their epochs, without syncing the file. The entries put before Close are not checked
+// against it, Close removes the invalidated entries and truncates it
+type invalidationLog struct {
+	path string
+	file *os.File
+	buf  []byte
+	err  error // the first error of appending since the file was rewritten
+}
+
+// replayLog records the invalidations of the file of invalidations in dir, before openLog
+func (inv *invalidator) replayLog(dir string) error {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+
+	data, err := os.ReadFile(filepath.Join(dir, persistInvalidationsFile))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	// the entries are not restored yet, the invalidations can only be pruned with the floors
+	liveEpochs := inv.liveEpochs
+	inv.liveEpochs = nil
+	inv.replay(data)
+	inv.liveEpochs = liveEpochs
+	return nil
+}
+
+// openLog opens the file of invalidations in dir, the file is rewritten with the recorded invalidations only
+func (inv *invalidator) openLog(dir string) error {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+
+	inv.log = &invalidationLog{path: filepath.Join(dir, persistInvalidationsFile)}
+	return inv.rewriteLogLocked()
+}
+
+// replay records the invalidations of data, stops at the first incomplete or corrupted record
+func (inv *invalidator) replay(data []byte) {
+	for len(data) >= invalidationRecordHeaderSize {
+		size := invalidationRecordHeaderSize + int(binary.LittleEndian.Uint16(data[11:]))
+		if len(data) < size || crc32.Checksum(data[4:size], persistCRCTable) != binary.LittleEndian.Uint32(data) {
+			return
+		}
+		epoch := binary.LittleEndian.Uint32(data[4:])
+		space := keySpace{namespace: binary.LittleEndian.Uint16(data[9:])}
+		value := data[invalidationRecordHeaderSize:size]
+
+		switch data[8] {
+		case invalidationRecordTag:
+			if len(value) == tagSize {
+				inv.setTag(binary.LittleEndian.Uint64(value), epoch)
+			}
+		case invalidationRecordPrefix:
+			inv.setPrefix(space, value, epoch)
+		case invalidationRecordTagFloor, invalidationRecordPrefixFloor:
+			inv.setFloor(data[8], space, epoch)
+		}
+		if epoch > inv.epoch {
+			atomic.StoreUint32(&inv.epoch, epoch)
+		}
+		data = data[size:]
+	}
+}
+
+// rewriteLog replaces the file of invalidations with the recorded invalidations, returns the first error
+// of appending since the previous rewrite if any
+func (inv *invalidator) rewriteLog() error {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+
+	appendErr := inv.log.err
+	if err := inv.rewriteLogLocked(); err != nil {
+		return err
+	}
+	return appendErr
+}
+
+// setFloor raises the tag floor or the prefix floor of space to epoch
+func (inv *invalidator) setFloor(kind uint8, space keySpace, epoch uint32) {
+	table := *inv.getTable()
+	if kind == invalidationRecordTagFloor {
+		if epoch > table.tagFloor {
+			table.tagFloor = epoch
+		}
+	} else if epoch > table.prefixFloors[space] {
+		floors := make(map[keySpace]uint32, len(table.prefixFloors)+1)
+		for s, e := range table.prefixFloors {
+			floors[s] = e
+		}
+		floors[space] = epoch
+		table.prefixFloors = floors
+	}
+	inv.table.Store(&table)
+}
+
+func (inv *invalidator) rewriteLogLocked() error {
+	table := inv.getTable()
+
+	var data []byte
+	if table.tagFloor != 0 {
+		data = appendInvalidationRecord(data, invalidationRecordTagFloor, keySpace{}, nil, table.tagFloor)
+	}
+	for space, epoch := range table.prefixFloors {
+		data = appendInvalidationRecord(data, invalidationRecordPrefixFloor, space, nil, epoch)
+	}
+	var tagData [tagSize]byte
+	for tag, epoch := range mergeTags(table.tags, table.recentTags) {
+		binary.LittleEndian.PutUint64(tagData[:], tag)
+		data = appendInvalidationRecord(data, invalidationRecordTag, keySpace{}, tagData[:], epoch)
+	}
+	for group, prefixes := range table.prefixes {
+		for prefix, epoch := range prefixes {
+			data = appendInvalidationRecord(data, invalidationRecordPrefix, group.space, []byte(prefix), epoch)
+		}
+	}
+
+	l := inv.log
+	if err := replaceFile(l.path, data); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if l.file != nil {
+		_ = l.file.Close()
+	}
+	l.file = file
+	l.err = nil
+	return nil
+}
+
+// truncateLog removes the invalidations from the file of invalidations, before closeLog
+func (inv *invalidator) truncateLog() error {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	return inv.log.file.Truncate(0)
+}
+
+// closeLog closes the file of invalidations
+func (inv *invalidator) closeLog() error {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+
+	l := inv.log
+	inv.log = nil
+	return l.file.Close()
+}
+
+func (l *invalidationLog) appendTag(tag uint64, epoch uint32) {
+	if l == nil {
+		return
+	}
+	var data [tagSize]byte
+	binary.LittleEndian.PutUint64(data[:], tag)
+	l.append(invalidationRecordTag, keySpace{}, data[:], epoch)
+}
+
+func (l *invalidationLog) appendTagFloor(epoch uint32) {
+	if l == nil {
+		return
+	}
+	l.append(invalidationRecordTagFloor, keySpace{}, nil, epoch)
+}
+
+func (l *invalidationLog) appendPrefixFloor(space keySpace, epoch uint32) {
+	if l == nil {
+		return
+	}
+	l.append(invalidationRecordPrefixFloor, space, nil, epoch)
+}
+
+func (l *invalidationLog) appendPrefix(space keySpace, prefix []byte, epoch uint32) {
+	// a longer prefix does not match any key
+	if l == nil || len(prefix) > math.MaxUint16 {
+		return
+	}
+	l.append(invalidationRecordPrefix, space, prefix, epoch)
+}
+
+func (l *invalidationLog) append(kind uint8, space keySpace, data []byte, epoch uint32) {
+	l.buf = appendInvalidationRecord(l.buf[:0], kind, space, data, epoch)
+	if _, err := l.file.Write(l.buf); err != nil && l.err == nil {
+		l.err = err
+	}
+}
+
+func appendInvalidationRecord(dst []byte, kind uint8, space keySpace, data []byte, epoch uint32) []byte {
+	begin := len(dst)
+	var header [invalidationRecordHeaderSize]byte
+	binary.LittleEndian.PutUint32(header[4:], epoch)
+	header[8] = kind
+	binary.LittleEndian.PutUint16(header[9:], space.namespace)
+	binary.LittleEndian.PutUint16(header[11:], uint16(len(data)))
+
+	dst = append(dst, header[:]...)
+	dst = append(dst, data...)
+	binary.LittleEndian.PutUint32(dst[begin:], crc32.Checksum(dst[begin+4:], persistCRCTable))
+	return dst
+}
@@ -0,0 +1,459 @@
+package bigcache
+
+import (
+	"compress/flate"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+func openTestCache(t *testing.T, dir string, options ...Option) *Cache {
+	if _, err := (MmapAllocator{}).Alloc(0, 4096); err == ErrMmapNotSupported {
+		t.Skip(err)
+	}
+	c, err := Open(dir, 4, 1<<14, options...)
+	assert.Equal(t, nil, err)
+	return c
+}
+
+func updateTestMeta(t *testing.T, dir string, fn func(meta *persistMeta)) {
+	meta, err := readPersistMeta(dir)
+	assert.Equal(t, nil, err)
+	fn(meta)
+	assert.Equal(t, nil, writePersistMeta(dir, meta))
+}
+
+// crashTestCache stops using the cache without saving its state, as if the process crashed
+func crashTestCache(t *testing.T, c *Cache) {
+	assert.Equal(t, nil, c.freeRingBufs())
+	assert.Equal(t, nil, c.persist.lock.Close())
+}
+
+func putTestEntries(c *Cache, from int, to int) {
+	for i := from; i < to; i++ {
+		c.Put([]byte(fmt.Sprintf("key:%d", i)), []byte(fmt.Sprintf("value:%d", i)))
+	}
+}
+
+func assertTestEntries(t *testing.T, c *Cache, from int, to int) {
+	t.Helper()
+	data := make([]byte, 20)
+	for i := from; i < to; i++ {
+		n, ok := c.Get([]byte(fmt.Sprintf("key:%d", i)), data)
+		assert.Equal(t, true, ok)
+		assert.Equal(t, fmt.Sprintf("value:%d", i), string(data[:n]))
+	}
+}
+
+func TestCache_Open_Reopen(t *testing.T) {
+	dir := t.TempDir()
+
+	c := openTestCache(t, dir, WithChecksum())
+	putTestEntries(c, 0, 100)
+	c.Delete([]byte("key:10"))
+	assert.Equal(t, nil, c.PutPinned([]byte("pinned"), []byte("value")))
+	hashSeed := c.hashSeed
+	pinnedBytes := c.GetPinnedBytes()
+	assert.Equal(t, nil, c.Close())
+
+	meta, err := readPersistMeta(dir)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, meta.clean)
+	assert.Equal(t, hashSeed, meta.hashSeed)
+	assert.Equal(t, 4, len(meta.segments))
+
+	c = openTestCache(t, dir, WithChecksum())
+	assert.Equal(t, hashSeed, c.hashSeed)
+	assert.Equal(t, uint64(100), c.GetTotal())
+	assert.Equal(t, nil, c.Verify())
+
+	assertTestEntries(t, c, 0, 10)
+	assertTestEntries(t, c, 11, 100)
+	_, ok := c.Get([]byte("key:10"), nil)
+	assert.Equal(t, false, ok)
+	assert.Equal(t, pinnedBytes, c.GetPinnedBytes())
+
+	// the saved state is marked as not closed while opened
+	meta, err = readPersistMeta(dir)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, meta.clean)
+
+	putTestEntries(c, 100, 200)
+	assert.Equal(t, nil, c.Close())
+
+	// the options must not change
+	_, err = Open(dir, 4, 1<<14)
+	assert.Equal(t, ErrOptionsMismatch, err)
+
+	c = openTestCache(t, dir, WithChecksum())
+	assertTestEntries(t, c, 100, 200)
+	assert.Equal(t, nil, c.Close())
+}
+
+func TestCache_Open_Create_Again(t *testing.T) {
+	dir := t.TempDir()
+
+	c := openTestCache(t, dir)
+	putTestEntries(c, 0, 100)
+	assert.Equal(t, nil, c.Close())
+
+	// the number of segments changed
+	c, err := Open(dir, 8, 1<<14)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint64(0), c.GetTotal())
+	assert.Equal(t, 8, len(c.segments))
+	putTestEntries(c, 0, 100)
+	assert.Equal(t, nil, c.Close())
+
+	// invalid meta file
+	assert.Equal(t, nil, os.WriteFile(filepath.Join(dir, persistMetaFile), []byte("invalid"), 0o644))
+	c, err = Open(dir, 8, 1<<14)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint64(0), c.GetTotal())
+	assert.Equal(t, nil, c.Close())
+}
+
+func TestCache_Open_Keep_Resized(t *testing.T) {
+	dir := t.TempDir()
+
+	c := openTestCache(t, dir)
+	putTestEntries(c, 0, 100)
+	assert.Equal(t, nil, c.Resize(1<<17))
+	assert.Equal(t, nil, c.Close())
+
+	c = openTestCache(t, dir)
+	assert.Equal(t, 1<<17, c.GetCapacity())
+	assertTestEntries(t, c, 0, 100)
+	assert.Equal(t, nil, c.Close())
+}
+
+func TestCache_Open_Expiration(t *testing.T) {
+	dir := t.TempDir()
+
+	c := openTestCache(t, dir)
+	c.PutWithTTL([]byte("key01"), []byte("value01"), 10*time.Second)
+	c.PutWithTTL([]byte("key02"), []byte("value02"), 30*time.Second)
+	assert.Equal(t, nil, c.Close())
+
+	updateTestMeta(t, dir, func(meta *persistMeta) {
+		meta.savedAt -= 20
+	})
+
+	c = openTestCache(t, dir)
+	_, ok := c.Get([]byte("key01"), nil)
+	assert.Equal(t, false, ok)
+	_, ok = c.Get([]byte("key02"), nil)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, nil, c.Close())
+}
+
+func TestCache_Open_Invalidation(t *testing.T) {
+	dir := t.TempDir()
+
+	c := openTestCache(t, dir)
+	c.PutWithTags([]byte("key01"), []byte("value01"), "tag")
+	c.PutWithTags([]byte("key02"), []byte("value02"), "tag")
+	c.InvalidateTag("tag")
+	c.PutWithTags([]byte("key03"), []byte("value03"), "tag")
+	assert.Equal(t, nil, c.Close())
+
+	c = openTestCache(t, dir)
+	assert.Equal(t, uint64(1), c.GetTotal())
+	_, ok := c.Get([]byte("key03"), nil)
+	assert.Equal(t, true, ok)
+
+	// invalidations after reopening apply to the restored entries
+	c.InvalidateTag("tag")
+	_, ok = c.Get([]byte("key03"), nil)
+	assert.Equal(t, false, ok)
+	assert.Equal(t, nil, c.Close())
+}
+
+func TestCache_Open_Chunked(t *testing.T) {
+	dir := t.TempDir()
+
+	c := openTestCache(t, dir)
+	// 2 chunks of each value, the chunks do not evict each other
+	value1 := randomBytes(5000)
+	c.Put([]byte("value1"), value1)
+	assert.Equal(t, nil, c.Close())
+
+	c = openTestCache(t, dir)
+	assert.Equal(t, uint64(1), c.chunkGeneration)
+
+	// new chunks do not replace the chunks of restored values
+	value2 := randomBytes(5000)
+	c.Put([]byte("value2"), value2)
+
+	data := make([]byte, 5000)
+	n, ok := c.Get([]byte("value1"), data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, value1, data[:n])
+
+	n, ok = c.Get([]byte("value2"), data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, value2, data[:n])
+	assert.Equal(t, nil, c.Close())
+}
+
+func TestCache_Open_After_Crash(t *testing.T) {
+	dir := t.TempDir()
+
+	c := openTestCache(t, dir, WithChecksum())
+	putTestEntries(c, 0, 100)
+	assert.Equal(t, nil, c.Sync())
+	putTestEntries(c, 100, 200)
+	crashTestCache(t, c) // without saving the state
+
+	c = openTestCache(t, dir, WithChecksum())
+	assert.Equal(t, uint64(100), c.GetTotal())
+	assertTestEntries(t, c, 0, 100)
+	assert.Equal(t, nil, c.Verify())
+
+	// the value of an entry is overwritten
+	seg, hash := c.getSegment([]byte("key:20"))
+	index := 0
+	for i := range c.segments {
+		if &c.segments[i] == seg {
+			index = i
+		}
+	}
+	header := seg.getHeader(uint32(hash))
+	valueOffset := header.valueOffset(seg.kv[uint32(hash)]) % len(seg.rb.data)
+	crashTestCache(t, c)
+
+	file, err := os.OpenFile(c.persist.allocator.segmentPath(index), os.O_RDWR, 0)
+	assert.Equal(t, nil, err)
+	_, err = file.WriteAt([]byte("overwritten"), int64(valueOffset))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, file.Close())
+
+	c = openTestCache(t, dir, WithChecksum())
+	_, ok := c.Get([]byte("key:20"), nil)
+	assert.Equal(t, false, ok)
+	assert.Equal(t, nil, c.Verify())
+	assert.Equal(t, uint64(0), c.GetCorruptedCount())
+	assert.Equal(t, nil, c.Close())
+}
+
+func TestCache_Open_After_Crash_Without_Checksum(t *testing.T) {
+	dir := t.TempDir()
+
+	c := openTestCache(t, dir)
+	putTestEntries(c, 0, 100)
+	assert.Equal(t, nil, c.Sync())
+	crashTestCache(t, c)
+
+	// the entries are rebuilt with their headers checked
+	c = openTestCache(t, dir)
+	assert.Equal(t, uint64(100), c.GetTotal())
+	assertTestEntries(t, c, 0, 100)
+	putTestEntries(c, 100, 110)
+	assert.Equal(t, nil, c.Sync())
+
+	// an invalid header is detected, the entries after it are dropped
+	seg, hash := c.getSegment([]byte("key:20"))
+	index := 0
+	for i := range c.segments {
+		if &c.segments[i] == seg {
+			index = i
+		}
+	}
+	offset := seg.kv[uint32(hash)]
+	crashTestCache(t, c)
+
+	file, err := os.OpenFile(c.persist.allocator.segmentPath(index), os.O_RDWR, 0)
+	assert.Equal(t, nil, err)
+	_, err = file.WriteAt([]byte{0xff, 0xff, 0xff, 0xff}, int64(offset+int(unsafe.Offsetof(entryHeader{}.valLen))))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, file.Close())
+
+	c = openTestCache(t, dir)
+	_, ok := c.Get([]byte("key:20"), nil)
+	assert.Equal(t, false, ok)
+	assert.Greater(t, c.GetTotal(), uint64(0))
+	assert.Less(t, c.GetTotal(), uint64(110))
+	assert.Equal(t, nil, c.Close())
+}
+
+func TestCache_Open_Locked(t *testing.T) {
+	dir := t.TempDir()
+
+	c := openTestCache(t, dir)
+	_, err := Open(dir, 4, 1<<14)
+	assert.Equal(t, ErrDirLocked, err)
+	assert.Equal(t, nil, c.Close())
+
+	c = openTestCache(t, dir)
+	assert.Equal(t, nil, c.Close())
+}
+
+func TestCache_Open_After_Crash_Invalidation(t *testing.T) {
+	dir := t.TempDir()
+
+	c := openTestCache(t, dir, WithChecksum())
+	c.PutWithTags([]byte("key01"), []byte("value01"), "tag")
+	c.Put([]byte("user:1"), []byte("value02"))
+	c.Put([]byte("key03"), []byte("value03"))
+	c.InvalidateTag("other")
+	assert.Equal(t, nil, c.Sync())
+	c.InvalidateTag("tag")
+	c.DeletePrefix([]byte("user:"))
+	crashTestCache(t, c)
+
+	assertInvalidated := func(c *Cache) {
+		t.Helper()
+		_, ok := c.Get([]byte("key01"), nil)
+		assert.Equal(t, false, ok)
+		_, ok = c.Get([]byte("user:1"), nil)
+		assert.Equal(t, false, ok)
+		_, ok = c.Get([]byte("key03"), nil)
+		assert.Equal(t, true, ok)
+	}
+
+	c = openTestCache(t, dir, WithChecksum())
+	assert.Equal(t, uint32(3), c.invalidator.getEpoch())
+	assertInvalidated(c)
+
+	// the invalidations are kept until Close
+	crashTestCache(t, c)
+	c = openTestCache(t, dir, WithChecksum())
+	assertInvalidated(c)
+	assert.Equal(t, nil, c.Close())
+
+	info, err := os.Stat(filepath.Join(dir, persistInvalidationsFile))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(0), info.Size())
+
+	c = openTestCache(t, dir, WithChecksum())
+	assert.Equal(t, uint64(1), c.GetTotal())
+	assertInvalidated(c)
+	assert.Equal(t, nil, c.Close())
+}
+
+func TestCache_Open_Invalid_Header(t *testing.T) {
+	dir := t.TempDir()
+
+	c := openTestCache(t, dir)
+	putTestEntries(c, 0, 100)
+	seg, hash := c.getSegment([]byte("key:20"))
+	index := 0
+	for i := range c.segments {
+		if &c.segments[i] == seg {
+			index = i
+		}
+	}
+	offset := seg.kv[uint32(hash)] % len(seg.rb.data)
+	segTotal := seg.getTotal()
+	assert.Equal(t, nil, c.Close())
+
+	// the hash of the entry does not match its key
+	file, err := os.OpenFile(c.persist.allocator.segmentPath(index), os.O_RDWR, 0)
+	assert.Equal(t, nil, err)
+	_, err = file.WriteAt([]byte{0xff, 0xff}, int64(offset))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, file.Close())
+
+	c = openTestCache(t, dir)
+	_, ok := c.Get([]byte("key:20"), nil)
+	assert.Equal(t, false, ok)
+	assert.Less(t, c.segments[index].getTotal(), segTotal)
+	assert.Equal(t, nil, c.Verify())
+	assert.Equal(t, nil, c.Close())
+}
+
+func TestCache_Open_Options_Mismatch(t *testing.T) {
+	dir := t.TempDir()
+
+	compression := WithCompression(NewFlateCompressor(flate.DefaultCompression), 128)
+	c := openTestCache(t, dir, compression)
+	value := compressibleValue(1000)
+	c.Put([]byte("key01"), value)
+	assert.Equal(t, nil, c.Close())
+
+	_, err := Open(dir, 4, 1<<14)
+	assert.Equal(t, ErrOptionsMismatch, err)
+
+	keyring, err := NewKeyring(1, make([]byte, 32))
+	assert.Equal(t, nil, err)
+	_, err = Open(dir, 4, 1<<14, compression, WithEncryption(keyring))
+	assert.Equal(t, ErrOptionsMismatch, err)
+
+	c = openTestCache(t, dir, compression)
+	data := make([]byte, 2000)
+	n, ok := c.Get([]byte("key01"), data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, value, data[:n])
+	assert.Equal(t, nil, c.Close())
+}
+
+func TestPersistMeta_Encode_Decode(t *testing.T) {
+	meta := &persistMeta{
+		clean:       true,
+		options:     persistOptionChecksum,
+		segmentSize: 1 << 20,
+		hashSeed:    0x1234,
+		expireNow:   100,
+		epoch:       5,
+		savedAt:     1700000000,
+		segments: []persistSegmentState{
+			{begin: 10, size: 20},
+			{begin: 30, size: 40},
+		},
+	}
+	data := meta.encode()
+
+	decoded, err := decodePersistMeta(data)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, meta, decoded)
+
+	data[20]++
+	_, err = decodePersistMeta(data)
+	assert.EqualError(t, err, "bigcache: invalid meta file: checksum mismatch")
+}
+
+func TestCache_Open_Namespace(t *testing.T) {
+	dir := t.TempDir()
+
+	c := openTestCache(t, dir)
+	ns := c.Namespace("ns", 1<<10)
+	ns.Put([]byte("key01"), []byte("value01"))
+	c.Put([]byte("key01"), []byte("value00"))
+	usedBytes := ns.GetUsedBytes()
+	assert.Equal(t, nil, c.Close())
+
+	c = openTestCache(t, dir)
+	ns = c.Namespace("ns", 1<<10)
+	assert.Equal(t, usedBytes, ns.GetUsedBytes())
+
+	data := make([]byte, 20)
+	n, ok := ns.Get([]byte("key01"), data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "value01", string(data[:n]))
+
+	n, ok = c.Get([]byte("key01"), data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "value00", string(data[:n]))
+
+	assert.Equal(t, true, ns.Delete([]byte("key01")))
+	assert.Equal(t, 0, ns.GetUsedBytes())
+}
+
+func TestInvalidator_Replay_Floors(t *testing.T) {
+	var data []byte
+	data = appendInvalidationRecord(data, invalidationRecordTagFloor, keySpace{}, nil, 3)
+	data = appendInvalidationRecord(data, invalidationRecordPrefixFloor, keySpace{namespace: 2}, nil, 5)
+	data = appendInvalidationRecord(data, invalidationRecordPrefixFloor, keySpace{namespace: 2}, nil, 4)
+
+	inv := newInvalidator()
+	inv.replay(data)
+
+	table := inv.getTable()
+	assert.Equal(t, uint32(3), table.tagFloor)
+	assert.Equal(t, map[keySpace]uint32{{namespace: 2}: 5}, table.prefixFloors)
+	assert.Equal(t, uint32(5), inv.getEpoch())
+}
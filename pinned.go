@@ -0,0 +1,65 @@
+package bigcache
+
+import (
+	"errors"
+	"unsafe"
+)
+
+// ErrPinnedBytesExceeded is returned when putting a pinned entry would exceed the maximum size of
+// pinned entries of the segment (see WithMaxPinnedBytes)
+var ErrPinnedBytesExceeded = errors.New("bigcache: pinned bytes limit exceeded")
+
+// PutPinned is the same as Put but the entry is never evicted, it is only removed by Delete, a later Put of
+// the same key (that is not pinned) or an invalidation. Returns ErrPinnedBytesExceeded without putting
+// if the pinned entries of the segment would exceed the limit
+func (c *Cache) PutPinned(key []byte, value []byte) error {
+	seg, hash := c.getSegment(key)
+
+	params := putParams{}
+	value, bufs := c.encodeValue(key, value, &params)
+	defer c.releaseEncodeBuffers(bufs)
+
+	seg.mu.Lock()
+	replaced, err := seg.putPinned(uint32(hash), key, value, params)
+	seg.mu.Unlock()
+
+	c.deleteChunks(keySpace{}, replaced, replaced.chunkCount())
+	return err
+}
+
+// GetPinnedBytes returns the total size of pinned entries
+func (c *Cache) GetPinnedBytes() uint64 {
+	total := uint64(0)
+	for i := range c.segments {
+		total += c.segments[i].getPinnedBytes()
+	}
+	return total
+}
+
+// putPinned returns the manifest of the replaced chunked value the same as putWithParams
+func (s *segment) putPinned(hash uint32, key []byte, value []byte, params putParams) (chunkManifest, error) {
+	params.epoch = s.invalidator.getEpoch()
+	size := headerSizeOf(s.trailerFlags(params)) + int(nextNumberAlignToHeader(uint32(len(key)+len(value))))
+	pinnedBytes := int(s.getPinnedBytes())
+
+	// the replaced entry is no longer pinned
+	if offset, ok := s.kv[hash]; ok {
+		var headerData [maxEntryHeaderSize]byte
+		s.rb.readHeader(&headerData, offset)
+		header := (*entryHeader)(unsafe.Pointer(&headerData[0]))
+		if header.isPinned() {
+			pinnedBytes -= header.entrySize()
+		}
+	}
+
+	if pinnedBytes+size > s.maxPinnedBytes {
+		return chunkManifest{}, ErrPinnedBytesExceeded
+	}
+	params.flags |= entryFlagPinned
+	replaced, _ := s.putWithParams(hash, key, value, params)
+	return replaced, nil
+}
+
+func (p putParams) isPinned() bool {
+	return p.flags&entryFlagPinned != 0
+}
@@ -0,0 +1,132 @@
+package bigcache
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSegment_Put_Pinned_Never_Evicted(t *testing.T) {
+	s := newSegmentPolicy(4096, FIFOPolicy{})
+	s.maxPinnedBytes = 2048
+
+	// more pinned entries in a row than maxConsecutiveEvacuation
+	for i := 0; i < 10; i++ {
+		err := putTestPinned(s, uint32(i), []byte{byte(i), 1, 2, 3}, []byte{10, 11, 12, 13})
+		assert.Equal(t, nil, err)
+	}
+	assert.Equal(t, uint64(10*(entryHeaderSize+8)), s.getPinnedBytes())
+
+	for i := 100; i < 1000; i++ {
+		s.put(uint32(i), []byte{byte(i), byte(i >> 8), 2, 3}, []byte{10, 11, 12, 13})
+	}
+
+	data := make([]byte, 10)
+	for i := 0; i < 10; i++ {
+		n, ok := s.getAndApply(uint32(i), []byte{byte(i), 1, 2, 3}, data)
+		assert.Equal(t, true, ok)
+		assert.Equal(t, []byte{10, 11, 12, 13}, data[:n])
+	}
+	assert.Equal(t, uint64(10*(entryHeaderSize+8)), s.getPinnedBytes())
+}
+
+func TestSegment_Put_Pinned_Exceeded(t *testing.T) {
+	s := newSegment()
+	s.maxPinnedBytes = 2 * (entryHeaderSize + 8)
+
+	assert.Equal(t, nil, putTestPinned(s, 40, []byte{1, 2, 3, 4}, []byte{10, 11, 12, 13}))
+	assert.Equal(t, nil, putTestPinned(s, 41, []byte{2, 2, 3, 4}, []byte{10, 11, 12, 13}))
+	assert.Equal(t, ErrPinnedBytesExceeded, putTestPinned(s, 42, []byte{3, 2, 3, 4}, []byte{10, 11, 12, 13}))
+	_, ok := s.getAndApply(42, []byte{3, 2, 3, 4}, nil)
+	assert.Equal(t, false, ok)
+
+	// replace an existing pinned entry
+	assert.Equal(t, nil, putTestPinned(s, 41, []byte{2, 2, 3, 4}, []byte{20, 21, 22, 23}))
+	assert.Equal(t, ErrPinnedBytesExceeded, putTestPinned(s, 41, []byte{2, 2, 3, 4}, []byte{20, 21, 22, 23, 24}))
+	assert.Equal(t, uint64(2*(entryHeaderSize+8)), s.getPinnedBytes())
+
+	data := make([]byte, 10)
+	n, ok := s.getAndApply(41, []byte{2, 2, 3, 4}, data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, []byte{20, 21, 22, 23}, data[:n])
+}
+
+func TestSegment_Put_Pinned_Then_Unpinned(t *testing.T) {
+	s := newSegment()
+
+	assert.Equal(t, nil, putTestPinned(s, 40, []byte{1, 2, 3, 4}, []byte{10, 11, 12, 13}))
+	s.put(40, []byte{1, 2, 3, 4}, []byte{20, 21})
+	assert.Equal(t, uint64(0), s.getPinnedBytes())
+	assert.Equal(t, false, s.getHeader(40).isPinned())
+
+	assert.Equal(t, nil, putTestPinned(s, 40, []byte{1, 2, 3, 4}, []byte{20, 21, 22, 23, 24}))
+	assert.Equal(t, uint64(entryHeaderSize+12), s.getPinnedBytes())
+
+	assert.Equal(t, true, s.delete(40, keySpace{}, []byte{1, 2, 3, 4}))
+	assert.Equal(t, uint64(0), s.getPinnedBytes())
+}
+
+func TestCache_Put_Pinned(t *testing.T) {
+	c := New(4, 1<<12, WithMaxPinnedBytes(1<<10))
+
+	for i := 0; i < 8; i++ {
+		err := c.PutPinned([]byte(fmt.Sprintf("config:%d", i)), []byte("value"))
+		assert.Equal(t, nil, err)
+	}
+	for i := 0; i < 2000; i++ {
+		c.Put([]byte(fmt.Sprintf("key:%d", i)), make([]byte, 50))
+	}
+
+	data := make([]byte, 10)
+	for i := 0; i < 8; i++ {
+		n, ok := c.Get([]byte(fmt.Sprintf("config:%d", i)), data)
+		assert.Equal(t, true, ok)
+		assert.Equal(t, "value", string(data[:n]))
+	}
+	assert.Equal(t, uint64(8*(entryHeaderSize+16)), c.GetPinnedBytes())
+}
+
+func TestCache_Resize_Keep_Pinned(t *testing.T) {
+	c := New(1, 1<<12)
+
+	assert.Equal(t, nil, c.PutPinned([]byte("config"), []byte("value")))
+	for i := 0; i < 100; i++ {
+		c.Put([]byte(fmt.Sprintf("key:%d", i)), make([]byte, 20))
+	}
+
+	assert.Equal(t, nil, c.Resize(1<<9))
+
+	data := make([]byte, 10)
+	_, ok := c.Get([]byte("config"), data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, 1<<7, c.segments[0].maxPinnedBytes)
+
+	assert.Equal(t, nil, c.Resize(1<<14))
+	assert.Equal(t, 1<<12, c.segments[0].maxPinnedBytes)
+}
+
+func TestCache_Resize_Configured_Max_Pinned_Bytes(t *testing.T) {
+	c := New(1, 1<<12, WithMaxPinnedBytes(1<<10))
+
+	assert.Equal(t, nil, c.Resize(1<<10))
+	assert.Equal(t, 1<<9, c.segments[0].maxPinnedBytes)
+
+	// back to the configured value when growing
+	assert.Equal(t, nil, c.Resize(1<<14))
+	assert.Equal(t, 1<<10, c.segments[0].maxPinnedBytes)
+}
+
+func TestWithMaxPinnedBytes(t *testing.T) {
+	assert.Equal(t, 1<<10, newCacheOptions().getMaxPinnedBytes(1<<12))
+	assert.Equal(t, 100, newCacheOptions(WithMaxPinnedBytes(100)).getMaxPinnedBytes(1<<12))
+	assert.Equal(t, 1<<11, newCacheOptions(WithMaxPinnedBytes(1<<20)).getMaxPinnedBytes(1<<12))
+
+	assert.PanicsWithValue(t, "max pinned bytes must be > 0", func() {
+		WithMaxPinnedBytes(0)(newCacheOptions())
+	})
+}
+
+func putTestPinned(s *segment, hash uint32, key []byte, value []byte) error {
+	_, err := s.putPinned(hash, key, value, putParams{})
+	return err
+}
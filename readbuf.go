@@ -0,0 +1,168 @@
+package bigcache
+
+import (
+	"sync/atomic"
+	"unsafe"
+)
+
+const readBufferSize = 64
+
+const readRecordHit = uint64(1) << 32
+
+// readBuffer records the accesses of gets holding only the read lock of a segment, the records are applied
+// (access times, referenced flags, frequency sketch) later while holding the write lock. Records are dropped
+// when the buffer is full, so recency is only approximated under heavy read contention
+type readBuffer struct {
+	reads     [readBufferSize]uint64 // hash | readRecordHit
+	readCount uint32
+}
+
+func (s *segment) getWithReadLock(hash uint32, space keySpace, key []byte, value []byte) (int, LookupResult) {
+	s.mu.RLock()
+	n, result, invalid := s.getShared(hash, space, key, value)
+	s.mu.RUnlock()
+
+	if invalid {
+		s.mu.Lock()
+		s.removeInvalid(hash, space, key)
+		s.mu.Unlock()
+	} else if s.isReadBufferFull() && s.mu.TryLock() {
+		s.drainReads()
+		s.mu.Unlock()
+	}
+	return n, result
+}
+
+// getShared reads the entry only holding the read lock, the access is recorded in the read buffer.
+// Returns invalid = true if the entry exists but is expired, invalidated or corrupted, the caller should remove it
+// with removeInvalid while holding the write lock
+func (s *segment) getShared(
+	hash uint32, space keySpace, key []byte, value []byte,
+) (n int, result LookupResult, invalid bool) {
+	atomic.AddUint64(&s.accessCount, 1)
+	offset, ok := s.kv[hash]
+	if !ok {
+		s.recordMiss(hash)
+		return 0, LookupMiss, false
+	}
+
+	var headerData [maxEntryHeaderSize]byte
+	s.rb.readHeader(&headerData, offset)
+	header := (*entryHeader)(unsafe.Pointer(&headerData[0]))
+	if !s.keyEqual(header, offset, space, key) {
+		s.recordMiss(hash)
+		return 0, LookupMiss, false
+	}
+
+	now := s.getExpireNow()
+	if header.isExpired(now) || s.isInvalidated(header, offset, key) || s.isCorrupted(header, offset) {
+		return 0, LookupMiss, true
+	}
+
+	if header.isNegative() {
+		atomic.AddUint64(&s.negativeHitCount, 1)
+		s.recordHit(hash)
+		return 0, LookupNegative, false
+	}
+
+	atomic.AddUint64(&s.hitCount, 1)
+
+	readLen := int(header.valLen)
+	if readLen > len(value) {
+		readLen = len(value)
+	}
+	s.rb.readAt(value[:readLen], header.valueOffset(offset))
+
+	s.recordHit(hash)
+	return int(header.valLen), hitResult(header, now), false
+}
+
+// hitResult returns the result of a hit on the entry, with the bits of its encoding
+func hitResult(header *entryHeader, now uint32) LookupResult {
+	result := LookupHit
+	if header.isStale(now) {
+		result = LookupStale
+	}
+	if header.isChunked() {
+		result |= lookupChunked
+	}
+	if header.isCompressed() {
+		result |= lookupCompressed
+	}
+	return result | LookupResult(header.keyID)<<lookupKeyIDShift
+}
+
+// removeInvalid deletes the entry if it is expired, invalidated or corrupted
+func (s *segment) removeInvalid(hash uint32, space keySpace, key []byte) {
+	offset, ok := s.kv[hash]
+	if !ok {
+		return
+	}
+
+	var headerData [maxEntryHeaderSize]byte
+	s.rb.readHeader(&headerData, offset)
+	header := (*entryHeader)(unsafe.Pointer(&headerData[0]))
+	if !s.keyEqual(header, offset, space, key) {
+		return
+	}
+	if header.isExpired(s.getExpireNow()) {
+		s.deleteEntry(header, &headerData, offset)
+		atomic.AddUint64(&s.expiredCount, 1)
+	} else if s.isInvalidated(header, offset, key) {
+		s.deleteEntry(header, &headerData, offset)
+	} else if s.isCorrupted(header, offset) {
+		s.removeCorrupted(header, &headerData, offset)
+	}
+}
+
+func (s *segment) recordHit(hash uint32) {
+	s.recordRead(uint64(hash) | readRecordHit)
+}
+
+func (s *segment) recordMiss(hash uint32) {
+	s.recordRead(uint64(hash))
+}
+
+func (s *segment) recordRead(record uint64) {
+	index := atomic.AddUint32(&s.readCount, 1) - 1
+	if index >= readBufferSize {
+		return
+	}
+	s.reads[index] = record
+}
+
+func (s *segment) isReadBufferFull() bool {
+	return atomic.LoadUint32(&s.readCount) >= readBufferSize
+}
+
+// drainReads applies the recorded reads, must hold the write lock
+func (s *segment) drainReads() {
+	n := atomic.LoadUint32(&s.readCount)
+	if n == 0 {
+		return
+	}
+	if n > readBufferSize {
+		n = readBufferSize
+	}
+
+	var headerData [maxEntryHeaderSize]byte
+	header := (*entryHeader)(unsafe.Pointer(&headerData[0]))
+
+	for _, record := range s.reads[:n] {
+		hash := uint32(record)
+		if s.sketch != nil {
+			s.sketch.increase(hash)
+		}
+		if record&readRecordHit == 0 {
+			continue
+		}
+
+		offset, ok := s.kv[hash]
+		if !ok {
+			continue
+		}
+		s.rb.readHeader(&headerData, offset)
+		s.touch(header, &headerData, offset)
+	}
+	atomic.StoreUint32(&s.readCount, 0)
+}
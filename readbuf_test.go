@@ -0,0 +1,218 @@
+package bigcache
+
+import (
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+)
+
+func TestSegment_Get_Shared(t *testing.T) {
+	s := newSegment()
+	s.getNow = monoGetNow(100)
+
+	s.put(40, []byte{1, 2, 3}, []byte{10, 11, 12, 13})
+	assert.Equal(t, uint32(101), s.getHeader(40).accessTime)
+
+	data := make([]byte, 10)
+	n, result, expired := s.getShared(40, keySpace{}, []byte{1, 2, 3}, data)
+	assert.Equal(t, LookupHit, result)
+	assert.Equal(t, false, expired)
+	assert.Equal(t, []byte{10, 11, 12, 13}, data[:n])
+
+	_, result, _ = s.getShared(40, keySpace{}, []byte{1, 2, 4}, data)
+	assert.Equal(t, LookupMiss, result)
+	_, result, _ = s.getShared(41, keySpace{}, []byte{1, 2, 3}, data)
+	assert.Equal(t, LookupMiss, result)
+
+	assert.Equal(t, uint32(3), s.readCount)
+	assert.Equal(t, []uint64{40 | readRecordHit, 40, 41}, s.reads[:3])
+	assert.Equal(t, uint32(101), s.getHeader(40).accessTime)
+	assert.Equal(t, uint64(3), s.getAccessCount())
+	assert.Equal(t, uint64(1), s.getHitCount())
+
+	s.drainReads()
+	assert.Equal(t, uint32(0), s.readCount)
+	assert.Equal(t, &entryHeader{
+		hash:       40,
+		accessTime: 102,
+		keyLen:     3,
+		flags:      entryFlagReferenced,
+		valLen:     4,
+		valCap:     5,
+	}, s.getHeader(40))
+	assert.Equal(t, s.totalAge, s.getSumTotalAge())
+}
+
+func TestSegment_Read_Buffer_Full(t *testing.T) {
+	s := newSegmentTinyLFU(1024)
+	s.put(40, []byte{1, 2, 3}, []byte{10, 11, 12, 13})
+
+	for i := 0; i < readBufferSize+10; i++ {
+		_, result, _ := s.getShared(40, keySpace{}, []byte{1, 2, 3}, nil)
+		assert.Equal(t, LookupHit, result)
+	}
+	assert.Equal(t, true, s.isReadBufferFull())
+	assert.Equal(t, uint32(readBufferSize+10), s.readCount)
+
+	s.drainReads()
+	assert.Equal(t, false, s.isReadBufferFull())
+	assert.Equal(t, uint8(15), s.sketch.estimate(40))
+}
+
+func TestSegment_Put_Drain_Reads(t *testing.T) {
+	s := newSegment()
+	s.getNow = monoGetNow(100)
+
+	s.put(40, []byte{1, 2, 3}, []byte{10, 11, 12, 13})
+	s.getShared(40, keySpace{}, []byte{1, 2, 3}, nil)
+	s.put(41, []byte{1, 2, 4}, []byte{10, 11, 12, 13})
+
+	assert.Equal(t, uint32(0), s.readCount)
+	assert.Equal(t, uint32(102), s.getHeader(40).accessTime)
+	assert.Equal(t, uint32(103), s.getHeader(41).accessTime)
+}
+
+func TestSegment_Get_With_Read_Lock_Drain_When_Full(t *testing.T) {
+	s := newSegment()
+	s.put(40, []byte{1, 2, 3}, []byte{10, 11, 12, 13})
+
+	for i := 0; i < readBufferSize-1; i++ {
+		s.getWithReadLock(40, keySpace{}, []byte{1, 2, 3}, nil)
+	}
+	assert.Equal(t, uint32(readBufferSize-1), s.readCount)
+
+	s.getWithReadLock(40, keySpace{}, []byte{1, 2, 3}, nil)
+	assert.Equal(t, uint32(0), s.readCount)
+	assert.Equal(t, entryFlagReferenced, s.getHeader(40).flags)
+}
+
+func TestSegment_Get_With_Read_Lock_Remove_Expired(t *testing.T) {
+	s := newSegment()
+	now := uint32(100)
+	s.getExpireNow = func() uint32 { return now }
+
+	s.putWithParams(40, []byte{1, 2, 3}, []byte{10, 11, 12, 13}, putParams{expireAt: 110})
+
+	now = 110
+	_, result := s.getWithReadLock(40, keySpace{}, []byte{1, 2, 3}, nil)
+	assert.Equal(t, LookupMiss, result)
+	assert.Equal(t, uint64(0), s.getTotal())
+	assert.Equal(t, uint64(1), s.getExpiredCount())
+}
+
+func TestCache_Concurrent_Get_Put(t *testing.T) {
+	c := New(4, 1<<14, WithTinyLFUAdmission())
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			value := make([]byte, 8)
+			var key [8]byte
+			for i := 0; i < 5000; i++ {
+				k := uint64(i % 300)
+				binary.LittleEndian.PutUint64(key[:], k)
+				if g%4 == 0 {
+					c.Put(key[:], key[:])
+					continue
+				}
+				n, ok := c.Get(key[:], value)
+				if ok {
+					assert.Equal(t, key[:], value[:n])
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	for i := range c.segments {
+		assert.Equal(t, c.segments[i].totalAge, c.segments[i].getSumTotalAge())
+	}
+}
+
+func newBenchCache(keyCount int) (*Cache, [][]byte) {
+	c := New(8, 1<<20)
+	keys := make([][]byte, keyCount)
+	for i := range keys {
+		key := make([]byte, 8)
+		binary.LittleEndian.PutUint64(key, uint64(i))
+		keys[i] = key
+		c.Put(key, make([]byte, 64))
+	}
+	return c, keys
+}
+
+// getExclusive is Cache.Get holding the write lock of the segment, as before using the read lock
+func (c *Cache) getExclusive(key []byte, value []byte) (int, bool) {
+	seg, hash := c.getSegment(key)
+
+	seg.mu.Lock()
+	n, ok := seg.getAndApply(uint32(hash), key, value)
+	seg.mu.Unlock()
+
+	return n, ok
+}
+
+func BenchmarkCacheGet_Parallel_Hot_Keys(b *testing.B) {
+	c, keys := newBenchCache(16)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		value := make([]byte, 64)
+		i := 0
+		for pb.Next() {
+			c.Get(keys[i%len(keys)], value)
+			i++
+		}
+	})
+}
+
+func BenchmarkCacheGet_Parallel_Hot_Keys_Exclusive_Lock(b *testing.B) {
+	c, keys := newBenchCache(16)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		value := make([]byte, 64)
+		i := 0
+		for pb.Next() {
+			c.getExclusive(keys[i%len(keys)], value)
+			i++
+		}
+	})
+}
+
+func BenchmarkCacheGet_Parallel_Mixed_Put(b *testing.B) {
+	c, keys := newBenchCache(10000)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		value := make([]byte, 64)
+		i := 0
+		for pb.Next() {
+			key := keys[i%len(keys)]
+			if i%10 == 0 {
+				c.Put(key, value)
+			} else {
+				c.Get(key, value)
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkCacheGet_Parallel_Mixed_Put_Exclusive_Lock(b *testing.B) {
+	c, keys := newBenchCache(10000)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		value := make([]byte, 64)
+		i := 0
+		for pb.Next() {
+			key := keys[i%len(keys)]
+			if i%10 == 0 {
+				c.Put(key, value)
+			} else {
+				c.getExclusive(key, value)
+			}
+			i++
+		}
+	})
+}
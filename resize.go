@@ -0,0 +1,166 @@
+package bigcache
+
+import (
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"unsafe"
+)
+
+type resizeEntry struct {
+	offset  int
+	size    int
+	age     uint64
+	expired bool
+	pinned  bool
+	keep    bool
+}
+
+// Resize changes the memory size of the cache to about totalBytes, segments are resized one at a time,
+// the other segments continue serving requests. When shrinking, the least recently accessed entries are evicted.
+// Concurrent calls are serialized. If the allocator fails, the error is returned and the segments
+// before the failed one keep their new size
+func (c *Cache) Resize(totalBytes int) error {
+	segmentSize := totalBytes / len(c.segments)
+	if segmentSize < entryHeaderSize {
+		panic("segment size after resizing is too small")
+	}
+
+	c.resizeMu.Lock()
+	defer c.resizeMu.Unlock()
+
+	// chunks must fit into the segments during and after resizing
+	chunkSize := computeChunkSize(segmentSize)
+	if chunkSize < atomic.LoadInt64(&c.chunkSize) {
+		atomic.StoreInt64(&c.chunkSize, chunkSize)
+	}
+
+	for i := range c.segments {
+		if err := c.resizeSegment(i, segmentSize); err != nil {
+			return err
+		}
+	}
+	atomic.StoreInt64(&c.chunkSize, chunkSize)
+	return nil
+}
+
+func (c *Cache) resizeSegment(index int, segmentSize int) error {
+	data, err := c.allocator.Alloc(index, segmentSize)
+	if err != nil {
+		return fmt.Errorf("bigcache: allocate ring buffer of segment %d: %w", index, err)
+	}
+	if len(data) != segmentSize {
+		return fmt.Errorf("bigcache: allocator returned %d bytes instead of %d", len(data), segmentSize)
+	}
+
+	seg := &c.segments[index]
+	seg.mu.Lock()
+	oldData := seg.rb.data
+	seg.resize(data)
+	seg.maxPinnedBytes = computeMaxPinnedBytes(c.maxPinnedBytes, segmentSize)
+	seg.mu.Unlock()
+
+	if err := c.allocator.Free(index, oldData); err != nil {
+		return fmt.Errorf("bigcache: free ring buffer of segment %d: %w", index, err)
+	}
+	return nil
+}
+
+// GetCapacity returns the total size in bytes of the ring buffers
+func (c *Cache) GetCapacity() int {
+	total := 0
+	for i := range c.segments {
+		seg := &c.segments[i]
+		seg.mu.RLock()
+		total += len(seg.rb.data)
+		seg.mu.RUnlock()
+	}
+	return total
+}
+
+// resize moves live entries to the new ring buffer data
+func (s *segment) resize(data []byte) {
+	s.drainReads()
+	s.updateAccessNow()
+	now := s.getExpireNow()
+
+	var entries []resizeEntry
+	liveSize := 0
+	s.walkEntries(func(header *entryHeader, offset int) {
+		if header.deleted {
+			return
+		}
+		expired := header.isExpired(now)
+		entries = append(entries, resizeEntry{
+			offset:  offset,
+			size:    header.entrySize(),
+			age:     s.getAge(header.accessTime),
+			expired: expired,
+			pinned:  header.isPinned(),
+			keep:    !expired,
+		})
+		if !expired {
+			liveSize += header.entrySize()
+		}
+	})
+
+	if liveSize > len(data) {
+		selectMostRecentEntries(entries, len(data))
+	}
+
+	newRB := ringBuf{data: data}
+	var headerData [maxEntryHeaderSize]byte
+	header := (*entryHeader)(unsafe.Pointer(&headerData[0]))
+
+	for _, e := range entries {
+		s.rb.readHeader(&headerData, e.offset)
+		if !e.keep {
+			if e.expired {
+				atomic.AddUint64(&s.expiredCount, 1)
+			}
+			delete(s.kv, header.hash)
+			atomic.AddUint64(&s.total, ^uint64(0))
+			s.totalAge -= s.getAge(header.accessTime)
+			s.accountEntry(header, -e.size)
+			continue
+		}
+		s.kv[header.hash] = newRB.appendFrom(&s.rb, e.offset, e.size)
+	}
+
+	s.rb = newRB
+	if s.sketch != nil {
+		// the frequencies are counted again for the new capacity
+		s.sketch = newCountMinSketch(len(data) / sketchBytesPerEntry)
+	}
+	s.sweepPos = 0
+	s.compactEndPos = 0
+	atomic.StoreUint64(&s.deadBytes, 0)
+}
+
+// selectMostRecentEntries keeps the pinned entries then the most recently accessed entries having total size <= maxSize
+func selectMostRecentEntries(entries []resizeEntry, maxSize int) {
+	indices := make([]int, len(entries))
+	for i := range indices {
+		indices[i] = i
+	}
+	sort.SliceStable(indices, func(i, j int) bool {
+		a, b := &entries[indices[i]], &entries[indices[j]]
+		if a.pinned != b.pinned {
+			return a.pinned
+		}
+		return a.age < b.age
+	})
+
+	size := 0
+	for _, index := range indices {
+		e := &entries[index]
+		if !e.keep {
+			continue
+		}
+		if size+e.size > maxSize {
+			e.keep = false
+			continue
+		}
+		size += e.size
+	}
+}
@@ -0,0 +1,188 @@
+package bigcache
+
+import (
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+)
+
+func TestSegment_Resize_Grow(t *testing.T) {
+	const entrySize = entryHeaderSize + 8
+	s := newSegmentSize(entrySize * 4)
+	s.getNow = monoGetNow(0)
+
+	for i := 0; i < 6; i++ {
+		s.put(uint32(40+i), []byte{1, 2, uint8(i)}, []byte{101, 102, 103, uint8(100 + i)})
+	}
+	s.delete(44, keySpace{}, []byte{1, 2, 4})
+
+	s.resize(make([]byte, entrySize*10))
+
+	assert.Equal(t, entrySize*10, len(s.rb.data))
+	assert.Equal(t, 0, s.rb.getBegin())
+	assert.Equal(t, entrySize*3, s.rb.size)
+	assert.Equal(t, uint64(0), s.getDeadBytes())
+	assert.Equal(t, uint64(3), s.getTotal())
+	assert.Equal(t, 3, len(s.kv))
+	assert.Equal(t, s.totalAge, s.getSumTotalAge())
+
+	data := make([]byte, 100)
+	for _, i := range []int{2, 3, 5} {
+		n, ok := s.getAndApply(uint32(40+i), []byte{1, 2, uint8(i)}, data)
+		assert.Equal(t, true, ok)
+		assert.Equal(t, []byte{101, 102, 103, uint8(100 + i)}, data[:n])
+	}
+
+	for i := 10; i < 17; i++ {
+		s.put(uint32(40+i), []byte{1, 2, uint8(i)}, []byte{101, 102, 103, uint8(100 + i)})
+	}
+	assert.Equal(t, uint64(10), s.getTotal())
+}
+
+func TestSegment_Resize_Shrink_Evict_Least_Recent(t *testing.T) {
+	const entrySize = entryHeaderSize + 8
+	s := newSegmentSize(entrySize * 6)
+	s.getNow = monoGetNow(0)
+
+	for i := 0; i < 6; i++ {
+		s.put(uint32(40+i), []byte{1, 2, uint8(i)}, []byte{101, 102, 103, uint8(100 + i)})
+	}
+
+	data := make([]byte, 100)
+	s.getAndApply(40, []byte{1, 2, 0}, data)
+	s.getAndApply(42, []byte{1, 2, 2}, data)
+
+	s.resize(make([]byte, entrySize*3+10))
+
+	assert.Equal(t, uint64(3), s.getTotal())
+	assert.Equal(t, 3, len(s.kv))
+	assert.Equal(t, s.totalAge, s.getSumTotalAge())
+
+	for _, i := range []int{0, 2, 5} {
+		n, ok := s.getAndApply(uint32(40+i), []byte{1, 2, uint8(i)}, data)
+		assert.Equal(t, true, ok)
+		assert.Equal(t, []byte{101, 102, 103, uint8(100 + i)}, data[:n])
+	}
+	for _, i := range []int{1, 3, 4} {
+		_, ok := s.getAndApply(uint32(40+i), []byte{1, 2, uint8(i)}, data)
+		assert.Equal(t, false, ok)
+	}
+
+	// relative order is preserved
+	assert.Equal(t, 0, s.kv[40])
+	assert.Equal(t, entrySize, s.kv[42])
+	assert.Equal(t, entrySize*2, s.kv[45])
+}
+
+func TestSegment_Resize_Remove_Expired(t *testing.T) {
+	s := newSegment()
+	now := uint32(100)
+	s.getExpireNow = func() uint32 { return now }
+
+	s.putWithParams(40, []byte{1, 2, 0}, []byte{101}, putParams{expireAt: 110})
+	s.put(41, []byte{1, 2, 1}, []byte{102})
+
+	now = 110
+	s.resize(make([]byte, 512))
+
+	assert.Equal(t, uint64(1), s.getTotal())
+	assert.Equal(t, uint64(1), s.getExpiredCount())
+	assert.Equal(t, entryHeaderSize+4, s.rb.size)
+}
+
+func TestCache_Resize(t *testing.T) {
+	c := New(4, 1<<12)
+	assert.Equal(t, 4*(1<<12), c.GetCapacity())
+
+	for i := 0; i < 100; i++ {
+		c.Put([]byte(fmt.Sprint("key:", i)), []byte(fmt.Sprint("value:", i)))
+	}
+	total := c.GetTotal()
+
+	assert.Equal(t, nil, c.Resize(4*(1<<14)))
+	assert.Equal(t, 4*(1<<14), c.GetCapacity())
+	assert.Equal(t, total, c.GetTotal())
+
+	assert.Equal(t, nil, c.Resize(4*200))
+	assert.Equal(t, 4*200, c.GetCapacity())
+	assert.Greater(t, total, c.GetTotal())
+
+	value := make([]byte, 100)
+	for i := 0; i < 100; i++ {
+		n, ok := c.Get([]byte(fmt.Sprint("key:", i)), value)
+		if ok {
+			assert.Equal(t, fmt.Sprint("value:", i), string(value[:n]))
+		}
+	}
+
+	assert.PanicsWithValue(t, "segment size after resizing is too small", func() {
+		_ = c.Resize(4)
+	})
+}
+
+func TestCache_Resize_Concurrent(t *testing.T) {
+	c := New(4, 1<<14)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		value := make([]byte, 100)
+		for i := 0; i < 20000; i++ {
+			key := []byte(fmt.Sprint("key:", i%500))
+			c.Put(key, []byte(fmt.Sprint("value:", i%500)))
+			n, ok := c.Get(key, value)
+			if ok {
+				assert.Equal(t, fmt.Sprint("value:", i%500), string(value[:n]))
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			assert.Equal(t, nil, c.Resize(4*(1<<12+(i%5)*(1<<12))))
+		}
+	}()
+	wg.Wait()
+}
+
+type failingAllocator struct {
+	HeapAllocator
+	fail bool
+}
+
+func (a *failingAllocator) Alloc(segment int, size int) ([]byte, error) {
+	if a.fail {
+		return nil, errors.New("alloc error")
+	}
+	return a.HeapAllocator.Alloc(segment, size)
+}
+
+func TestCache_Resize_Alloc_Error(t *testing.T) {
+	allocator := &failingAllocator{}
+	c := New(4, 1<<12, WithAllocator(allocator))
+	c.Put([]byte("key"), []byte("value"))
+
+	allocator.fail = true
+	err := c.Resize(4 * (1 << 14))
+	assert.EqualError(t, err, "bigcache: allocate ring buffer of segment 0: alloc error")
+	assert.Equal(t, 4*(1<<12), c.GetCapacity())
+
+	value := make([]byte, 10)
+	n, ok := c.Get([]byte("key"), value)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "value", string(value[:n]))
+}
+
+func TestCache_Resize_Sketch(t *testing.T) {
+	c := New(4, 1<<14, WithTinyLFUAdmission())
+	assert.Equal(t, uint64(1<<10), c.segments[0].sketch.width)
+
+	assert.Equal(t, nil, c.Resize(4*(1<<16)))
+	assert.Equal(t, uint64(1<<12), c.segments[0].sketch.width)
+
+	assert.Equal(t, nil, c.Resize(4*(1<<12)))
+	assert.Equal(t, uint64(1<<8), c.segments[0].sketch.width)
+}
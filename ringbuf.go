@@ -2,12 +2,14 @@ package bigcache
 
 import (
 	"bytes"
+	"io"
 )
 
 type ringBuf struct {
-	begin int
-	size  int
-	data  []byte
+	begin    int
+	size     int
+	data     []byte
+	beginPos uint64 // the logical position of begin, only increases, begin = beginPos % len(data)
 }
 
 func newRingBuf(size int) ringBuf {
@@ -60,6 +62,21 @@ func (r *ringBuf) writeAt(data []byte, offset int) {
 	}
 }
 
+// readFrom reads exactly n bytes from src into the ring buffer at offset
+func (r *ringBuf) readFrom(src io.Reader, offset int, n int) error {
+	offset = offset % len(r.data)
+
+	firstPart := n
+	if offset+n > len(r.data) {
+		firstPart = len(r.data) - offset
+	}
+	if _, err := io.ReadFull(src, r.data[offset:offset+firstPart]); err != nil {
+		return err
+	}
+	_, err := io.ReadFull(src, r.data[:n-firstPart])
+	return err
+}
+
 func (r *ringBuf) getBegin() int {
 	return r.begin
 }
@@ -74,6 +91,15 @@ func (r *ringBuf) getAvailable() int {
 
 func (r *ringBuf) increaseBegin(n int) {
 	r.begin = (r.begin + n) % len(r.data)
+	r.beginPos += uint64(n)
+}
+
+func (r *ringBuf) getBeginPos() uint64 {
+	return r.beginPos
+}
+
+func (r *ringBuf) posToOffset(pos uint64) int {
+	return int(pos % uint64(len(r.data)))
 }
 
 func (r *ringBuf) skip(n int) {
@@ -128,3 +154,18 @@ func (r *ringBuf) evacuate(size int) int {
 	r.increaseBegin(size)
 	return end
 }
+
+// appendFrom appends size bytes of src starting at offset, returns the offset of the appended data
+func (r *ringBuf) appendFrom(src *ringBuf, offset int, size int) int {
+	max := len(r.data)
+	end := r.getEnd()
+	if end+size > max {
+		firstPart := max - end
+		src.readAt(r.data[end:], offset)
+		src.readAt(r.data[:size-firstPart], offset+firstPart)
+	} else {
+		src.readAt(r.data[end:end+size], offset)
+	}
+	r.size += size
+	return end
+}
@@ -1,7 +1,9 @@
 package bigcache
 
 import (
+	"bytes"
 	"github.com/stretchr/testify/assert"
+	"io"
 	"testing"
 )
 
@@ -206,3 +208,52 @@ func TestRingBuf_WriteAt_WrapAround(t *testing.T) {
 	rb.readAt(data, 5)
 	assert.Equal(t, []byte{10, 11, 12, 13, 14}, data)
 }
+
+func TestRingBuf_Begin_Pos(t *testing.T) {
+	rb := newRingBuf(16)
+	rb.append([]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10})
+	rb.skip(6)
+	assert.Equal(t, uint64(6), rb.getBeginPos())
+
+	rb.append([]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10})
+	rb.skip(8)
+	rb.evacuate(4)
+	assert.Equal(t, 2, rb.getBegin())
+	assert.Equal(t, uint64(18), rb.getBeginPos())
+	assert.Equal(t, 2, rb.posToOffset(rb.getBeginPos()))
+}
+
+func TestRingBuf_Append_From(t *testing.T) {
+	src := newRingBuf(8)
+	src.append([]byte{1, 2, 3, 4, 5, 6})
+	src.skip(4)
+	src.append([]byte{7, 8, 9, 10})
+
+	dst := newRingBuf(8)
+	dst.append([]byte{20, 21, 22, 23, 24, 25})
+	dst.skip(6)
+
+	offset := dst.appendFrom(&src, 4, 5)
+	assert.Equal(t, 6, offset)
+	assert.Equal(t, 5, dst.size)
+
+	data := make([]byte, 5)
+	dst.readAt(data, 6)
+	assert.Equal(t, []byte{5, 6, 7, 8, 9}, data)
+}
+
+func TestRingBuf_ReadFrom_WrapAround(t *testing.T) {
+	rb := newRingBuf(16)
+	rb.appendEmpty(12)
+
+	err := rb.readFrom(bytes.NewReader([]byte{1, 2, 3, 4, 5, 6, 7}), 12, 6)
+	assert.Equal(t, nil, err)
+
+	data := make([]byte, 6)
+	rb.readAt(data, 12)
+	assert.Equal(t, []byte{1, 2, 3, 4, 5, 6}, data)
+	assert.Equal(t, []byte{5, 6}, rb.data[:2])
+
+	err = rb.readFrom(bytes.NewReader([]byte{1, 2, 3}), 14, 6)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
@@ -0,0 +1,179 @@
+package bigcache
+
+import (
+	"errors"
+	"time"
+	"unsafe"
+)
+
+var errScannedEntryEvicted = errors.New("bigcache: scanned entry evicted")
+
+// scannedEntry is a live entry copied by collectEntries, value is the stored value before decoding
+type scannedEntry struct {
+	key      []byte
+	value    []byte
+	space    keySpace
+	expireAt uint32
+	flags    uint8
+	keyID    uint8
+}
+
+// scanEntries calls fn with the live entries of the Cache itself one segment at a time, ttl is the remaining
+// time to live or zero if the entry never expires. The chunks of big values are not returned separately.
+// The entries are copied without counting as accesses. The segments are not locked while calling fn,
+// entries put or deleted during the scan may be skipped
+func (c *Cache) scanEntries(fn func(key []byte, value []byte, ttl time.Duration) error) error {
+	for i := range c.segments {
+		entries := c.segments[i].collectEntries()
+		for k := range entries {
+			e := &entries[k]
+			ttl, ok := c.remainingTTL(e.expireAt)
+			if !ok {
+				continue
+			}
+			value, err := c.decodeScannedEntry(e)
+			if err != nil {
+				continue
+			}
+			if err := fn(e.key, value, ttl); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// remainingTTL returns the time to live of an entry expiring at expireAt, zero if it never expires,
+// or false if it is expired
+func (c *Cache) remainingTTL(expireAt uint32) (time.Duration, bool) {
+	if expireAt == 0 {
+		return 0, true
+	}
+	now := c.getExpireNow()
+	if expireAt <= now {
+		return 0, false
+	}
+	return time.Duration(expireAt-now) * time.Second, true
+}
+
+// decodeScannedEntry returns the value of the entry, reassembling, decrypting and decompressing it if needed
+func (c *Cache) decodeScannedEntry(e *scannedEntry) ([]byte, error) {
+	value := e.value
+	if e.flags&entryFlagChunked != 0 {
+		reassembled, err := c.readScannedChunks(e.space, value)
+		if err != nil {
+			return nil, err
+		}
+		value = reassembled
+	}
+
+	if e.keyID != 0 {
+		if c.keyring == nil {
+			return nil, ErrKeyIDNotFound
+		}
+		decrypted, err := c.keyring.decrypt(nil, e.keyID, value, e.key)
+		if err != nil {
+			return nil, err
+		}
+		value = decrypted
+	}
+
+	if e.flags&entryFlagCompressed != 0 {
+		if c.compressor == nil {
+			return nil, ErrInvalidEncoding
+		}
+		decompressed, err := c.compressor.Decompress(nil, value)
+		if err != nil {
+			return nil, err
+		}
+		value = decompressed
+	}
+	return value, nil
+}
+
+// readScannedChunks returns the value of a chunked entry of the key space from the manifest
+func (c *Cache) readScannedChunks(space keySpace, manifestData []byte) ([]byte, error) {
+	if len(manifestData) != chunkManifestSize {
+		return nil, ErrInvalidEncoding
+	}
+	manifest := decodeChunkManifest(manifestData)
+	value := make([]byte, manifest.totalLen)
+	chunkSpace := space.chunkSpace()
+	for i := 0; i < manifest.chunkCount(); i++ {
+		begin := i * int(manifest.chunkSize)
+		end := begin + int(manifest.chunkSize)
+		if end > len(value) {
+			end = len(value)
+		}
+
+		chunkKey := manifest.chunkKey(i)
+		chunkSeg, chunkHash := c.getSpaceSegment(chunkSpace, chunkKey[:])
+		n, ok := chunkSeg.copyValue(uint32(chunkHash), chunkSpace, chunkKey[:], value[begin:end])
+		if !ok || n != end-begin {
+			return nil, errScannedEntryEvicted
+		}
+	}
+	return value, nil
+}
+
+// collectEntries copies the live entries of the Cache itself, except chunks and negative entries, holding
+// the read lock. The entries of namespaces are skipped, their keys are only unique within the namespace.
+// The access times, the referenced bits and the stats are not changed
+func (s *segment) collectEntries() []scannedEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var entries []scannedEntry
+	now := s.getExpireNow()
+	s.walkEntries(func(header *entryHeader, offset int) {
+		if header.deleted || header.keySpace() != (keySpace{}) || header.isNegative() || header.isExpired(now) {
+			return
+		}
+		key := make([]byte, header.keyLen)
+		s.rb.readAt(key, header.keyOffset(offset))
+		if s.isInvalidated(header, offset, key) || s.isCorrupted(header, offset) {
+			return
+		}
+
+		value := make([]byte, header.valLen)
+		s.rb.readAt(value, header.valueOffset(offset))
+		entries = append(entries, scannedEntry{
+			key:      key,
+			value:    value,
+			space:    header.keySpace(),
+			expireAt: header.expireAt,
+			flags:    header.flags,
+			keyID:    header.keyID,
+		})
+	})
+	return entries
+}
+
+// copyValue copies the value of the live entry of the key into value holding the read lock,
+// the same as getShared but without counting as an access. Returns the length of the value
+func (s *segment) copyValue(hash uint32, space keySpace, key []byte, value []byte) (int, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	offset, ok := s.kv[hash]
+	if !ok {
+		return 0, false
+	}
+
+	var headerData [maxEntryHeaderSize]byte
+	s.rb.readHeader(&headerData, offset)
+	header := (*entryHeader)(unsafe.Pointer(&headerData[0]))
+	if !s.keyEqual(header, offset, space, key) {
+		return 0, false
+	}
+	if header.isExpired(s.getExpireNow()) || s.isInvalidated(header, offset, key) || s.isCorrupted(header, offset) {
+		return 0, false
+	}
+
+	readLen := int(header.valLen)
+	if readLen > len(value) {
+		readLen = len(value)
+	}
+	s.rb.readAt(value[:readLen], header.valueOffset(offset))
+	return int(header.valLen), true
+}
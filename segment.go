@@ -1,193 +1,721 @@
 package bigcache
 
 import (
-	"github.com/QuangTung97/bigcache/memhash"
+	"hash/crc32"
 	"sync"
 	"sync/atomic"
 	"unsafe"
 )
 
 type segment struct {
-	mu     sync.Mutex
+	mu     sync.RWMutex
 	rb     ringBuf
 	kv     map[uint32]int
 	getNow func() uint32
+	sketch *countMinSketch
 
+	getExpireNow func() uint32
+	sweepPos     uint64
+	invalidator  *invalidator
+	namespaces   *namespaceRegistry
+
+	namespaceBytes map[uint16]int // the size of the live entries of each namespace in this segment
+
+	pinnedBytes    uint64 // size of pinned entries
+	maxPinnedBytes int
+	checksumTable  *crc32.Table // nil if checksums are disabled
+	keyring        *Keyring     // nil if encryption is disabled
+
+	deadBytes     uint64 // size of entries marked deleted but still in the ring buffer
+	compactEndPos uint64 // the compaction is running while the begin position of rb is less than this
+
+	evictionPolicy           EvictionPolicy
 	maxConsecutiveEvacuation int
-	totalAccessTime          uint64
+	accessNow                uint32 // the access time when totalAge was last updated
+	totalAge                 uint64 // the sum of the ages of live entries at accessNow
 
 	total       uint64
 	accessCount uint64
 	hitCount    uint64
 
-	_padding [18]byte // for align with cache lines
+	expiredCount     uint64
+	negativeHitCount uint64
+	corruptedCount   uint64
+
+	readBuffer
+
+	_padding [40]byte // for align with cache lines
 }
 
+// entryHeader is the header of an entry in memory. In the ring buffer the header is stored as a core of
+// entryHeaderSize bytes (up to valCap) followed by the trailers flagged in flags: the expiry trailer
+// (expireAt, staleAt) and the extended trailer (epoch to checksum). The fields of absent trailers are zero
 type entryHeader struct {
 	hash       uint32
 	accessTime uint32
 	keyLen     uint16
 	deleted    bool
+	flags      uint8
 	valLen     uint32
 	valCap     uint32
+	expireAt   uint32 // in seconds of the expire clock, zero means never expire
+	staleAt    uint32 // in seconds of the expire clock, zero means never stale
+	epoch      uint32 // the invalidation epoch when the entry was put
+	tagCount   uint8  // the number of tag hashes stored between the key and the value
+	keyID      uint8  // the id of the encryption key, zero if the value is not encrypted
+	namespace  uint16 // the id of the namespace, zero for the entries of the Cache itself
+	checksum   uint32 // CRC32C of the key and the value if checksums are enabled
 }
 
-const entryHeaderSize = int(unsafe.Sizeof(entryHeader{}))
+type putParams struct {
+	expireAt  uint32
+	staleAt   uint32
+	flags     uint8
+	epoch     uint32
+	tags      []uint64
+	namespace uint16
+	keyID     uint8
+}
+
+func (p putParams) keySpace() keySpace {
+	return keySpace{namespace: p.namespace, chunk: p.flags&entryFlagChunk != 0}
+}
+
+const (
+	entryFlagReferenced uint8 = 1 << iota
+	entryFlagNegative         // the key is known to not exist, the entry has no value
+	entryFlagPinned           // the entry is never evicted
+	entryFlagChunked          // the value is a manifest of chunks stored in other entries
+	entryFlagChunk            // the entry is a chunk of a chunked value
+	entryFlagCompressed       // the value is compressed, for chunked values the chunks contain the compressed value
+	entryFlagExpiry           // the header has the expiry trailer
+	entryFlagExtended         // the header has the extended trailer
+)
+
+const entryTrailerMask = entryFlagExpiry | entryFlagExtended
+
+// entryHeaderSize is the size of the core of the header, stored for every entry
+const entryHeaderSize = int(unsafe.Offsetof(entryHeader{}.expireAt))
+const entryExtendedOffset = int(unsafe.Offsetof(entryHeader{}.epoch))
+const entryExpirySize = entryExtendedOffset - entryHeaderSize
+const entryExtendedSize = maxEntryHeaderSize - entryExtendedOffset
+
+// maxEntryHeaderSize is the size of the header with all of its trailers
+const maxEntryHeaderSize = int(unsafe.Sizeof(entryHeader{}))
 const entryHeaderAlign = int(unsafe.Alignof(entryHeader{}))
 const entryHeaderAlignMask = ^uint32(entryHeaderAlign - 1)
 
-func initSegment(s *segment, bufSize int) {
-	s.rb = newRingBuf(bufSize)
+func initSegment(s *segment, bufSize int, opts *cacheOptions) {
+	initSegmentWithData(s, make([]byte, bufSize), opts)
+}
+
+func initSegmentWithData(s *segment, data []byte, opts *cacheOptions) {
+	bufSize := len(data)
+	s.rb = ringBuf{data: data}
 	s.kv = map[uint32]int{}
-	s.getNow = getNowMono
+	s.getNow = newSegmentGetNow(opts)
+	s.getExpireNow = opts.getExpireNow
+	s.invalidator = opts.invalidator
+	s.namespaces = opts.namespaces
+	s.namespaceBytes = map[uint16]int{}
+	s.maxPinnedBytes = opts.getMaxPinnedBytes(bufSize)
+	s.keyring = opts.keyring
+	if opts.checksum {
+		s.checksumTable = crc32.MakeTable(crc32.Castagnoli)
+	}
+	s.evictionPolicy = opts.evictionPolicy
 	s.maxConsecutiveEvacuation = 5
+	if opts.tinyLFU {
+		s.sketch = newCountMinSketch(bufSize / sketchBytesPerEntry)
+	}
+}
+
+func (s *segment) put(hash uint32, key []byte, value []byte) {
+	s.putWithParams(hash, key, value, putParams{})
 }
 
-func getNowMono() uint32 {
-	return uint32(memhash.NanoTime() / 1000000000)
+// putWithParams returns false if the entry is not admitted, and the manifest of the replaced chunked value
+// of the key if any, the chunks of which must be deleted by the caller after unlocking (see Cache.deleteChunks)
+func (s *segment) putWithParams(hash uint32, key []byte, value []byte, params putParams) (chunkManifest, bool) {
+	offset, replaced, ok := s.reserve(hash, key, len(value), params)
+	if ok {
+		s.rb.writeAt(value, offset)
+		s.writeChecksum(hash)
+	}
+	return replaced, ok
 }
 
-func (s *segment) put(hash uint32, key []byte, value []byte) {
-	var headerData [entryHeaderSize]byte
+// reserve puts the entry without writing the value, returns the offset the value of size valLen should be
+// written to and the manifest of the replaced chunked value, or false if the entry is not admitted
+func (s *segment) reserve(hash uint32, key []byte, valLen int, params putParams) (int, chunkManifest, bool) {
+	s.drainReads()
+	now := s.updateAccessNow()
+	params.epoch = s.invalidator.getEpoch()
+
+	var headerData [maxEntryHeaderSize]byte
+	header := (*entryHeader)(unsafe.Pointer(&headerData[0]))
+
 	offset, existed := s.kv[hash]
+	if !existed && !s.admitNew(hash, key, valLen, params) {
+		return 0, chunkManifest{}, false
+	}
+	var replaced chunkManifest
 	if existed {
-		s.rb.readAt(headerData[:], offset)
-		header := (*entryHeader)(unsafe.Pointer(&headerData[0]))
-
-		s.totalAccessTime -= uint64(header.accessTime)
+		s.rb.readHeader(&headerData, offset)
+		s.totalAge -= s.getAge(header.accessTime)
 
-		if s.keyEqual(header, offset, key) {
-			if len(value) <= int(header.valCap) {
-				s.rb.writeAt(value, offset+entryHeaderSize+int(header.keyLen))
-				header.valLen = uint32(len(value))
-				header.accessTime = s.getNow()
-				s.totalAccessTime += uint64(header.accessTime)
-				s.rb.writeAt(headerData[:], offset)
-				return
-			}
+		keyEqual := s.keyEqual(header, offset, params.keySpace(), key)
+		if keyEqual {
+			replaced = s.readChunkManifest(header, offset)
+		}
+		if keyEqual && s.replaceInPlace(&headerData, offset, valLen, params, now) {
+			return header.valueOffset(offset), replaced, true
 		}
-		header.deleted = true
-		s.rb.writeAt(headerData[:], offset)
+		s.markReplaced(header, &headerData, offset)
 	}
 
-	keyLen := uint16(len(key))
-	valLen := uint32(len(value))
-	totalLen := uint32(keyLen) + valLen
+	offset = s.appendEntry(&headerData, hash, key, valLen, params, now)
+	s.kv[hash] = offset
+	s.accountEntry(header, header.entrySize())
+
+	if !existed {
+		atomic.AddUint64(&s.total, 1)
+	}
+	return header.valueOffset(offset), replaced, true
+}
+
+// admitNew returns false if the entry of a new key is not admitted by TinyLFU,
+// chunks are not subject to admission, their manifest is
+func (s *segment) admitNew(hash uint32, key []byte, valLen int, params putParams) bool {
+	if s.sketch == nil || params.flags&entryFlagChunk != 0 {
+		return true
+	}
+	s.sketch.increase(hash)
+	totalSize, _ := s.sizeOfEntry(key, valLen, params)
+	return params.isPinned() || s.admit(hash, totalSize)
+}
+
+// sizeOfEntry returns the size of an entry in the ring buffer and the aligned size of its key, tags and value
+func (s *segment) sizeOfEntry(key []byte, valLen int, params putParams) (int, uint32) {
+	totalLen := uint32(len(key) + len(params.tags)*tagSize + valLen)
 	totalLenAligned := nextNumberAlignToHeader(totalLen)
+	return headerSizeOf(s.trailerFlags(params)) + int(totalLenAligned), totalLenAligned
+}
+
+// replaceInPlace updates the entry at offset of the same key for a value of valLen bytes,
+// returns false if the value or the trailers do not fit into the entry
+func (s *segment) replaceInPlace(
+	headerData *[maxEntryHeaderSize]byte, offset int, valLen int, params putParams, now uint32,
+) bool {
+	header := (*entryHeader)(unsafe.Pointer(&headerData[0]))
+	trailers := s.trailerFlags(params)
+	if header.flags&entryTrailerMask != trailers {
+		return false
+	}
+	if valLen > int(header.valCap) || len(params.tags) != int(header.tagCount) {
+		return false
+	}
+
+	s.writeTags(params.tags, header.keyOffset(offset)+int(header.keyLen))
+	header.valLen = uint32(valLen)
+	header.epoch = params.epoch
+	header.keyID = params.keyID
+	header.expireAt = params.expireAt
+	header.staleAt = params.staleAt
+	s.accountEntry(header, -header.entrySize())
+	header.flags = header.flags&entryFlagReferenced | params.flags | trailers
+	s.accountEntry(header, header.entrySize())
+	header.accessTime = now
+	s.rb.writeHeader(headerData, offset)
+	return true
+}
 
-	totalSize := entryHeaderSize + int(totalLenAligned)
+// markReplaced marks the entry at offset deleted without removing it from kv, the key is put again
+func (s *segment) markReplaced(header *entryHeader, headerData *[maxEntryHeaderSize]byte, offset int) {
+	header.deleted = true
+	s.rb.writeHeader(headerData, offset)
+	atomic.AddUint64(&s.deadBytes, uint64(header.entrySize()))
+	s.accountEntry(header, -header.entrySize())
+}
+
+// appendEntry evacuates enough space then appends the header, the key and the tags of a new entry with room
+// for a value of valLen bytes. headerData is set to the header of the entry, the entry is not added to kv.
+// Returns the offset of the entry
+func (s *segment) appendEntry(
+	headerData *[maxEntryHeaderSize]byte, hash uint32, key []byte, valLen int, params putParams, now uint32,
+) int {
+	totalSize, totalLenAligned := s.sizeOfEntry(key, valLen, params)
 	s.evacuate(totalSize)
 
+	keyLen := uint16(len(key))
+	*headerData = [maxEntryHeaderSize]byte{}
 	header := (*entryHeader)(unsafe.Pointer(&headerData[0]))
 	header.hash = hash
-	header.accessTime = s.getNow()
+	header.accessTime = now
 	header.keyLen = keyLen
-	header.deleted = false
-	header.valLen = valLen
-	header.valCap = totalLenAligned - uint32(keyLen)
-
-	offset = s.rb.append(headerData[:])
+	header.flags = params.flags | s.trailerFlags(params)
+	header.valLen = uint32(valLen)
+	header.valCap = totalLenAligned - uint32(int(keyLen)+len(params.tags)*tagSize)
+	header.expireAt = params.expireAt
+	header.staleAt = params.staleAt
+	header.epoch = params.epoch
+	header.tagCount = uint8(len(params.tags))
+	header.namespace = params.namespace
+	header.keyID = params.keyID
+
+	offset := s.rb.appendHeader(headerData)
 	s.rb.append(key)
-	s.rb.append(value)
-	s.rb.appendEmpty(int(header.valCap - header.valLen))
-	s.kv[hash] = offset
+	s.appendTags(params.tags)
+	s.rb.appendEmpty(int(header.valCap))
+	return offset
+}
 
-	if !existed {
-		atomic.AddUint64(&s.total, 1)
-	}
-	s.totalAccessTime += uint64(header.accessTime)
+// evacuation is the state of evacuate across the entries at the head of the ring buffer
+type evacuation struct {
+	now           uint32
+	consecutive   int  // the number of entries relocated in a row by the eviction policy
+	protectedSize int  // the size of the pinned entries and the entries of namespaces under quota relocated
+	quotaEviction bool // the segment holds entries of namespaces over quota
 }
 
 func (s *segment) evacuate(expectedSize int) {
-	var headerData [entryHeaderSize]byte
-	consecutiveEvacuation := 0
+	var headerData [maxEntryHeaderSize]byte
+	state := evacuation{
+		now:           s.getExpireNow(),
+		quotaEviction: s.holdsOverQuotaBytes(),
+	}
 
 	for s.rb.getAvailable() < expectedSize {
 		offset := s.rb.getBegin()
-		s.rb.readAt(headerData[:], offset)
+		s.rb.readHeader(&headerData, offset)
 		header := (*entryHeader)(unsafe.Pointer(&headerData[0]))
 
-		size := entryHeaderSize + int(header.keyLen) + int(header.valCap)
-
-		expired := atomic.LoadUint64(&s.total)*uint64(header.accessTime) < s.totalAccessTime
-		if header.deleted || expired || consecutiveEvacuation >= s.maxConsecutiveEvacuation {
-			consecutiveEvacuation = 0
-			s.rb.skip(size)
-			if !header.deleted {
-				delete(s.kv, header.hash)
-				atomic.AddUint64(&s.total, ^uint64(0))
-				s.totalAccessTime -= uint64(header.accessTime)
+		decision, protected := s.decideEvacuation(header, offset, &state)
+		if decision == EvictionDrop {
+			state.consecutive = 0
+			s.dropHead(header)
+			if state.quotaEviction && header.namespace != 0 {
+				state.quotaEviction = s.holdsOverQuotaBytes()
 			}
+			continue
+		}
+
+		if header.flags&entryFlagReferenced != 0 {
+			header.flags &^= entryFlagReferenced
+			s.rb.writeHeader(&headerData, offset)
+		}
+		s.relocateHead(header)
+		if protected {
+			state.protectedSize += header.entrySize()
 		} else {
-			prevEnd := s.rb.evacuate(size)
-			s.kv[header.hash] = prevEnd
-			consecutiveEvacuation++
+			state.consecutive++
 		}
 	}
 }
 
-func (s *segment) get(hash uint32, key []byte, value []byte) (n int, ok bool) {
-	atomic.AddUint64(&s.accessCount, 1)
-	offset, ok := s.kv[hash]
-	if !ok {
-		return 0, false
+// decideEvacuation returns the decision for the entry at the head of the ring buffer, protected is true
+// if the entry is relocated regardless of maxConsecutiveEvacuation
+func (s *segment) decideEvacuation(header *entryHeader, offset int, state *evacuation) (EvictionDecision, bool) {
+	expired := header.isExpired(state.now)
+	if expired && !header.deleted {
+		atomic.AddUint64(&s.expiredCount, 1)
 	}
+	if header.deleted || expired || s.isInvalidated(header, offset, nil) {
+		return EvictionDrop, false
+	}
+	return s.decideLiveEvacuation(header, state)
+}
 
-	var headerData [entryHeaderSize]byte
-	s.rb.readAt(headerData[:], offset)
-	header := (*entryHeader)(unsafe.Pointer(&headerData[0]))
-	if !s.keyEqual(header, offset, key) {
-		return 0, false
+// decideLiveEvacuation is the same as decideEvacuation for an entry not deleted, expired or invalidated
+func (s *segment) decideLiveEvacuation(header *entryHeader, state *evacuation) (EvictionDecision, bool) {
+	// pinned entries and entries of namespaces under quota are relocated regardless of
+	// maxConsecutiveEvacuation but at most one pass of the ring buffer, only while this segment
+	// holds entries of namespaces over quota
+	if state.protectedSize < len(s.rb.data) && header.isPinned() {
+		return EvictionRelocate, true
+	}
+	if state.protectedSize < len(s.rb.data) && state.quotaEviction {
+		return s.decideQuotaEviction(header), true
+	}
+	if state.consecutive < s.maxConsecutiveEvacuation {
+		return s.evictionPolicy.Decide(EvictionEntry{
+			Age:             uint32(s.getAge(header.accessTime)),
+			Referenced:      header.flags&entryFlagReferenced != 0,
+			SegmentTotal:    atomic.LoadUint64(&s.total),
+			SegmentTotalAge: s.totalAge,
+		}), false
+	}
+	return EvictionDrop, false
+}
+
+// dropHead removes the entry at the head of the ring buffer, header is the header of that entry
+func (s *segment) dropHead(header *entryHeader) {
+	size := header.entrySize()
+	s.rb.skip(size)
+	if header.deleted {
+		atomic.AddUint64(&s.deadBytes, ^uint64(size-1))
+		return
 	}
+	delete(s.kv, header.hash)
+	atomic.AddUint64(&s.total, ^uint64(0))
+	s.totalAge -= s.getAge(header.accessTime)
+	s.accountEntry(header, -size)
+}
 
-	atomic.AddUint64(&s.hitCount, 1)
+// accountEntry adds the size of a live entry to the namespace and pinned accounting, delta is negative
+// when the entry is removed
+func (s *segment) accountEntry(header *entryHeader, delta int) {
+	if header.namespace != 0 {
+		s.namespaces.addUsage(header.namespace, delta)
+		if size := s.namespaceBytes[header.namespace] + delta; size != 0 {
+			s.namespaceBytes[header.namespace] = size
+		} else {
+			delete(s.namespaceBytes, header.namespace)
+		}
+	}
+	if header.isPinned() {
+		atomic.AddUint64(&s.pinnedBytes, uint64(delta))
+	}
+}
 
-	readLen := int(header.valLen)
-	if readLen > len(value) {
-		readLen = len(value)
+// holdsOverQuotaBytes returns true if the segment contains entries of namespaces over their quota
+func (s *segment) holdsOverQuotaBytes() bool {
+	if !s.namespaces.hasOverQuota() {
+		return false
 	}
-	s.rb.readAt(value[:readLen], offset+entryHeaderSize+int(header.keyLen))
+	for id := range s.namespaceBytes {
+		if ns := s.namespaces.get(id); ns != nil && ns.isOverQuota() {
+			return true
+		}
+	}
+	return false
+}
 
-	s.totalAccessTime -= uint64(header.accessTime)
-	header.accessTime = s.getNow()
-	s.rb.writeAt(headerData[:], offset)
-	s.totalAccessTime += uint64(header.accessTime)
+// relocateHead moves the entry at the head of the ring buffer to the tail
+func (s *segment) relocateHead(header *entryHeader) {
+	prevEnd := s.rb.evacuate(header.entrySize())
+	s.kv[header.hash] = prevEnd
+}
+
+// admit checks whether a new entry is more valuable than the entry at the head of the ring buffer
+func (s *segment) admit(hash uint32, size int) bool {
+	if s.rb.getAvailable() >= size {
+		return true
+	}
+
+	var headerData [maxEntryHeaderSize]byte
+	s.rb.readHeader(&headerData, s.rb.getBegin())
+	victim := (*entryHeader)(unsafe.Pointer(&headerData[0]))
+	if victim.deleted {
+		return true
+	}
+	return s.sketch.estimate(hash) > s.sketch.estimate(victim.hash)
+}
+
+// touch updates the access time and sets the referenced flag, headerData is the header of the entry
+func (s *segment) touch(header *entryHeader, headerData *[maxEntryHeaderSize]byte, offset int) {
+	now := s.updateAccessNow()
+	s.totalAge -= s.getAge(header.accessTime)
+	header.accessTime = now
+	header.flags |= entryFlagReferenced
+	s.rb.writeHeader(headerData, offset)
+}
+
+// updateAccessNow advances accessNow to the current access time, the ages of all live entries increase.
+// Access times are uint32 and wrap around, they are only compared through ages: an entry not accessed
+// for 2^32 ticks looks recently accessed
+func (s *segment) updateAccessNow() uint32 {
+	now := s.getNow()
+	s.totalAge += atomic.LoadUint64(&s.total) * uint64(now-s.accessNow)
+	s.accessNow = now
+	return now
+}
+
+// getAge returns the age at accessNow of an entry accessed at accessTime
+func (s *segment) getAge(accessTime uint32) uint64 {
+	return uint64(s.accessNow - accessTime)
+}
 
-	return int(header.valLen), true
+func (s *segment) delete(hash uint32, space keySpace, key []byte) bool {
+	affected, _ := s.deleteWithManifest(hash, space, key)
+	return affected
 }
 
-func (s *segment) delete(hash uint32, key []byte) bool {
+// deleteWithManifest is the same as delete but also returns the manifest of a chunked value, even if expired,
+// the chunks must be deleted by the caller. The manifest is zero for the values that are not chunked
+func (s *segment) deleteWithManifest(hash uint32, space keySpace, key []byte) (bool, chunkManifest) {
+	s.drainReads()
+
 	offset, ok := s.kv[hash]
 	if !ok {
-		return false
+		return false, chunkManifest{}
 	}
 
-	var headerData [entryHeaderSize]byte
-	s.rb.readAt(headerData[:], offset)
+	var headerData [maxEntryHeaderSize]byte
+	s.rb.readHeader(&headerData, offset)
 	header := (*entryHeader)(unsafe.Pointer(&headerData[0]))
-	if !s.keyEqual(header, offset, key) {
-		return false
+	if !s.keyEqual(header, offset, space, key) {
+		return false, chunkManifest{}
 	}
 
+	manifest := s.readChunkManifest(header, offset)
+	expired := header.isExpired(s.getExpireNow())
+	invalidated := !expired && s.isInvalidated(header, offset, key)
+	s.deleteEntry(header, &headerData, offset)
+	if expired {
+		atomic.AddUint64(&s.expiredCount, 1)
+		return false, manifest
+	}
+	return !invalidated, manifest
+}
+
+// readChunkManifest returns the manifest of the entry at offset, zero if the entry is not chunked
+func (s *segment) readChunkManifest(header *entryHeader, offset int) chunkManifest {
+	if !header.isChunked() || header.valLen != chunkManifestSize {
+		return chunkManifest{}
+	}
+	var data [chunkManifestSize]byte
+	s.rb.readAt(data[:], header.valueOffset(offset))
+	if m := decodeChunkManifest(data[:]); m.chunkSize > 0 {
+		return m
+	}
+	return chunkManifest{}
+}
+
+// deleteEntry marks the entry deleted and removes it from the index, headerData is the header of the entry
+func (s *segment) deleteEntry(header *entryHeader, headerData *[maxEntryHeaderSize]byte, offset int) {
 	header.deleted = true
-	s.rb.writeAt(headerData[:], offset)
-	delete(s.kv, hash)
+	s.rb.writeHeader(headerData, offset)
+	atomic.AddUint64(&s.deadBytes, uint64(header.entrySize()))
+	delete(s.kv, header.hash)
 	atomic.AddUint64(&s.total, ^uint64(0))
-	s.totalAccessTime -= uint64(header.accessTime)
+	s.totalAge -= s.getAge(header.accessTime)
+	s.accountEntry(header, -header.entrySize())
+}
 
-	return true
+// sweepExpired deletes expired and invalidated entries, starting from where the previous call stopped,
+// checks at most maxEntries entries and returns true if reached the end of the ring buffer
+func (s *segment) sweepExpired(maxEntries int) (deleted int, done bool) {
+	var headerData [maxEntryHeaderSize]byte
+	now := s.getExpireNow()
+
+	beginPos := s.rb.getBeginPos()
+	endPos := beginPos + uint64(s.rb.size)
+	if s.sweepPos < beginPos || s.sweepPos >= endPos {
+		s.sweepPos = beginPos
+	}
+
+	for i := 0; i < maxEntries; i++ {
+		if s.sweepPos >= endPos {
+			return deleted, true
+		}
+
+		offset := s.rb.posToOffset(s.sweepPos)
+		s.rb.readHeader(&headerData, offset)
+		header := (*entryHeader)(unsafe.Pointer(&headerData[0]))
+		s.sweepPos += uint64(header.entrySize())
+
+		if header.deleted {
+			continue
+		}
+		if header.isExpired(now) {
+			s.deleteEntry(header, &headerData, offset)
+			atomic.AddUint64(&s.expiredCount, 1)
+			deleted++
+		} else if s.isInvalidated(header, offset, nil) {
+			s.deleteEntry(header, &headerData, offset)
+			deleted++
+		}
+	}
+	return deleted, s.sweepPos >= endPos
+}
+
+// walkEntries calls fn for every entry in the ring buffer from the head to the tail, including deleted entries
+func (s *segment) walkEntries(fn func(header *entryHeader, offset int)) {
+	var headerData [maxEntryHeaderSize]byte
+	header := (*entryHeader)(unsafe.Pointer(&headerData[0]))
+
+	pos := s.rb.getBeginPos()
+	endPos := pos + uint64(s.rb.size)
+	for pos < endPos {
+		offset := s.rb.posToOffset(pos)
+		s.rb.readHeader(&headerData, offset)
+		pos += uint64(header.entrySize())
+		fn(header, offset)
+	}
 }
 
-func (s *segment) keyEqual(header *entryHeader, offset int, key []byte) bool {
-	if int(header.keyLen) != len(key) {
+func (s *segment) keyEqual(header *entryHeader, offset int, space keySpace, key []byte) bool {
+	if int(header.keyLen) != len(key) || header.keySpace() != space {
 		return false
 	}
-	if ok := s.rb.bytesEqual(offset+entryHeaderSize, key); !ok {
+	if ok := s.rb.bytesEqual(header.keyOffset(offset), key); !ok {
 		return false
 	}
 	return true
 }
 
+// startCompaction starts a compaction if the ratio of dead bytes is at least minDeadRatio,
+// returns true if a compaction is running
+func (s *segment) startCompaction(minDeadRatio float64) bool {
+	beginPos := s.rb.getBeginPos()
+	if s.compactEndPos > beginPos {
+		return true
+	}
+	if s.getDeadBytes() == 0 || s.getDeadRatio() < minDeadRatio {
+		return false
+	}
+	s.compactEndPos = beginPos + uint64(s.rb.size)
+	return true
+}
+
+// compactStep removes deleted entries by relocating live entries from the head to the tail of the
+// ring buffer, until all entries existed at the start of the compaction are processed.
+// Processes at most maxEntries entries, returns true if the compaction is finished
+func (s *segment) compactStep(maxEntries int) bool {
+	var headerData [maxEntryHeaderSize]byte
+	now := s.getExpireNow()
+
+	for i := 0; i < maxEntries; i++ {
+		if s.rb.getBeginPos() >= s.compactEndPos {
+			return true
+		}
+
+		s.rb.readHeader(&headerData, s.rb.getBegin())
+		header := (*entryHeader)(unsafe.Pointer(&headerData[0]))
+
+		if !header.deleted && header.isExpired(now) {
+			atomic.AddUint64(&s.expiredCount, 1)
+		} else if !header.deleted {
+			s.relocateHead(header)
+			continue
+		}
+		s.dropHead(header)
+	}
+	return s.rb.getBeginPos() >= s.compactEndPos
+}
+
+func (h *entryHeader) entrySize() int {
+	return h.headerSize() + int(h.keyLen) + int(h.tagCount)*tagSize + int(h.valCap)
+}
+
+func (h *entryHeader) keyOffset(offset int) int {
+	return offset + h.headerSize()
+}
+
+func (h *entryHeader) valueOffset(offset int) int {
+	return offset + h.headerSize() + int(h.keyLen) + int(h.tagCount)*tagSize
+}
+
+// headerSize returns the size of the stored header, including its trailers
+func (h *entryHeader) headerSize() int {
+	return headerSizeOf(h.flags)
+}
+
+func headerSizeOf(flags uint8) int {
+	size := entryHeaderSize
+	if flags&entryFlagExpiry != 0 {
+		size += entryExpirySize
+	}
+	if flags&entryFlagExtended != 0 {
+		size += entryExtendedSize
+	}
+	return size
+}
+
+// trailerFlags returns the flags of the trailers needed to store the fields of params,
+// the extended trailer is always needed for the checksum if checksums are enabled
+func (s *segment) trailerFlags(params putParams) uint8 {
+	var flags uint8
+	if params.expireAt != 0 || params.staleAt != 0 {
+		flags |= entryFlagExpiry
+	}
+	extended := params.epoch != 0 || len(params.tags) != 0 || params.keyID != 0 || params.namespace != 0
+	if extended || s.checksumTable != nil {
+		flags |= entryFlagExtended
+	}
+	return flags
+}
+
+// packHeader copies the stored form of the header in headerData into buf: the core followed by its trailers
+func packHeader(headerData *[maxEntryHeaderSize]byte, buf *[maxEntryHeaderSize]byte) []byte {
+	flags := (*entryHeader)(unsafe.Pointer(&headerData[0])).flags
+	n := copy(buf[:], headerData[:entryHeaderSize])
+	if flags&entryFlagExpiry != 0 {
+		n += copy(buf[n:], headerData[entryHeaderSize:entryExtendedOffset])
+	}
+	if flags&entryFlagExtended != 0 {
+		n += copy(buf[n:], headerData[entryExtendedOffset:])
+	}
+	return buf[:n]
+}
+
+// unpackHeader moves the trailers stored right after the core of headerData to their fields
+// and zeroes the fields of absent trailers
+func unpackHeader(headerData *[maxEntryHeaderSize]byte) {
+	flags := (*entryHeader)(unsafe.Pointer(&headerData[0])).flags
+	expiry := flags&entryFlagExpiry != 0
+	extended := flags&entryFlagExtended != 0
+	if extended && !expiry {
+		copy(headerData[entryExtendedOffset:], headerData[entryHeaderSize:entryHeaderSize+entryExtendedSize])
+	}
+	if !expiry {
+		clearBytes(headerData[entryHeaderSize:entryExtendedOffset])
+	}
+	if !extended {
+		clearBytes(headerData[entryExtendedOffset:])
+	}
+}
+
+func clearBytes(data []byte) {
+	for i := range data {
+		data[i] = 0
+	}
+}
+
+// readHeader reads the stored header at offset into headerData
+func (r *ringBuf) readHeader(headerData *[maxEntryHeaderSize]byte, offset int) {
+	r.readAt(headerData[:entryHeaderSize], offset)
+	flags := (*entryHeader)(unsafe.Pointer(&headerData[0])).flags
+	r.readAt(headerData[entryHeaderSize:headerSizeOf(flags)], offset+entryHeaderSize)
+	unpackHeader(headerData)
+}
+
+// writeHeader stores the header in headerData at offset, the trailers flagged must not change
+func (r *ringBuf) writeHeader(headerData *[maxEntryHeaderSize]byte, offset int) {
+	var buf [maxEntryHeaderSize]byte
+	r.writeAt(packHeader(headerData, &buf), offset)
+}
+
+func (r *ringBuf) appendHeader(headerData *[maxEntryHeaderSize]byte) int {
+	var buf [maxEntryHeaderSize]byte
+	return r.append(packHeader(headerData, &buf))
+}
+
+func (h *entryHeader) isPinned() bool {
+	return h.flags&entryFlagPinned != 0
+}
+
+func (h *entryHeader) isChunked() bool {
+	return h.flags&entryFlagChunked != 0
+}
+
+func (h *entryHeader) isCompressed() bool {
+	return h.flags&entryFlagCompressed != 0
+}
+
+func (h *entryHeader) isNegative() bool {
+	return h.flags&entryFlagNegative != 0
+}
+
+func (h *entryHeader) isExpired(now uint32) bool {
+	return h.expireAt != 0 && now >= h.expireAt
+}
+
+func (h *entryHeader) isStale(now uint32) bool {
+	return h.staleAt != 0 && now >= h.staleAt
+}
+
 func (s *segment) getTotal() uint64 {
 	return atomic.LoadUint64(&s.total)
 }
@@ -200,6 +728,30 @@ func (s *segment) getAccessCount() uint64 {
 	return atomic.LoadUint64(&s.accessCount)
 }
 
+func (s *segment) getExpiredCount() uint64 {
+	return atomic.LoadUint64(&s.expiredCount)
+}
+
+func (s *segment) getNegativeHitCount() uint64 {
+	return atomic.LoadUint64(&s.negativeHitCount)
+}
+
+func (s *segment) getPinnedBytes() uint64 {
+	return atomic.LoadUint64(&s.pinnedBytes)
+}
+
+func (s *segment) getCorruptedCount() uint64 {
+	return atomic.LoadUint64(&s.corruptedCount)
+}
+
+func (s *segment) getDeadBytes() uint64 {
+	return atomic.LoadUint64(&s.deadBytes)
+}
+
+func (s *segment) getDeadRatio() float64 {
+	return float64(s.getDeadBytes()) / float64(len(s.rb.data))
+}
+
 func nextNumberAlignToHeader(n uint32) uint32 {
 	return (n + uint32(entryHeaderAlign) - 1) & entryHeaderAlignMask
 }
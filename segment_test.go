@@ -11,40 +11,52 @@ import (
 
 func TestEntryHeaderAlign(t *testing.T) {
 	assert.Equal(t, 20, entryHeaderSize)
+	assert.Equal(t, 40, maxEntryHeaderSize)
 	assert.Equal(t, 4, entryHeaderAlign)
 }
 
 func newSegment() *segment {
 	s := &segment{}
-	initSegment(s, 1024)
+	initSegment(s, 1024, newCacheOptions())
 	return s
 }
 
 func newSegmentSize(bufSize int) *segment {
 	s := &segment{}
-	initSegment(s, bufSize)
+	initSegment(s, bufSize, newCacheOptions())
 	return s
 }
 
 func (s *segment) getHeader(hash uint32) *entryHeader {
 	offset := s.kv[hash]
-	var headerData [entryHeaderSize]byte
-	s.rb.readAt(headerData[:], offset)
+	var headerData [maxEntryHeaderSize]byte
+	s.rb.readHeader(&headerData, offset)
 	return (*entryHeader)(unsafe.Pointer(&headerData[0]))
 }
 
-func (s *segment) getSumTotalAccessTime() uint64 {
-	totalAccess := uint64(0)
+// getAndApply reads the entry through getShared as Cache.Get does, then removes the entry if invalid and
+// applies the recorded read as getWithReadLock and the next write do
+func (s *segment) getAndApply(hash uint32, key []byte, value []byte) (int, bool) {
+	n, result, invalid := s.getShared(hash, keySpace{}, key, value)
+	if invalid {
+		s.removeInvalid(hash, keySpace{}, key)
+	}
+	s.drainReads()
+	return n, (result &^ lookupFlagMask).IsFound()
+}
+
+func (s *segment) getSumTotalAge() uint64 {
+	totalAge := uint64(0)
 	for _, offset := range s.kv {
 		header := s.getHeaderAtOffset(offset)
-		totalAccess += uint64(header.accessTime)
+		totalAge += s.getAge(header.accessTime)
 	}
-	return totalAccess
+	return totalAge
 }
 
 func (s *segment) getHeaderAtOffset(offset int) *entryHeader {
-	var headerData [entryHeaderSize]byte
-	s.rb.readAt(headerData[:], offset)
+	var headerData [maxEntryHeaderSize]byte
+	s.rb.readHeader(&headerData, offset)
 	return (*entryHeader)(unsafe.Pointer(&headerData[0]))
 }
 
@@ -65,7 +77,7 @@ func TestNextNumberAlignToHeader(t *testing.T) {
 }
 
 func TestSegmentSizeAlignToCacheLine(t *testing.T) {
-	assert.Equal(t, 64*2, int(unsafe.Sizeof(segment{})))
+	assert.Equal(t, 64*13, int(unsafe.Sizeof(segment{})))
 }
 
 func TestSegment_Simple_Set_Get(t *testing.T) {
@@ -73,7 +85,7 @@ func TestSegment_Simple_Set_Get(t *testing.T) {
 	s.put(40, []byte{1, 2, 3}, []byte{10, 11, 12, 13})
 
 	data := make([]byte, 10)
-	n, ok := s.get(40, []byte{1, 2, 3}, data)
+	n, ok := s.getAndApply(40, []byte{1, 2, 3}, data)
 	assert.Equal(t, true, ok)
 	assert.Equal(t, 4, n)
 	assert.Equal(t, []byte{10, 11, 12, 13}, data[:n])
@@ -91,7 +103,7 @@ func TestSegment_Simple_Set_With_Not_Enough_Space(t *testing.T) {
 	s.put(40, []byte{1, 2, 3}, []byte{10, 11, 12, 13, 14, 15})
 
 	data := make([]byte, 5)
-	n, ok := s.get(40, []byte{1, 2, 3}, data)
+	n, ok := s.getAndApply(40, []byte{1, 2, 3}, data)
 	assert.Equal(t, true, ok)
 	assert.Equal(t, 6, n)
 	assert.Equal(t, []byte{10, 11, 12, 13, 14}, data)
@@ -101,7 +113,7 @@ func TestSegment_Set_Get_Not_Equal_Hash(t *testing.T) {
 	s := newSegment()
 	s.put(40, []byte{1, 2, 3}, []byte{10, 11, 12, 13})
 
-	n, ok := s.get(50, []byte{1, 2, 3}, nil)
+	n, ok := s.getAndApply(50, []byte{1, 2, 3}, nil)
 	assert.Equal(t, false, ok)
 	assert.Equal(t, 0, n)
 
@@ -115,7 +127,7 @@ func TestSegment_Set_Get_Key_Not_Equal_Length(t *testing.T) {
 
 	assert.Equal(t, uint64(0), s.getAccessCount())
 
-	n, ok := s.get(40, []byte{1, 2, 3}, nil)
+	n, ok := s.getAndApply(40, []byte{1, 2, 3}, nil)
 	assert.Equal(t, false, ok)
 	assert.Equal(t, 0, n)
 
@@ -127,7 +139,7 @@ func TestSegment_Set_Get_Not_Equal_Key(t *testing.T) {
 	s := newSegment()
 	s.put(40, []byte{1, 2, 3}, []byte{10, 11, 12, 13})
 
-	n, ok := s.get(40, []byte{1, 2, 4}, nil)
+	n, ok := s.getAndApply(40, []byte{1, 2, 4}, nil)
 	assert.Equal(t, false, ok)
 	assert.Equal(t, 0, n)
 
@@ -158,7 +170,7 @@ func TestSegment_Get_Update_Access_Time(t *testing.T) {
 
 	s.getNow = func() uint32 { return 140 }
 	data := make([]byte, 4)
-	s.get(40, []byte{1, 2, 3}, data)
+	s.getAndApply(40, []byte{1, 2, 3}, data)
 
 	header := s.getHeader(40)
 	assert.Equal(t, &entryHeader{
@@ -166,6 +178,7 @@ func TestSegment_Get_Update_Access_Time(t *testing.T) {
 		accessTime: 140,
 		keyLen:     3,
 		deleted:    false,
+		flags:      entryFlagReferenced,
 		valLen:     4,
 		valCap:     5,
 	}, header)
@@ -183,7 +196,7 @@ func TestSegment_Put_With_Exist_Key_Same_Length(t *testing.T) {
 	assert.Equal(t, prevAvail, s.rb.getAvailable())
 
 	data := make([]byte, 100)
-	n, ok := s.get(40, []byte{1, 2, 3}, data)
+	n, ok := s.getAndApply(40, []byte{1, 2, 3}, data)
 	assert.Equal(t, true, ok)
 	assert.Equal(t, []byte{20, 21, 22, 23}, data[:n])
 }
@@ -213,7 +226,7 @@ func TestSegment_Put_With_Exist_Key_Same_Length_Different_Length_Still_In_Cap(t
 	}, header)
 
 	data := make([]byte, 100)
-	n, ok := s.get(40, []byte{1, 2, 3}, data)
+	n, ok := s.getAndApply(40, []byte{1, 2, 3}, data)
 	assert.Equal(t, true, ok)
 	assert.Equal(t, []byte{20, 21, 22, 23, 24}, data[:n])
 }
@@ -232,7 +245,7 @@ func TestSegment_Put_With_Exist_Key_Not_In_Cap(t *testing.T) {
 	assert.Equal(t, prevAvail-entryHeaderSize-12, s.rb.getAvailable())
 
 	data := make([]byte, 100)
-	n, ok := s.get(40, []byte{1, 2, 3}, data)
+	n, ok := s.getAndApply(40, []byte{1, 2, 3}, data)
 	assert.Equal(t, true, ok)
 	assert.Equal(t, []byte{20, 21, 22, 23, 24, 25}, data[:n])
 
@@ -249,12 +262,12 @@ func TestSegment_Put_Same_Hash_Diff_Key(t *testing.T) {
 	assert.Equal(t, true, header.deleted)
 
 	data := make([]byte, 100)
-	n, ok := s.get(40, []byte{1, 2, 3}, data)
+	n, ok := s.getAndApply(40, []byte{1, 2, 3}, data)
 	assert.Equal(t, false, ok)
 	assert.Equal(t, 0, n)
 
 	data = make([]byte, 100)
-	n, ok = s.get(40, []byte{5, 6, 7, 8, 9}, data)
+	n, ok = s.getAndApply(40, []byte{5, 6, 7, 8, 9}, data)
 	assert.Equal(t, true, ok)
 	assert.Equal(t, []byte{20, 21, 22, 23}, data[:n])
 }
@@ -273,11 +286,11 @@ func TestSegment_Put_Evacuate(t *testing.T) {
 	assert.Equal(t, entryHeaderSize+8, s.rb.getBegin())
 
 	data := make([]byte, 100)
-	n, ok := s.get(40, []byte{1, 2, 3}, data)
+	n, ok := s.getAndApply(40, []byte{1, 2, 3}, data)
 	assert.Equal(t, false, ok)
 	assert.Equal(t, 0, n)
 
-	assert.Equal(t, s.totalAccessTime, s.getSumTotalAccessTime())
+	assert.Equal(t, s.totalAge, s.getSumTotalAge())
 }
 
 func TestSegment_Put_Evacuate_Skip_Recent_Used(t *testing.T) {
@@ -296,23 +309,23 @@ func TestSegment_Put_Evacuate_Skip_Recent_Used(t *testing.T) {
 
 	data := make([]byte, 100)
 
-	s.get(40, []byte{1, 2, 0}, data)
+	s.getAndApply(40, []byte{1, 2, 0}, data)
 	s.put(45, []byte{1, 2, 5}, []byte{101, 102, 103, 105})
 
 	assert.Equal(t, uint64(5), s.getTotal())
 
 	data = make([]byte, 100)
-	n, ok := s.get(40, []byte{1, 2, 0}, data)
+	n, ok := s.getAndApply(40, []byte{1, 2, 0}, data)
 	assert.Equal(t, true, ok)
 	assert.Equal(t, []byte{101, 102, 103, 100}, data[:n])
 
-	_, ok = s.get(41, []byte{1, 2, 1}, data)
+	_, ok = s.getAndApply(41, []byte{1, 2, 1}, data)
 	assert.Equal(t, false, ok)
 
-	_, ok = s.get(42, []byte{1, 2, 2}, data)
+	_, ok = s.getAndApply(42, []byte{1, 2, 2}, data)
 	assert.Equal(t, true, ok)
 
-	assert.Equal(t, s.totalAccessTime, s.getSumTotalAccessTime())
+	assert.Equal(t, s.totalAge, s.getSumTotalAge())
 }
 
 func TestSegment_Put_Evacuate_Reach_Max_Evacuation(t *testing.T) {
@@ -336,27 +349,27 @@ func TestSegment_Put_Evacuate_Reach_Max_Evacuation(t *testing.T) {
 	s.put(51, []byte{1, 2, 11}, []byte{101, 102, 103, 111})
 
 	data := make([]byte, 100)
-	s.get(40, []byte{1, 2, 0}, data)
-	s.get(41, []byte{1, 2, 1}, data)
-	s.get(42, []byte{1, 2, 2}, data)
-	s.get(43, []byte{1, 2, 3}, data)
+	s.getAndApply(40, []byte{1, 2, 0}, data)
+	s.getAndApply(41, []byte{1, 2, 1}, data)
+	s.getAndApply(42, []byte{1, 2, 2}, data)
+	s.getAndApply(43, []byte{1, 2, 3}, data)
 
-	s.get(44, []byte{1, 2, 4}, data)
-	s.get(45, []byte{1, 2, 5}, data)
+	s.getAndApply(44, []byte{1, 2, 4}, data)
+	s.getAndApply(45, []byte{1, 2, 5}, data)
 
 	s.put(52, []byte{1, 2, 12}, []byte{101, 102, 103, 112})
 
 	data = make([]byte, 100)
-	n, ok := s.get(45, []byte{1, 2, 5}, data)
+	n, ok := s.getAndApply(45, []byte{1, 2, 5}, data)
 	assert.Equal(t, false, ok)
 	assert.Equal(t, []byte{}, data[:n])
 
 	data = make([]byte, 100)
-	n, ok = s.get(46, []byte{1, 2, 6}, data)
+	n, ok = s.getAndApply(46, []byte{1, 2, 6}, data)
 	assert.Equal(t, true, ok)
 	assert.Equal(t, []byte{101, 102, 103, 106}, data[:n])
 
-	assert.Equal(t, s.totalAccessTime, s.getSumTotalAccessTime())
+	assert.Equal(t, s.totalAge, s.getSumTotalAge())
 }
 
 func TestSegment_Put_Existing_Check_Total_Access_Time(t *testing.T) {
@@ -366,7 +379,7 @@ func TestSegment_Put_Existing_Check_Total_Access_Time(t *testing.T) {
 	s.put(40, []byte{1, 2, 3}, []byte{101, 102, 103, 104})
 	s.put(40, []byte{1, 2, 3}, []byte{101, 102, 103, 0})
 
-	assert.Equal(t, s.totalAccessTime, s.getSumTotalAccessTime())
+	assert.Equal(t, s.totalAge, s.getSumTotalAge())
 }
 
 func TestSegment_Put_Same_Hash_Diff_Key_Check_Total_Access_Time(t *testing.T) {
@@ -377,7 +390,7 @@ func TestSegment_Put_Same_Hash_Diff_Key_Check_Total_Access_Time(t *testing.T) {
 	s.put(40, []byte{1, 2, 4}, []byte{101, 102, 103, 0})
 
 	assert.Equal(t, uint64(1), s.getTotal())
-	assert.Equal(t, s.totalAccessTime, s.getSumTotalAccessTime())
+	assert.Equal(t, s.totalAge, s.getSumTotalAge())
 }
 
 func TestSegment_Delete_Simple(t *testing.T) {
@@ -385,16 +398,16 @@ func TestSegment_Delete_Simple(t *testing.T) {
 	s.getNow = monoGetNow(200)
 
 	s.put(40, []byte{1, 2, 3}, []byte{101, 102, 103, 104})
-	affected := s.delete(40, []byte{1, 2, 3})
+	affected := s.delete(40, keySpace{}, []byte{1, 2, 3})
 	assert.Equal(t, true, affected)
 
 	assert.Equal(t, uint64(0), s.getTotal())
 
 	data := make([]byte, 100)
-	n, ok := s.get(40, []byte{1, 2, 3}, data)
+	n, ok := s.getAndApply(40, []byte{1, 2, 3}, data)
 	assert.Equal(t, false, ok)
 	assert.Equal(t, 0, n)
-	assert.Equal(t, s.totalAccessTime, s.getSumTotalAccessTime())
+	assert.Equal(t, s.totalAge, s.getSumTotalAge())
 }
 
 func TestSegment_Delete_Different_Hash(t *testing.T) {
@@ -404,16 +417,16 @@ func TestSegment_Delete_Different_Hash(t *testing.T) {
 	s.put(40, []byte{1, 2, 3}, []byte{101, 102, 103, 104})
 	s.put(41, []byte{1, 2, 4}, []byte{101, 102, 103, 105})
 
-	affected := s.delete(42, []byte{1, 2, 3})
+	affected := s.delete(42, keySpace{}, []byte{1, 2, 3})
 	assert.Equal(t, false, affected)
 
 	assert.Equal(t, uint64(2), s.getTotal())
 
 	data := make([]byte, 100)
-	n, ok := s.get(40, []byte{1, 2, 3}, data)
+	n, ok := s.getAndApply(40, []byte{1, 2, 3}, data)
 	assert.Equal(t, true, ok)
 	assert.Equal(t, []byte{101, 102, 103, 104}, data[:n])
-	assert.Equal(t, s.totalAccessTime, s.getSumTotalAccessTime())
+	assert.Equal(t, s.totalAge, s.getSumTotalAge())
 }
 
 func TestSegment_Delete_Same_Hash_Diff_Key(t *testing.T) {
@@ -423,16 +436,16 @@ func TestSegment_Delete_Same_Hash_Diff_Key(t *testing.T) {
 	s.put(40, []byte{1, 2, 3}, []byte{101, 102, 103, 104})
 	s.put(41, []byte{1, 2, 4}, []byte{101, 102, 103, 105})
 
-	affected := s.delete(40, []byte{1, 2, 5})
+	affected := s.delete(40, keySpace{}, []byte{1, 2, 5})
 	assert.Equal(t, false, affected)
 
 	assert.Equal(t, uint64(2), s.getTotal())
 
 	data := make([]byte, 100)
-	n, ok := s.get(40, []byte{1, 2, 3}, data)
+	n, ok := s.getAndApply(40, []byte{1, 2, 3}, data)
 	assert.Equal(t, true, ok)
 	assert.Equal(t, []byte{101, 102, 103, 104}, data[:n])
-	assert.Equal(t, s.totalAccessTime, s.getSumTotalAccessTime())
+	assert.Equal(t, s.totalAge, s.getSumTotalAge())
 }
 
 func TestSegment_Delete_Already_Deleted(t *testing.T) {
@@ -442,18 +455,18 @@ func TestSegment_Delete_Already_Deleted(t *testing.T) {
 	s.put(40, []byte{1, 2, 3}, []byte{101, 102, 103, 104})
 	s.put(41, []byte{1, 2, 4}, []byte{101, 102, 103, 105})
 
-	affected := s.delete(40, []byte{1, 2, 3})
+	affected := s.delete(40, keySpace{}, []byte{1, 2, 3})
 	assert.Equal(t, true, affected)
 
 	assert.Equal(t, uint64(1), s.getTotal())
 
-	affected = s.delete(40, []byte{1, 2, 3})
+	affected = s.delete(40, keySpace{}, []byte{1, 2, 3})
 	assert.Equal(t, false, affected)
 
 	assert.Equal(t, uint64(1), s.getTotal())
 
 	data := make([]byte, 100)
-	n, ok := s.get(40, []byte{1, 2, 3}, data)
+	n, ok := s.getAndApply(40, []byte{1, 2, 3}, data)
 	assert.Equal(t, false, ok)
 	assert.Equal(t, 0, n)
 }
@@ -470,8 +483,8 @@ func TestSegment_Put_Evacuate_After_Deleted(t *testing.T) {
 	s.put(44, []byte{1, 2, 4}, []byte{101, 102, 103, 104})
 
 	data := make([]byte, 100)
-	s.get(40, []byte{1, 2, 0}, data)
-	s.delete(40, []byte{1, 2, 0})
+	s.getAndApply(40, []byte{1, 2, 0}, data)
+	s.delete(40, keySpace{}, []byte{1, 2, 0})
 
 	assert.Equal(t, uint64(4), s.getTotal())
 	assert.Equal(t, 4, len(s.kv))
@@ -482,7 +495,7 @@ func TestSegment_Put_Evacuate_After_Deleted(t *testing.T) {
 	assert.Equal(t, 5, len(s.kv))
 
 	data = make([]byte, 100)
-	n, ok := s.get(41, []byte{1, 2, 1}, data)
+	n, ok := s.getAndApply(41, []byte{1, 2, 1}, data)
 	assert.Equal(t, true, ok)
 	assert.Equal(t, []byte{101, 102, 103, 101}, data[:n])
 }
@@ -509,31 +522,31 @@ func TestSegment_Put_Evacuate_Need_Reset_ConsecutiveEvacuation(t *testing.T) {
 	s.put(51, []byte{1, 2, 11}, []byte{101, 102, 103, 111})
 
 	data := make([]byte, 100)
-	s.get(40, []byte{1, 2, 0}, data)
-	s.get(41, []byte{1, 2, 1}, data)
-	s.get(42, []byte{1, 2, 2}, data)
-	s.get(43, []byte{1, 2, 3}, data)
+	s.getAndApply(40, []byte{1, 2, 0}, data)
+	s.getAndApply(41, []byte{1, 2, 1}, data)
+	s.getAndApply(42, []byte{1, 2, 2}, data)
+	s.getAndApply(43, []byte{1, 2, 3}, data)
 
-	s.get(44, []byte{1, 2, 4}, data)
+	s.getAndApply(44, []byte{1, 2, 4}, data)
 
 	s.put(60, []byte{5, 5, 5}, []byte{10, 10, 10, 10, 10, 10})
 
-	_, ok := s.get(40, []byte{1, 2, 0}, data)
+	_, ok := s.getAndApply(40, []byte{1, 2, 0}, data)
 	assert.Equal(t, true, ok)
-	_, ok = s.get(41, []byte{1, 2, 1}, data)
+	_, ok = s.getAndApply(41, []byte{1, 2, 1}, data)
 	assert.Equal(t, true, ok)
-	_, ok = s.get(42, []byte{1, 2, 2}, data)
+	_, ok = s.getAndApply(42, []byte{1, 2, 2}, data)
 	assert.Equal(t, false, ok)
-	_, ok = s.get(43, []byte{1, 2, 3}, data)
+	_, ok = s.getAndApply(43, []byte{1, 2, 3}, data)
 	assert.Equal(t, true, ok)
-	_, ok = s.get(44, []byte{1, 2, 4}, data)
+	_, ok = s.getAndApply(44, []byte{1, 2, 4}, data)
 	assert.Equal(t, true, ok)
-	_, ok = s.get(45, []byte{1, 2, 5}, data)
+	_, ok = s.getAndApply(45, []byte{1, 2, 5}, data)
 	assert.Equal(t, false, ok)
 
 	assert.Equal(t, 11, len(s.kv))
 	assert.Equal(t, uint64(11), s.getTotal())
-	assert.Equal(t, s.totalAccessTime, s.getSumTotalAccessTime())
+	assert.Equal(t, s.totalAge, s.getSumTotalAge())
 }
 
 func fillRandom(data []byte) {
@@ -590,7 +603,7 @@ func TestSegment_Stress_Testing(t *testing.T) {
 		for k := 0; k < touchCount; k++ {
 			index := rand.Intn(keyCount)
 			e := keyUsedList[index]
-			s.get(uint32(e.hash), e.key, placeholder)
+			s.getAndApply(uint32(e.hash), e.key, placeholder)
 		}
 
 		index := rand.Intn(keyCount)
@@ -603,13 +616,13 @@ func TestSegment_Stress_Testing(t *testing.T) {
 		if putOrDelete < 2 {
 			s.put(uint32(e.hash), e.key, value)
 		} else {
-			s.delete(uint32(e.hash), e.key)
+			s.delete(uint32(e.hash), keySpace{}, e.key)
 		}
 	}
 
 	for _, e := range keyUsedList {
 		data := make([]byte, 100)
-		n, ok := s.get(uint32(e.hash), e.key, data)
+		n, ok := s.getAndApply(uint32(e.hash), e.key, data)
 		if ok {
 			value := data[:n]
 			h := memhash.Hash(appendKeyValue(e.key, value))
@@ -619,7 +632,7 @@ func TestSegment_Stress_Testing(t *testing.T) {
 
 	assert.Equal(t, uint64((touchCount*3+1)*keyCount), s.getAccessCount())
 	assert.Equal(t, len(s.kv), int(s.getTotal()))
-	assert.Equal(t, s.totalAccessTime, s.getSumTotalAccessTime())
+	assert.Equal(t, s.totalAge, s.getSumTotalAge())
 
 	fmt.Println(s.getHitCount())
 	fmt.Println(s.getAccessCount())
@@ -660,7 +673,7 @@ func BenchmarkSegmentPut(b *testing.B) {
 		for i := 0; i < actionCount; i++ {
 			*num++
 			hash := memhash.Hash(key)
-			_, ok := s.get(uint32(hash), key, data)
+			_, ok := s.getAndApply(uint32(hash), key, data)
 			if ok {
 				hitCount++
 			}
@@ -668,3 +681,338 @@ func BenchmarkSegmentPut(b *testing.B) {
 	}
 	fmt.Println(hitCount, b.N)
 }
+
+func TestSegment_Put_With_Expire(t *testing.T) {
+	s := newSegment()
+	now := uint32(100)
+	s.getExpireNow = func() uint32 { return now }
+
+	s.putWithParams(40, []byte{1, 2, 3}, []byte{10, 11, 12, 13}, putParams{expireAt: 110})
+	assert.Equal(t, uint32(110), s.getHeader(40).expireAt)
+
+	data := make([]byte, 100)
+	now = 109
+	n, ok := s.getAndApply(40, []byte{1, 2, 3}, data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, []byte{10, 11, 12, 13}, data[:n])
+
+	now = 110
+	n, ok = s.getAndApply(40, []byte{1, 2, 3}, data)
+	assert.Equal(t, false, ok)
+	assert.Equal(t, 0, n)
+
+	assert.Equal(t, uint64(0), s.getTotal())
+	assert.Equal(t, 0, len(s.kv))
+	assert.Equal(t, uint64(1), s.getExpiredCount())
+	assert.Equal(t, true, s.getHeaderAtOffset(0).deleted)
+	assert.Equal(t, s.totalAge, s.getSumTotalAge())
+}
+
+func TestSegment_Put_Existing_Reset_Expire(t *testing.T) {
+	s := newSegment()
+	now := uint32(100)
+	s.getExpireNow = func() uint32 { return now }
+
+	s.putWithParams(40, []byte{1, 2, 3}, []byte{10, 11, 12, 13}, putParams{expireAt: 110})
+	s.put(40, []byte{1, 2, 3}, []byte{20, 21, 22, 23})
+	assert.Equal(t, uint32(0), s.getHeader(40).expireAt)
+
+	now = 200
+	_, ok := s.getAndApply(40, []byte{1, 2, 3}, nil)
+	assert.Equal(t, true, ok)
+}
+
+func TestSegment_Put_Header_Trailers(t *testing.T) {
+	s := newSegment()
+	s.getNow = monoGetNow(100)
+
+	s.putWithParams(40, []byte{1, 2, 3}, []byte{10, 11, 12, 13}, putParams{
+		expireAt: 110, staleAt: 105, namespace: 2, keyID: 3,
+	})
+	assert.Equal(t, &entryHeader{
+		hash:       40,
+		accessTime: 101,
+		keyLen:     3,
+		flags:      entryFlagExpiry | entryFlagExtended,
+		valLen:     4,
+		valCap:     5,
+		expireAt:   110,
+		staleAt:    105,
+		keyID:      3,
+		namespace:  2,
+	}, s.getHeader(40))
+	assert.Equal(t, maxEntryHeaderSize+3+5, s.rb.size)
+
+	// only the extended trailer
+	s.putWithParams(41, []byte{1, 2, 4}, []byte{10, 11, 12, 13}, putParams{namespace: 2})
+	assert.Equal(t, &entryHeader{
+		hash:       41,
+		accessTime: 102,
+		keyLen:     3,
+		flags:      entryFlagExtended,
+		valLen:     4,
+		valCap:     5,
+		namespace:  2,
+	}, s.getHeader(41))
+	assert.Equal(t, entryHeaderSize+entryExtendedSize+8, s.getHeader(41).entrySize())
+
+	data := make([]byte, 10)
+	n, result, _ := s.getShared(41, keySpace{namespace: 2}, []byte{1, 2, 4}, data)
+	assert.Equal(t, LookupHit, result)
+	assert.Equal(t, []byte{10, 11, 12, 13}, data[:n])
+}
+
+func TestSegment_Put_Existing_Different_Trailers(t *testing.T) {
+	s := newSegment()
+	now := uint32(100)
+	s.getExpireNow = func() uint32 { return now }
+
+	s.put(40, []byte{1, 2, 3}, []byte{10, 11, 12, 13})
+	s.putWithParams(40, []byte{1, 2, 3}, []byte{20, 21, 22, 23}, putParams{expireAt: 110})
+	assert.Equal(t, uint64(entryHeaderSize+8), s.getDeadBytes())
+	assert.Equal(t, uint32(110), s.getHeader(40).expireAt)
+
+	data := make([]byte, 10)
+	n, ok := s.getAndApply(40, []byte{1, 2, 3}, data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, []byte{20, 21, 22, 23}, data[:n])
+
+	now = 110
+	_, ok = s.getAndApply(40, []byte{1, 2, 3}, data)
+	assert.Equal(t, false, ok)
+}
+
+func TestSegment_Delete_Expired(t *testing.T) {
+	s := newSegment()
+	now := uint32(100)
+	s.getExpireNow = func() uint32 { return now }
+
+	s.putWithParams(40, []byte{1, 2, 3}, []byte{10, 11, 12, 13}, putParams{expireAt: 110})
+
+	now = 120
+	affected := s.delete(40, keySpace{}, []byte{1, 2, 3})
+	assert.Equal(t, false, affected)
+	assert.Equal(t, uint64(0), s.getTotal())
+	assert.Equal(t, uint64(1), s.getExpiredCount())
+}
+
+func TestSegment_Evacuate_Drop_Expired_Recently_Used(t *testing.T) {
+	const entrySize = entryHeaderSize + 8
+	s := newSegmentSize(entrySize*3 + entryExpirySize) // one entry with expiry
+	s.getNow = monoGetNow(0)
+	now := uint32(100)
+	s.getExpireNow = func() uint32 { return now }
+
+	s.putWithParams(40, []byte{1, 2, 0}, []byte{101, 102, 103, 100}, putParams{expireAt: 110})
+	s.put(41, []byte{1, 2, 1}, []byte{101, 102, 103, 101})
+	s.put(42, []byte{1, 2, 2}, []byte{101, 102, 103, 102})
+
+	data := make([]byte, 100)
+	s.getAndApply(40, []byte{1, 2, 0}, data)
+	s.getAndApply(40, []byte{1, 2, 0}, data)
+
+	now = 110
+	s.put(43, []byte{1, 2, 3}, []byte{101, 102, 103, 103})
+
+	_, ok := s.getAndApply(41, []byte{1, 2, 1}, data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, uint64(3), s.getTotal())
+	assert.Equal(t, uint64(1), s.getExpiredCount())
+	assert.Equal(t, s.totalAge, s.getSumTotalAge())
+}
+
+func TestSegment_Sweep_Expired(t *testing.T) {
+	s := newSegment()
+	now := uint32(100)
+	s.getExpireNow = func() uint32 { return now }
+
+	for i := 0; i < 5; i++ {
+		expireAt := uint32(0)
+		if i%2 == 0 {
+			expireAt = 110
+		}
+		s.putWithParams(uint32(40+i), []byte{1, 2, uint8(i)}, []byte{101, 102, 103}, putParams{expireAt: expireAt})
+	}
+
+	deleted, done := s.sweepExpired(10)
+	assert.Equal(t, 0, deleted)
+	assert.Equal(t, true, done)
+
+	now = 110
+
+	deleted, done = s.sweepExpired(2)
+	assert.Equal(t, 1, deleted)
+	assert.Equal(t, false, done)
+
+	deleted, done = s.sweepExpired(2)
+	assert.Equal(t, 1, deleted)
+	assert.Equal(t, false, done)
+
+	deleted, done = s.sweepExpired(2)
+	assert.Equal(t, 1, deleted)
+	assert.Equal(t, true, done)
+
+	assert.Equal(t, uint64(2), s.getTotal())
+	assert.Equal(t, 2, len(s.kv))
+	assert.Equal(t, uint64(3), s.getExpiredCount())
+	assert.Equal(t, s.totalAge, s.getSumTotalAge())
+
+	deleted, done = s.sweepExpired(10)
+	assert.Equal(t, 0, deleted)
+	assert.Equal(t, true, done)
+}
+
+func TestSegment_Sweep_Expired_Position_Before_Begin(t *testing.T) {
+	const entrySize = entryHeaderSize + 8
+	s := newSegmentSize(entrySize*3 + entryExpirySize) // one entry with expiry
+	s.getNow = monoGetNow(0)
+	now := uint32(100)
+	s.getExpireNow = func() uint32 { return now }
+
+	s.put(40, []byte{1, 2, 0}, []byte{101, 102, 103, 100})
+	s.put(41, []byte{1, 2, 1}, []byte{101, 102, 103, 101})
+	s.put(42, []byte{1, 2, 2}, []byte{101, 102, 103, 102})
+
+	deleted, done := s.sweepExpired(1)
+	assert.Equal(t, 0, deleted)
+	assert.Equal(t, false, done)
+	assert.Equal(t, uint64(entrySize), s.sweepPos)
+
+	s.put(43, []byte{1, 2, 3}, []byte{101, 102, 103, 103})
+	s.putWithParams(44, []byte{1, 2, 4}, []byte{101, 102, 103, 104}, putParams{expireAt: 105})
+
+	now = 105
+	deleted, done = s.sweepExpired(10)
+	assert.Equal(t, 1, deleted)
+	assert.Equal(t, true, done)
+	assert.Equal(t, uint64(2), s.getTotal())
+}
+
+func (s *segment) getSumDeadBytes() uint64 {
+	var headerData [maxEntryHeaderSize]byte
+	dead := uint64(0)
+	pos := s.rb.getBeginPos()
+	endPos := pos + uint64(s.rb.size)
+	for pos < endPos {
+		s.rb.readHeader(&headerData, s.rb.posToOffset(pos))
+		header := (*entryHeader)(unsafe.Pointer(&headerData[0]))
+		if header.deleted {
+			dead += uint64(header.entrySize())
+		}
+		pos += uint64(header.entrySize())
+	}
+	return dead
+}
+
+func TestSegment_Dead_Bytes(t *testing.T) {
+	const entrySize = entryHeaderSize + 8
+	s := newSegmentSize(entrySize * 8)
+	s.getNow = monoGetNow(0)
+
+	s.put(40, []byte{1, 2, 0}, []byte{101, 102, 103, 100})
+	s.put(41, []byte{1, 2, 1}, []byte{101, 102, 103, 101})
+	s.put(42, []byte{1, 2, 2}, []byte{101, 102, 103, 102})
+	assert.Equal(t, uint64(0), s.getDeadBytes())
+
+	s.delete(41, keySpace{}, []byte{1, 2, 1})
+	assert.Equal(t, uint64(entrySize), s.getDeadBytes())
+	assert.Equal(t, 0.125, s.getDeadRatio())
+
+	s.put(42, []byte{1, 2, 2}, []byte{101, 102, 103, 102, 1, 2, 3})
+	assert.Equal(t, uint64(2*entrySize), s.getDeadBytes())
+	assert.Equal(t, s.getSumDeadBytes(), s.getDeadBytes())
+
+	for i := 0; i < 5; i++ {
+		s.put(uint32(50+i), []byte{1, 3, uint8(i)}, []byte{101, 102, 103, 103})
+	}
+	assert.Equal(t, s.getSumDeadBytes(), s.getDeadBytes())
+}
+
+func TestSegment_Compact(t *testing.T) {
+	const entrySize = entryHeaderSize + 8
+	s := newSegmentSize(entrySize * 5)
+	s.getNow = monoGetNow(0)
+
+	for i := 0; i < 5; i++ {
+		s.put(uint32(40+i), []byte{1, 2, uint8(i)}, []byte{101, 102, 103, uint8(100 + i)})
+	}
+	s.delete(41, keySpace{}, []byte{1, 2, 1})
+	s.delete(43, keySpace{}, []byte{1, 2, 3})
+
+	assert.Equal(t, false, s.startCompaction(0.5))
+	assert.Equal(t, true, s.startCompaction(0.4))
+	assert.Equal(t, true, s.startCompaction(0.9))
+
+	assert.Equal(t, false, s.compactStep(2))
+	assert.Equal(t, uint64(entrySize), s.getDeadBytes())
+
+	assert.Equal(t, true, s.compactStep(10))
+	assert.Equal(t, uint64(0), s.getDeadBytes())
+	assert.Equal(t, 2*entrySize, s.rb.getAvailable())
+	assert.Equal(t, false, s.startCompaction(0.01))
+
+	data := make([]byte, 100)
+	for _, i := range []int{0, 2, 4} {
+		n, ok := s.getAndApply(uint32(40+i), []byte{1, 2, uint8(i)}, data)
+		assert.Equal(t, true, ok)
+		assert.Equal(t, []byte{101, 102, 103, uint8(100 + i)}, data[:n])
+	}
+	assert.Equal(t, uint64(3), s.getTotal())
+	assert.Equal(t, s.totalAge, s.getSumTotalAge())
+}
+
+func TestSegment_Compact_Remove_Expired(t *testing.T) {
+	s := newSegment()
+	now := uint32(100)
+	s.getExpireNow = func() uint32 { return now }
+
+	s.putWithParams(40, []byte{1, 2, 0}, []byte{101}, putParams{expireAt: 110})
+	s.put(41, []byte{1, 2, 1}, []byte{102})
+	s.delete(41, keySpace{}, []byte{1, 2, 1})
+
+	now = 110
+	assert.Equal(t, true, s.startCompaction(0.01))
+	assert.Equal(t, true, s.compactStep(10))
+
+	assert.Equal(t, uint64(0), s.getTotal())
+	assert.Equal(t, 0, len(s.kv))
+	assert.Equal(t, uint64(1), s.getExpiredCount())
+	assert.Equal(t, 1024, s.rb.getAvailable())
+}
+
+func TestSegment_Compact_Stress_Testing(t *testing.T) {
+	s := newSegmentSize(23456)
+	s.getNow = monoGetNow(0)
+
+	values := map[string][]byte{}
+	placeholder := make([]byte, 100)
+
+	for i := 0; i < 20000; i++ {
+		key := []byte(fmt.Sprint("key:", rand.Intn(800)))
+		hash := uint32(memhash.Hash(key))
+
+		if rand.Intn(3) == 0 {
+			s.delete(hash, keySpace{}, key)
+			delete(values, string(key))
+		} else {
+			value := make([]byte, 1+rand.Intn(60))
+			fillRandom(value)
+			s.put(hash, key, value)
+			values[string(key)] = value
+		}
+
+		if i%7 == 0 && s.startCompaction(0.1) {
+			s.compactStep(1 + rand.Intn(10))
+		}
+	}
+
+	for key, value := range values {
+		n, ok := s.getAndApply(uint32(memhash.Hash([]byte(key))), []byte(key), placeholder)
+		if ok {
+			assert.Equal(t, value, placeholder[:n])
+		}
+	}
+	assert.Equal(t, len(s.kv), int(s.getTotal()))
+	assert.Equal(t, s.getSumDeadBytes(), s.getDeadBytes())
+	assert.Equal(t, s.totalAge, s.getSumTotalAge())
+}
@@ -0,0 +1,221 @@
+package bigcache
+
+import (
+	"errors"
+	"io"
+	"sync/atomic"
+	"unsafe"
+)
+
+// ErrValueEvicted is returned by the reader of OpenReader when a chunk of the value is evicted
+// before being read
+var ErrValueEvicted = errors.New("bigcache: value evicted while reading")
+
+// ErrNegativeSize is returned by PutFrom when the size is negative
+var ErrNegativeSize = errors.New("bigcache: negative value size")
+
+// PutFrom is the same as Put but reads the value of size bytes from r directly into the ring buffers,
+// values bigger than the chunk size are read one chunk at a time. The lock of a segment is held while
+// reading into it, so r should not block for long (e.g. a file or a buffer).
+// If r returns an error the value is not put, the previous value of the key is kept and the error is returned.
+// With encryption enabled the value is read into memory first and put with Put.
+// Returns ErrNegativeSize without reading if size < 0
+func (c *Cache) PutFrom(key []byte, r io.Reader, size int) error {
+	if size < 0 {
+		return ErrNegativeSize
+	}
+
+	seg, hash := c.getSegment(key)
+	if c.keyring != nil {
+		value := make([]byte, size)
+		if _, err := io.ReadFull(r, value); err != nil {
+			return err
+		}
+		c.putEntry(seg, hash, key, value, putParams{})
+		return nil
+	}
+	if size > c.getChunkSize() {
+		return c.putChunks(seg, hash, key, nil, chunkSource{r: r, size: size}, putParams{})
+	}
+
+	seg.mu.Lock()
+	replaced, err := seg.putFrom(uint32(hash), key, r, size, putParams{})
+	seg.mu.Unlock()
+
+	c.deleteChunks(keySpace{}, replaced, replaced.chunkCount())
+	return err
+}
+
+// putFrom returns the manifest of the replaced chunked value the same as putWithParams.
+// The value of an existing key is read into a new entry, the existing entry is kept if r fails
+func (s *segment) putFrom(hash uint32, key []byte, r io.Reader, size int, params putParams) (chunkManifest, error) {
+	if _, existed := s.kv[hash]; existed {
+		return s.replaceFrom(hash, key, r, size, params)
+	}
+
+	offset, replaced, ok := s.reserve(hash, key, size, params)
+	if !ok {
+		// not admitted, the value is still consumed for the next chunks
+		_, err := io.CopyN(io.Discard, r, int64(size))
+		return replaced, err
+	}
+
+	if err := s.rb.readFrom(r, offset, size); err != nil {
+		var headerData [maxEntryHeaderSize]byte
+		entryOffset := s.kv[hash]
+		s.rb.readHeader(&headerData, entryOffset)
+		header := (*entryHeader)(unsafe.Pointer(&headerData[0]))
+		s.deleteEntry(header, &headerData, entryOffset)
+		return replaced, err
+	}
+	s.writeChecksum(hash)
+	return replaced, nil
+}
+
+// replaceFrom appends a new entry for the key and reads the value into it, then replaces the entry of the hash
+func (s *segment) replaceFrom(hash uint32, key []byte, r io.Reader, size int, params putParams) (chunkManifest, error) {
+	s.drainReads()
+	now := s.updateAccessNow()
+	params.epoch = s.invalidator.getEpoch()
+
+	var headerData [maxEntryHeaderSize]byte
+	header := (*entryHeader)(unsafe.Pointer(&headerData[0]))
+	offset := s.appendEntry(&headerData, hash, key, size, params, now)
+
+	if err := s.rb.readFrom(r, header.valueOffset(offset), size); err != nil {
+		header.deleted = true
+		s.rb.writeHeader(&headerData, offset)
+		atomic.AddUint64(&s.deadBytes, uint64(header.entrySize()))
+		return chunkManifest{}, err
+	}
+
+	// the existing entry may have been evacuated or evicted while appending
+	var replaced chunkManifest
+	if oldOffset, ok := s.kv[hash]; ok {
+		var oldHeaderData [maxEntryHeaderSize]byte
+		oldHeader := (*entryHeader)(unsafe.Pointer(&oldHeaderData[0]))
+		s.rb.readHeader(&oldHeaderData, oldOffset)
+		s.totalAge -= s.getAge(oldHeader.accessTime)
+		if s.keyEqual(oldHeader, oldOffset, params.keySpace(), key) {
+			replaced = s.readChunkManifest(oldHeader, oldOffset)
+		}
+		s.markReplaced(oldHeader, &oldHeaderData, oldOffset)
+	} else {
+		atomic.AddUint64(&s.total, 1)
+	}
+
+	s.kv[hash] = offset
+	s.accountEntry(header, header.entrySize())
+	s.writeChecksum(hash)
+	return replaced, nil
+}
+
+// OpenReader returns a reader of the value of the key, the value is not copied as a whole: a value
+// smaller than the chunk size is copied when opening, a chunked value is copied one chunk at a time when reading.
+// The reader returns ErrValueEvicted if a chunk is evicted before being read.
+// A compressed or encrypted value is decoded as a whole when opening
+func (c *Cache) OpenReader(key []byte) (io.ReadCloser, bool) {
+	seg, hash := c.getSegment(key)
+
+	value := make([]byte, defaultTypedBufferSize)
+	for {
+		n, result := seg.getWithReadLock(uint32(hash), keySpace{}, key, value)
+		chunked := result&lookupChunked != 0
+		if !(result &^ lookupFlagMask).IsFound() {
+			return nil, false
+		}
+		if n > len(value) {
+			value = make([]byte, n)
+			continue
+		}
+		if result&lookupEncodingMask != 0 {
+			return c.openDecodedReader(seg, hash, key)
+		}
+
+		if !chunked {
+			return &valueReader{data: value[:n]}, true
+		}
+		return &valueReader{
+			cache:    c,
+			manifest: decodeChunkManifest(value[:n]),
+		}, true
+	}
+}
+
+// openDecodedReader decrypts and decompresses the whole value when opening
+func (c *Cache) openDecodedReader(seg *segment, hash uint64, key []byte) (io.ReadCloser, bool) {
+	value := make([]byte, defaultTypedBufferSize)
+	for {
+		n, result := c.lookupEntry(seg, hash, keySpace{}, key, value)
+		if !result.IsFound() {
+			return nil, false
+		}
+		if n > len(value) {
+			value = make([]byte, n)
+			continue
+		}
+		return &valueReader{data: value[:n]}, true
+	}
+}
+
+type valueReader struct {
+	cache    *Cache
+	manifest chunkManifest // zero for values that are not chunked
+
+	nextChunk int
+	buf       []byte
+	data      []byte // the data of the current chunk not yet read
+	closed    bool
+}
+
+func (r *valueReader) Read(p []byte) (int, error) {
+	if r.closed {
+		return 0, io.ErrClosedPipe
+	}
+
+	for len(r.data) == 0 {
+		if r.manifest.totalLen == 0 || r.nextChunk >= r.manifest.chunkCount() {
+			return 0, io.EOF
+		}
+		if err := r.readChunk(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func (r *valueReader) readChunk() error {
+	begin := uint64(r.nextChunk) * uint64(r.manifest.chunkSize)
+	end := begin + uint64(r.manifest.chunkSize)
+	if end > r.manifest.totalLen {
+		end = r.manifest.totalLen
+	}
+	size := int(end - begin)
+
+	if r.buf == nil {
+		r.buf = make([]byte, r.manifest.chunkSize)
+	}
+
+	chunkKey := r.manifest.chunkKey(r.nextChunk)
+	chunkSpace := keySpace{}.chunkSpace()
+	seg, hash := r.cache.getSpaceSegment(chunkSpace, chunkKey[:])
+	n, result := seg.getWithReadLock(uint32(hash), chunkSpace, chunkKey[:], r.buf[:size])
+	if !result.IsFound() || n != size {
+		return ErrValueEvicted
+	}
+
+	r.data = r.buf[:size]
+	r.nextChunk++
+	return nil
+}
+
+// Close ...
+func (r *valueReader) Close() error {
+	r.closed = true
+	r.buf = nil
+	r.data = nil
+	return nil
+}
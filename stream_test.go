@@ -0,0 +1,182 @@
+package bigcache
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+func TestCache_Open_Reader(t *testing.T) {
+	c := New(4, 1<<14)
+	c.Put([]byte("key01"), []byte("value01"))
+
+	r, ok := c.OpenReader([]byte("key01"))
+	assert.Equal(t, true, ok)
+
+	data, err := io.ReadAll(r)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "value01", string(data))
+	assert.Equal(t, nil, r.Close())
+
+	_, err = r.Read(data)
+	assert.Equal(t, io.ErrClosedPipe, err)
+
+	_, ok = c.OpenReader([]byte("key02"))
+	assert.Equal(t, false, ok)
+}
+
+func TestCache_Open_Reader_Chunked(t *testing.T) {
+	c := New(4, 1<<14)
+	value := randomBytes(10000)
+	c.Put([]byte("page"), value)
+
+	r, ok := c.OpenReader([]byte("page"))
+	assert.Equal(t, true, ok)
+
+	data, err := io.ReadAll(iotest.OneByteReader(r))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, value, data)
+	assert.Equal(t, nil, r.Close())
+}
+
+func TestCache_Open_Reader_Chunk_Evicted(t *testing.T) {
+	c := New(4, 1<<14)
+	c.Put([]byte("page"), randomBytes(10000))
+
+	r, ok := c.OpenReader([]byte("page"))
+	assert.Equal(t, true, ok)
+
+	manifest := chunkManifest{totalLen: 10000, generation: 1, chunkSize: 1 << 12}
+	assert.Equal(t, true, deleteTestChunk(c, manifest, 1))
+
+	data := make([]byte, 1<<12)
+	n, err := io.ReadFull(r, data)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1<<12, n)
+
+	_, err = r.Read(data)
+	assert.Equal(t, ErrValueEvicted, err)
+}
+
+func TestCache_Put_From(t *testing.T) {
+	c := New(4, 1<<14)
+
+	err := c.PutFrom([]byte("key01"), bytes.NewReader([]byte("value01")), 7)
+	assert.Equal(t, nil, err)
+
+	data := make([]byte, 20)
+	n, ok := c.Get([]byte("key01"), data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "value01", string(data[:n]))
+
+	// replace in place
+	err = c.PutFrom([]byte("key01"), bytes.NewReader([]byte("value1")), 6)
+	assert.Equal(t, nil, err)
+	n, ok = c.Get([]byte("key01"), data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "value1", string(data[:n]))
+}
+
+func TestCache_Put_From_Chunked(t *testing.T) {
+	c := New(4, 1<<14)
+	value := randomBytes(10000)
+
+	err := c.PutFrom([]byte("page"), iotest.HalfReader(bytes.NewReader(value)), len(value))
+	assert.Equal(t, nil, err)
+
+	data := make([]byte, 10000)
+	n, ok := c.Get([]byte("page"), data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, value, data[:n])
+}
+
+func TestCache_Put_From_Error(t *testing.T) {
+	c := New(4, 1<<14)
+	c.Put([]byte("key01"), []byte("value01"))
+
+	err := c.PutFrom([]byte("key02"), iotest.ErrReader(io.ErrClosedPipe), 7)
+	assert.Equal(t, io.ErrClosedPipe, err)
+	_, ok := c.Get([]byte("key02"), nil)
+	assert.Equal(t, false, ok)
+	assert.Equal(t, uint64(1), c.GetTotal())
+
+	err = c.PutFrom([]byte("page"), bytes.NewReader(randomBytes(5000)), 10000)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+	_, ok = c.Get([]byte("page"), nil)
+	assert.Equal(t, false, ok)
+}
+
+func TestCache_Put_From_Error_Existing(t *testing.T) {
+	c := New(4, 1<<14, WithChecksum())
+	c.Put([]byte("key01"), []byte("value01"))
+	value := randomBytes(10000)
+	c.Put([]byte("page"), value)
+
+	// the previous values are kept
+	err := c.PutFrom([]byte("key01"), bytes.NewReader([]byte("val")), 7)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+	err = c.PutFrom([]byte("page"), bytes.NewReader([]byte("val")), 7)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+	err = c.PutFrom([]byte("page"), bytes.NewReader(randomBytes(5000)), 10000)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	data := make([]byte, 10000)
+	n, ok := c.Get([]byte("key01"), data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "value01", string(data[:n]))
+	n, ok = c.Get([]byte("page"), data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, value, data[:n])
+	assert.Equal(t, uint64(1+1+3), c.GetTotal())
+	assert.Equal(t, nil, c.Verify())
+
+	// then replaced by a successful read
+	err = c.PutFrom([]byte("key01"), bytes.NewReader([]byte("value02")), 7)
+	assert.Equal(t, nil, err)
+	err = c.PutFrom([]byte("page"), bytes.NewReader([]byte("small")), 5)
+	assert.Equal(t, nil, err)
+
+	n, ok = c.Get([]byte("key01"), data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "value02", string(data[:n]))
+	n, ok = c.Get([]byte("page"), data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "small", string(data[:n]))
+	assert.Equal(t, uint64(2), c.GetTotal())
+	assert.Equal(t, nil, c.Verify())
+}
+
+func TestCache_Put_From_Negative_Size(t *testing.T) {
+	c := New(4, 1<<14)
+	c.Put([]byte("key01"), []byte("value01"))
+
+	r := bytes.NewReader([]byte("value"))
+	err := c.PutFrom([]byte("key01"), r, -1)
+	assert.Equal(t, ErrNegativeSize, err)
+	assert.Equal(t, 5, r.Len())
+
+	data := make([]byte, 10)
+	n, ok := c.Get([]byte("key01"), data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "value01", string(data[:n]))
+}
+
+func TestSegment_Put_From_Existing_Evicted(t *testing.T) {
+	const entrySize = entryHeaderSize + 8
+	s := newSegmentSize(entrySize * 2)
+
+	s.put(40, []byte{1, 2, 3, 4}, []byte{10, 11, 12, 13})
+	s.put(41, []byte{2, 2, 3, 4}, []byte{10, 11, 12, 13})
+
+	// the existing entry is evicted while appending the new one
+	_, err := s.putFrom(40, []byte{1, 2, 3, 4}, bytes.NewReader([]byte{20, 21, 22, 23}), 4, putParams{})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint64(len(s.kv)), s.getTotal())
+
+	data := make([]byte, 10)
+	n, ok := s.getAndApply(40, []byte{1, 2, 3, 4}, data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, []byte{20, 21, 22, 23}, data[:n])
+}
@@ -0,0 +1,109 @@
+package bigcache
+
+import (
+	"sync"
+)
+
+const defaultTypedBufferSize = 256
+
+// Typed is a type safe wrapper around Cache, keys and values are converted using the codecs
+type Typed[K, V any] struct {
+	cache      *Cache
+	keyCodec   KeyCodec[K]
+	valueCodec ValueCodec[V]
+	pool       *bufferPool
+}
+
+// NewTyped ...
+func NewTyped[K, V any](cache *Cache, keyCodec KeyCodec[K], valueCodec ValueCodec[V]) *Typed[K, V] {
+	return &Typed[K, V]{
+		cache:      cache,
+		keyCodec:   keyCodec,
+		valueCodec: valueCodec,
+		pool:       newBufferPool(defaultTypedBufferSize),
+	}
+}
+
+// Cache returns the underlying cache
+func (t *Typed[K, V]) Cache() *Cache {
+	return t.cache
+}
+
+// Put ...
+func (t *Typed[K, V]) Put(key K, value V) error {
+	buf := t.pool.get()
+	defer t.pool.put(buf)
+
+	data := t.keyCodec.AppendKey((*buf)[:0], key)
+	keyLen := len(data)
+
+	data, err := t.valueCodec.AppendValue(data, value)
+	*buf = data
+	if err != nil {
+		return err
+	}
+
+	t.cache.Put(data[:keyLen], data[keyLen:])
+	return nil
+}
+
+// Get returns ok = false if the key is not found, err != nil if the cached value can not be decoded
+func (t *Typed[K, V]) Get(key K) (value V, ok bool, err error) {
+	keyBuf := t.pool.get()
+	defer t.pool.put(keyBuf)
+
+	valueBuf := t.pool.get()
+	defer t.pool.put(valueBuf)
+
+	*keyBuf = t.keyCodec.AppendKey((*keyBuf)[:0], key)
+
+	data := (*valueBuf)[:cap(*valueBuf)]
+	for {
+		n, found := t.cache.Get(*keyBuf, data)
+		if !found {
+			return value, false, nil
+		}
+		if n <= len(data) {
+			data = data[:n]
+			break
+		}
+		data = make([]byte, n)
+		*valueBuf = data
+	}
+
+	value, err = t.valueCodec.DecodeValue(data)
+	if err != nil {
+		return value, false, err
+	}
+	return value, true, nil
+}
+
+// Delete ...
+func (t *Typed[K, V]) Delete(key K) bool {
+	buf := t.pool.get()
+	defer t.pool.put(buf)
+
+	*buf = t.keyCodec.AppendKey((*buf)[:0], key)
+	return t.cache.Delete(*buf)
+}
+
+type bufferPool struct {
+	pool sync.Pool
+}
+
+func newBufferPool(initSize int) *bufferPool {
+	p := &bufferPool{}
+	p.pool.New = func() interface{} {
+		buf := make([]byte, 0, initSize)
+		return &buf
+	}
+	return p
+}
+
+func (p *bufferPool) get() *[]byte {
+	return p.pool.Get().(*[]byte)
+}
+
+func (p *bufferPool) put(buf *[]byte) {
+	p.pool.Put(buf)
+}
@@ -0,0 +1,165 @@
+package bigcache
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+type typedTestPoint struct {
+	X int32
+	Y int64
+}
+
+type typedTestUser struct {
+	ID   int64
+	Name string
+	Tags []string
+}
+
+func TestTyped_String_String(t *testing.T) {
+	c := NewTyped[string, string](New(4, 1<<16), StringCodec{}, StringCodec{})
+
+	err := c.Put("key01", "value01")
+	assert.Equal(t, nil, err)
+
+	value, ok, err := c.Get("key01")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "value01", value)
+
+	value, ok, err = c.Get("key02")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, ok)
+	assert.Equal(t, "", value)
+
+	assert.Equal(t, true, c.Delete("key01"))
+	assert.Equal(t, false, c.Delete("key01"))
+
+	_, ok, _ = c.Get("key01")
+	assert.Equal(t, false, ok)
+}
+
+func TestTyped_Value_Larger_Than_Buffer(t *testing.T) {
+	c := NewTyped[int, string](New(4, 1<<16), IntegerCodec[int]{}, StringCodec{})
+
+	long := strings.Repeat("abcd", 1000)
+	err := c.Put(10, long)
+	assert.Equal(t, nil, err)
+
+	value, ok, err := c.Get(10)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, long, value)
+
+	value, ok, err = c.Get(10)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, long, value)
+}
+
+func TestTyped_Integer(t *testing.T) {
+	c := NewTyped[uint32, int64](New(4, 1<<16), IntegerCodec[uint32]{}, IntegerCodec[int64]{})
+
+	_ = c.Put(12, -300)
+	_ = c.Put(13, 1<<40)
+
+	value, ok, err := c.Get(12)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, int64(-300), value)
+
+	value, ok, err = c.Get(13)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, int64(1<<40), value)
+}
+
+func TestTyped_Integer_Invalid_Encoding(t *testing.T) {
+	cache := New(4, 1<<16)
+	c := NewTyped[string, int64](cache, StringCodec{}, IntegerCodec[int64]{})
+
+	cache.Put([]byte("key01"), []byte{1, 2, 3})
+
+	_, ok, err := c.Get("key01")
+	assert.Equal(t, ErrInvalidEncoding, err)
+	assert.Equal(t, false, ok)
+}
+
+func TestTyped_Binary(t *testing.T) {
+	c := NewTyped[string, typedTestPoint](New(4, 1<<16), StringCodec{}, BinaryCodec[typedTestPoint]{})
+
+	err := c.Put("p", typedTestPoint{X: 10, Y: -20})
+	assert.Equal(t, nil, err)
+
+	value, ok, err := c.Get("p")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, typedTestPoint{X: 10, Y: -20}, value)
+}
+
+func TestTyped_Binary_Not_Fixed_Size(t *testing.T) {
+	cache := New(4, 1<<16)
+	c := NewTyped[string, typedTestUser](cache, StringCodec{}, BinaryCodec[typedTestUser]{})
+
+	err := c.Put("u", typedTestUser{ID: 1})
+	assert.Error(t, err)
+	assert.Equal(t, uint64(0), cache.GetTotal())
+}
+
+func TestTyped_JSON(t *testing.T) {
+	c := NewTyped[int64, typedTestUser](New(4, 1<<16), IntegerCodec[int64]{}, JSONCodec[typedTestUser]{})
+
+	user := typedTestUser{ID: 11, Name: "user11", Tags: []string{"a", "b"}}
+	err := c.Put(11, user)
+	assert.Equal(t, nil, err)
+
+	value, ok, err := c.Get(11)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, user, value)
+}
+
+func TestTyped_Gob(t *testing.T) {
+	c := NewTyped[int64, typedTestUser](New(4, 1<<16), IntegerCodec[int64]{}, GobCodec[typedTestUser]{})
+
+	user := typedTestUser{ID: 12, Name: "user12", Tags: []string{"c"}}
+	err := c.Put(12, user)
+	assert.Equal(t, nil, err)
+
+	value, ok, err := c.Get(12)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, user, value)
+
+	value, ok, err = c.Get(12)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, user, value)
+}
+
+func TestTyped_Get_Integer_No_Alloc(t *testing.T) {
+	if raceEnabled {
+		t.Skip("sync.Pool drops items randomly with race detector")
+	}
+	c := NewTyped[int64, int64](New(4, 1<<16), IntegerCodec[int64]{}, IntegerCodec[int64]{})
+	_ = c.Put(20, 200)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		_, _, _ = c.Get(20)
+	})
+	assert.Equal(t, float64(0), allocs)
+}
+
+func BenchmarkTypedGet(b *testing.B) {
+	c := NewTyped[int64, int64](New(4, 1<<20), IntegerCodec[int64]{}, IntegerCodec[int64]{})
+	for i := int64(0); i < 1000; i++ {
+		_ = c.Put(i, i*10)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		_, _, _ = c.Get(int64(n % 1000))
+	}
+}
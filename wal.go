@@ -0,0 +1,641 @@
+package bigcache
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	walOpPut    = 1
+	walOpDelete = 2
+)
+
+const (
+	walRecordHeaderSize  = 4 + 4         // crc32c of the payload | payload length
+	walPayloadHeaderSize = 1 + 1 + 8 + 4 // op | key id | expire at (unix seconds) | key length
+	walMaxPayloadSize    = 1 << 30
+)
+
+// walKeyLockCount is the number of locks ordering the writes of the same key, a power of two
+const walKeyLockCount = 64
+
+const (
+	walLogPrefix      = "wal-"
+	walLogSuffix      = ".log"
+	walSnapshotPrefix = "snapshot-"
+	walSnapshotSuffix = ".data"
+)
+
+// ErrWALClosed is returned by the writes after the WAL is closed
+var ErrWALClosed = errors.New("bigcache: wal closed")
+
+var errInvalidWALRecord = errors.New("bigcache: invalid wal record")
+
+// WAL is an append-only write-ahead log making the puts and deletes of a cache durable.
+// Writes through the WAL are appended to the log then applied to the cache, they return after the log is
+// synced: the writes waiting at the same time are synced in batches by one fsync.
+// Snapshots write all entries of the cache into a file and remove the logs before them,
+// the directory contains the last snapshot and the logs written after it.
+//
+// Only the writes through the WAL are logged, but snapshots contain all entries of the cache except the entries
+// of namespaces. Tags, stale times and pinning are not logged. Values are not compressed, they are encrypted
+// with the keyring of the cache if encryption is enabled, so replaying needs the same keys.
+// Expiration times follow the clock of the cache and are logged as wall times
+type WAL struct {
+	dir       string
+	cache     *Cache
+	syncDelay time.Duration
+
+	// the wall time in seconds when the expire clock of the cache was zero
+	wallBase int64
+
+	// a write holds the lock of its key while appending and applying, so that the writes of a key are applied
+	// in the order of the log. Switching the log holds all of them. Before syncMu and mu
+	keyLocks [walKeyLockCount]sync.Mutex
+
+	syncMu sync.Mutex // held while syncing or switching the log, before mu
+
+	mu     sync.Mutex
+	seq    uint64 // the sequence number of the current log
+	file   *os.File
+	writer *bufio.Writer
+	batch  *walBatch
+	record []byte
+	sealed []byte // the encrypted value of the record
+	err    error  // the WAL can not be used after a failed write or sync
+	closed bool
+
+	snapshotMu sync.Mutex
+
+	notify chan struct{}
+	done   chan struct{}
+	wg     sync.WaitGroup
+	worker *worker
+
+	syncCount     uint64
+	snapshotCount uint64
+}
+
+type walBatch struct {
+	done  chan struct{}
+	count int
+	err   error
+}
+
+func newWALBatch() *walBatch {
+	return &walBatch{done: make(chan struct{})}
+}
+
+func (b *walBatch) finish(err error) {
+	b.err = err
+	close(b.done)
+}
+
+// OpenWAL replays the last snapshot and the logs in the directory dir into cache, which should be empty,
+// then starts a new log. syncDelay is the time waited before syncing a batch to gather more writes,
+// 0 means syncing as soon as possible. snapshotInterval is the interval of the snapshots taken after Start,
+// 0 means no periodic snapshots
+func OpenWAL(dir string, cache *Cache, syncDelay time.Duration, snapshotInterval time.Duration) (*WAL, error) {
+	if snapshotInterval < 0 {
+		return nil, errors.New("bigcache: wal snapshot interval must be >= 0")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	w := &WAL{
+		dir:       dir,
+		cache:     cache,
+		syncDelay: syncDelay,
+		wallBase:  time.Now().Unix() - int64(cache.getExpireNow()),
+		batch:     newWALBatch(),
+		notify:    make(chan struct{}, 1),
+		done:      make(chan struct{}),
+	}
+	if snapshotInterval > 0 {
+		w.worker = newWorker("wal snapshot", snapshotInterval, w.snapshotInBackground)
+	}
+
+	lastSeq, err := w.replay()
+	if err != nil {
+		return nil, err
+	}
+	if err := w.openLog(lastSeq + 1); err != nil {
+		return nil, err
+	}
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		w.runSync()
+	}()
+	return w, nil
+}
+
+// Put is the same as Cache.Put but the entry is logged
+func (w *WAL) Put(key []byte, value []byte) error {
+	return w.write(walRecord{op: walOpPut, key: key, value: value}, func() {
+		w.cache.Put(key, value)
+	})
+}
+
+// PutWithTTL is the same as Cache.PutWithTTL but the entry is logged with its expiration time
+func (w *WAL) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
+	if ttl <= 0 {
+		return w.Put(key, value)
+	}
+	record := walRecord{
+		op:       walOpPut,
+		key:      key,
+		value:    value,
+		expireAt: w.unixNow() + int64((ttl+time.Second-1)/time.Second),
+	}
+	return w.write(record, func() {
+		w.cache.PutWithTTL(key, value, ttl)
+	})
+}
+
+// Delete is the same as Cache.Delete but the deletion is logged
+func (w *WAL) Delete(key []byte) (bool, error) {
+	var affected bool
+	err := w.write(walRecord{op: walOpDelete, key: key}, func() {
+		affected = w.cache.Delete(key)
+	})
+	return affected, err
+}
+
+// Start takes snapshots every snapshot interval in a new goroutine until ctx is cancelled or Close is called,
+// does nothing if the snapshot interval is 0
+func (w *WAL) Start(ctx context.Context) {
+	if w.worker != nil {
+		w.worker.start(ctx)
+	}
+}
+
+// Close stops the snapshots, syncs and closes the log
+func (w *WAL) Close() error {
+	if w.worker != nil {
+		w.worker.close()
+	}
+
+	w.mu.Lock()
+	closed := w.closed
+	w.closed = true
+	w.mu.Unlock()
+	if closed {
+		return nil
+	}
+
+	close(w.done)
+	w.wg.Wait()
+
+	w.syncMu.Lock()
+	defer w.syncMu.Unlock()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	err := w.closeLog()
+	w.err = ErrWALClosed
+	return err
+}
+
+// GetSyncCount returns the number of fsyncs of the logs
+func (w *WAL) GetSyncCount() uint64 {
+	return atomic.LoadUint64(&w.syncCount)
+}
+
+// GetSnapshotCount returns the number of snapshots taken
+func (w *WAL) GetSnapshotCount() uint64 {
+	return atomic.LoadUint64(&w.snapshotCount)
+}
+
+// unixNow returns the wall time in seconds following the clock of the cache
+func (w *WAL) unixNow() int64 {
+	return w.wallBase + int64(w.cache.getExpireNow())
+}
+
+// sealRecord encrypts the value of a put with the keyring of the cache into buf, if encryption is enabled
+func (w *WAL) sealRecord(r *walRecord, buf *[]byte) {
+	if w.cache.keyring == nil || r.op != walOpPut {
+		return
+	}
+	r.value, r.keyID = w.cache.encryptValue(r.value, r.key, buf)
+}
+
+func (w *WAL) write(r walRecord, apply func()) error {
+	keyLock := &w.keyLocks[w.cache.hash(r.key)&(walKeyLockCount-1)]
+	keyLock.Lock()
+
+	w.mu.Lock()
+	if w.err != nil {
+		err := w.err
+		w.mu.Unlock()
+		keyLock.Unlock()
+		return err
+	}
+
+	w.sealRecord(&r, &w.sealed)
+	w.record = appendWALRecord(w.record[:0], &r)
+	if _, err := w.writer.Write(w.record); err != nil {
+		w.err = err
+		w.mu.Unlock()
+		keyLock.Unlock()
+		return err
+	}
+	batch := w.batch
+	batch.count++
+	w.mu.Unlock()
+
+	// applied outside of mu, in the order of the log for the same key
+	apply()
+	keyLock.Unlock()
+
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+
+	<-batch.done
+	return batch.err
+}
+
+func (w *WAL) runSync() {
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-w.notify:
+		}
+
+		if w.syncDelay > 0 {
+			select {
+			case <-w.done:
+				return
+			case <-time.After(w.syncDelay):
+			}
+		}
+		w.syncBatch()
+	}
+}
+
+// syncBatch syncs the writes of the current batch
+func (w *WAL) syncBatch() {
+	w.syncMu.Lock()
+	defer w.syncMu.Unlock()
+
+	w.mu.Lock()
+	batch := w.batch
+	if batch.count == 0 {
+		w.mu.Unlock()
+		return
+	}
+	w.batch = newWALBatch()
+	err := w.writer.Flush()
+	w.mu.Unlock()
+
+	// new writes are appended to the log while syncing
+	if err == nil {
+		err = w.file.Sync()
+		atomic.AddUint64(&w.syncCount, 1)
+	}
+	if err != nil {
+		w.mu.Lock()
+		w.err = err
+		w.mu.Unlock()
+	}
+	batch.finish(err)
+}
+
+// openLog creates the log with the sequence number, must be called with mu held or before starting
+func (w *WAL) openLog(seq uint64) error {
+	file, err := os.OpenFile(w.logPath(seq), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	w.seq = seq
+	w.file = file
+	w.writer = bufio.NewWriter(file)
+	return nil
+}
+
+// closeLog syncs and closes the current log then finishes the current batch, must be called with syncMu and mu held
+func (w *WAL) closeLog() error {
+	err := w.writer.Flush()
+	if err == nil {
+		err = w.file.Sync()
+		atomic.AddUint64(&w.syncCount, 1)
+	}
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+
+	w.batch.finish(err)
+	w.batch = newWALBatch()
+	return err
+}
+
+// switchLog closes the current log and starts the next one, returns the sequence number of the new log.
+// The writes appended to the previous logs are applied to the cache when it returns
+func (w *WAL) switchLog() (uint64, error) {
+	for i := range w.keyLocks {
+		w.keyLocks[i].Lock()
+	}
+	defer func() {
+		for i := range w.keyLocks {
+			w.keyLocks[i].Unlock()
+		}
+	}()
+
+	w.syncMu.Lock()
+	defer w.syncMu.Unlock()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.err != nil {
+		return 0, w.err
+	}
+	if err := w.closeLog(); err != nil {
+		w.err = err
+		return 0, err
+	}
+	if err := w.openLog(w.seq + 1); err != nil {
+		w.err = err
+		return 0, err
+	}
+	return w.seq, nil
+}
+
+// Snapshot writes the entries of the cache into a snapshot file then removes the previous logs and snapshot.
+// Writes continue during the snapshot into a new log
+func (w *WAL) Snapshot() error {
+	w.snapshotMu.Lock()
+	defer w.snapshotMu.Unlock()
+
+	seq, err := w.switchLog()
+	if err != nil {
+		return err
+	}
+
+	path := w.snapshotPath(seq)
+	if err := w.writeSnapshot(path); err != nil {
+		_ = os.Remove(path + ".tmp")
+		return err
+	}
+	atomic.AddUint64(&w.snapshotCount, 1)
+
+	// the snapshot contains the writes of the logs before it
+	snapshots, logs, err := w.listFiles()
+	if err != nil {
+		return err
+	}
+	for _, s := range snapshots {
+		if s < seq {
+			_ = os.Remove(w.snapshotPath(s))
+		}
+	}
+	for _, s := range logs {
+		if s < seq {
+			_ = os.Remove(w.logPath(s))
+		}
+	}
+	return nil
+}
+
+func (w *WAL) snapshotInBackground(context.Context) {
+	_ = w.Snapshot()
+}
+
+func (w *WAL) writeSnapshot(path string) error {
+	file, err := os.OpenFile(path+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+
+	var data []byte
+	var sealed []byte
+	err = w.cache.scanEntries(func(key []byte, value []byte, ttl time.Duration) error {
+		r := walRecord{op: walOpPut, key: key, value: value}
+		if ttl > 0 {
+			r.expireAt = w.unixNow() + int64(ttl/time.Second)
+		}
+		w.sealRecord(&r, &sealed)
+		data = appendWALRecord(data[:0], &r)
+		_, err := writer.Write(data)
+		return err
+	})
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// replay applies the last snapshot and the logs after it to the cache, returns the last sequence number
+func (w *WAL) replay() (uint64, error) {
+	snapshots, logs, err := w.listFiles()
+	if err != nil {
+		return 0, err
+	}
+
+	lastSeq := uint64(0)
+	if len(snapshots) > 0 {
+		lastSeq = snapshots[len(snapshots)-1]
+		if err := w.replayFile(w.snapshotPath(lastSeq)); err != nil {
+			return 0, err
+		}
+	}
+
+	for _, seq := range logs {
+		// a crash happened before removing the logs of the snapshot
+		if seq < lastSeq {
+			continue
+		}
+		if err := w.replayFile(w.logPath(seq)); err != nil {
+			return 0, err
+		}
+		lastSeq = seq
+	}
+	return lastSeq, nil
+}
+
+// replayFile applies the records of the file, stops at the first incomplete or invalid record
+// written by a crash
+func (w *WAL) replayFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = file.Close() }()
+
+	reader := bufio.NewReader(file)
+	var payload []byte
+	for {
+		payload, err = readWALRecord(reader, payload)
+		if err != nil {
+			return nil
+		}
+		w.applyRecord(payload)
+	}
+}
+
+func (w *WAL) applyRecord(payload []byte) {
+	r := decodeWALPayload(payload)
+	switch r.op {
+	case walOpPut:
+		value, err := w.openRecord(&r)
+		ttl := time.Duration(r.expireAt-w.unixNow()) * time.Second
+		if err != nil || (r.expireAt != 0 && ttl <= 0) {
+			// replaces the previous value
+			w.cache.Delete(r.key)
+			return
+		}
+		if r.expireAt == 0 {
+			w.cache.Put(r.key, value)
+			return
+		}
+		w.cache.PutWithTTL(r.key, value, ttl)
+	case walOpDelete:
+		w.cache.Delete(r.key)
+	default:
+	}
+}
+
+// openRecord returns the decrypted value of a put, ErrKeyIDNotFound if the value is encrypted and encryption
+// is disabled or its key is not in the keyring
+func (w *WAL) openRecord(r *walRecord) ([]byte, error) {
+	if r.keyID == 0 {
+		return r.value, nil
+	}
+	if w.cache.keyring == nil {
+		return nil, ErrKeyIDNotFound
+	}
+	return w.cache.keyring.decrypt(nil, r.keyID, r.value, r.key)
+}
+
+// listFiles returns the sequence numbers of the snapshots and the logs in increasing order
+func (w *WAL) listFiles() (snapshots []uint64, logs []uint64, err error) {
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if seq, ok := parseWALFileName(name, walSnapshotPrefix, walSnapshotSuffix); ok {
+			snapshots = append(snapshots, seq)
+		} else if seq, ok := parseWALFileName(name, walLogPrefix, walLogSuffix); ok {
+			logs = append(logs, seq)
+		}
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i] < snapshots[j] })
+	sort.Slice(logs, func(i, j int) bool { return logs[i] < logs[j] })
+	return snapshots, logs, nil
+}
+
+func (w *WAL) logPath(seq uint64) string {
+	return filepath.Join(w.dir, fmt.Sprintf("%s%016d%s", walLogPrefix, seq, walLogSuffix))
+}
+
+func (w *WAL) snapshotPath(seq uint64) string {
+	return filepath.Join(w.dir, fmt.Sprintf("%s%016d%s", walSnapshotPrefix, seq, walSnapshotSuffix))
+}
+
+func parseWALFileName(name string, prefix string, suffix string) (uint64, bool) {
+	if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) {
+		return 0, false
+	}
+	var seq uint64
+	_, err := fmt.Sscanf(name[len(prefix):len(name)-len(suffix)], "%d", &seq)
+	return seq, err == nil
+}
+
+// walRecord is a put or a delete of a log or a snapshot
+type walRecord struct {
+	op       byte
+	keyID    uint8 // the id of the key encrypting the value, 0 if not encrypted
+	key      []byte
+	value    []byte
+	expireAt int64 // unix seconds, 0 if the entry never expires
+}
+
+// appendWALRecord appends the record: crc32c of the payload | payload length | payload,
+// with payload: op | key id | expire at | key length | key | value
+func appendWALRecord(data []byte, r *walRecord) []byte {
+	begin := len(data)
+	payloadLen := walPayloadHeaderSize + len(r.key) + len(r.value)
+
+	var header [walRecordHeaderSize + walPayloadHeaderSize]byte
+	binary.LittleEndian.PutUint32(header[4:], uint32(payloadLen))
+	header[8] = r.op
+	header[9] = r.keyID
+	binary.LittleEndian.PutUint64(header[10:], uint64(r.expireAt))
+	binary.LittleEndian.PutUint32(header[18:], uint32(len(r.key)))
+
+	data = append(data, header[:]...)
+	data = append(data, r.key...)
+	data = append(data, r.value...)
+
+	crc := crc32.Checksum(data[begin+walRecordHeaderSize:], persistCRCTable)
+	binary.LittleEndian.PutUint32(data[begin:], crc)
+	return data
+}
+
+// decodeWALPayload splits a payload returned by readWALRecord
+func decodeWALPayload(payload []byte) walRecord {
+	keyLen := int(binary.LittleEndian.Uint32(payload[10:]))
+	return walRecord{
+		op:       payload[0],
+		keyID:    payload[1],
+		key:      payload[walPayloadHeaderSize : walPayloadHeaderSize+keyLen],
+		value:    payload[walPayloadHeaderSize+keyLen:],
+		expireAt: int64(binary.LittleEndian.Uint64(payload[2:])),
+	}
+}
+
+// readWALRecord reads the payload of the next record into buf
+func readWALRecord(r io.Reader, buf []byte) ([]byte, error) {
+	var header [walRecordHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+
+	payloadLen := int(binary.LittleEndian.Uint32(header[4:]))
+	if payloadLen < walPayloadHeaderSize || payloadLen > walMaxPayloadSize {
+		return nil, errInvalidWALRecord
+	}
+	if cap(buf) < payloadLen {
+		buf = make([]byte, payloadLen)
+	}
+	buf = buf[:payloadLen]
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+
+	if crc32.Checksum(buf, persistCRCTable) != binary.LittleEndian.Uint32(header[:]) {
+		return nil, errInvalidWALRecord
+	}
+	if keyLen := binary.LittleEndian.Uint32(buf[10:]); int(keyLen) > payloadLen-walPayloadHeaderSize {
+		return nil, errInvalidWALRecord
+	}
+	return buf, nil
+}
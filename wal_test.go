@@ -0,0 +1,298 @@
+package bigcache
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/QuangTung97/bigcache/bigcachetest"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func openTestWAL(t *testing.T, dir string) (*WAL, *Cache) {
+	c := New(4, 1<<16)
+	w, err := OpenWAL(dir, c, 0, time.Hour)
+	assert.Equal(t, nil, err)
+	return w, c
+}
+
+func walTestFiles(t *testing.T, dir string) []string {
+	entries, err := os.ReadDir(dir)
+	assert.Equal(t, nil, err)
+
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func TestWAL_Replay(t *testing.T) {
+	dir := t.TempDir()
+
+	w, c := openTestWAL(t, dir)
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, nil, w.Put([]byte(fmt.Sprintf("key:%d", i)), []byte(fmt.Sprintf("value:%d", i))))
+	}
+	assert.Equal(t, nil, w.Put([]byte("key:5"), []byte("updated")))
+	affected, err := w.Delete([]byte("key:10"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, affected)
+	assert.Equal(t, nil, w.PutWithTTL([]byte("ttl"), []byte("value"), time.Hour))
+	assert.Equal(t, uint64(100), c.GetTotal())
+	assert.Equal(t, nil, w.Close())
+
+	w, c = openTestWAL(t, dir)
+	assert.Equal(t, uint64(100), c.GetTotal())
+	assertTestEntries(t, c, 0, 5)
+	assertTestEntries(t, c, 11, 100)
+
+	data := make([]byte, 20)
+	n, ok := c.Get([]byte("key:5"), data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "updated", string(data[:n]))
+	_, ok = c.Get([]byte("key:10"), nil)
+	assert.Equal(t, false, ok)
+	_, ok = c.Get([]byte("ttl"), nil)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, nil, w.Close())
+
+	assert.Equal(t, []string{"wal-0000000000000001.log", "wal-0000000000000002.log"}, walTestFiles(t, dir))
+}
+
+func TestWAL_Replay_Expired(t *testing.T) {
+	dir := t.TempDir()
+
+	w, _ := openTestWAL(t, dir)
+	assert.Equal(t, nil, w.Put([]byte("key01"), []byte("value01")))
+	expired := walRecord{op: walOpPut, key: []byte("key01"), value: []byte("value02"), expireAt: w.unixNow() - 10}
+	assert.Equal(t, nil, w.write(expired, func() {}))
+	expired.key = []byte("key02")
+	assert.Equal(t, nil, w.write(expired, func() {}))
+	assert.Equal(t, nil, w.Close())
+
+	_, c := openTestWAL(t, dir)
+	assert.Equal(t, uint64(0), c.GetTotal())
+}
+
+func TestWAL_Replay_Torn_Record(t *testing.T) {
+	dir := t.TempDir()
+
+	w, _ := openTestWAL(t, dir)
+	assert.Equal(t, nil, w.Put([]byte("key01"), []byte("value01")))
+	assert.Equal(t, nil, w.Put([]byte("key02"), []byte("value02")))
+	assert.Equal(t, nil, w.Close())
+
+	// the last record is partially written
+	path := filepath.Join(dir, "wal-0000000000000001.log")
+	info, err := os.Stat(path)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, os.Truncate(path, info.Size()-3))
+
+	w, c := openTestWAL(t, dir)
+	assert.Equal(t, uint64(1), c.GetTotal())
+	_, ok := c.Get([]byte("key01"), nil)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, nil, w.Close())
+}
+
+func TestWAL_Snapshot(t *testing.T) {
+	dir := t.TempDir()
+
+	w, c := openTestWAL(t, dir)
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, nil, w.Put([]byte(fmt.Sprintf("key:%d", i)), []byte(fmt.Sprintf("value:%d", i))))
+	}
+	assert.Equal(t, nil, w.PutWithTTL([]byte("ttl"), []byte("value"), time.Hour))
+	c.Put([]byte("not-logged"), []byte("value"))
+
+	assert.Equal(t, nil, w.Snapshot())
+	assert.Equal(t, uint64(1), w.GetSnapshotCount())
+	assert.Equal(t, []string{"snapshot-0000000000000002.data", "wal-0000000000000002.log"}, walTestFiles(t, dir))
+
+	_, err := w.Delete([]byte("key:10"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, w.Put([]byte("key:100"), []byte("value:100")))
+	assert.Equal(t, nil, w.Close())
+
+	w, c = openTestWAL(t, dir)
+	assertTestEntries(t, c, 0, 10)
+	assertTestEntries(t, c, 11, 101)
+	_, ok := c.Get([]byte("key:10"), nil)
+	assert.Equal(t, false, ok)
+	_, ok = c.Get([]byte("not-logged"), nil)
+	assert.Equal(t, true, ok)
+
+	seg, hash := c.getSegment([]byte("ttl"))
+	assert.NotEqual(t, uint32(0), seg.getHeader(uint32(hash)).expireAt)
+
+	assert.Equal(t, nil, w.Snapshot())
+	assert.Equal(t, nil, w.Close())
+	assert.Equal(t, []string{"snapshot-0000000000000004.data", "wal-0000000000000004.log"}, walTestFiles(t, dir))
+}
+
+func walTestFileContains(t *testing.T, dir string, data []byte) bool {
+	for _, name := range walTestFiles(t, dir) {
+		content, err := os.ReadFile(filepath.Join(dir, name))
+		assert.Equal(t, nil, err)
+		if bytes.Contains(content, data) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestWAL_Encrypted(t *testing.T) {
+	dir := t.TempDir()
+	keyring := newTestKeyring(t)
+
+	c := New(4, 1<<16, WithEncryption(keyring))
+	w, err := OpenWAL(dir, c, 0, time.Hour)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, w.Put([]byte("key01"), []byte("secret01")))
+	assert.Equal(t, nil, w.Snapshot())
+	assert.Equal(t, nil, w.PutWithTTL([]byte("key02"), []byte("secret02"), time.Hour))
+	assert.Equal(t, nil, w.Close())
+
+	assert.Equal(t, false, walTestFileContains(t, dir, []byte("secret")))
+	assert.Equal(t, true, walTestFileContains(t, dir, []byte("key02")))
+
+	c = New(4, 1<<16, WithEncryption(keyring))
+	w, err = OpenWAL(dir, c, 0, time.Hour)
+	assert.Equal(t, nil, err)
+	data := make([]byte, 20)
+	n, ok := c.Get([]byte("key01"), data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "secret01", string(data[:n]))
+	n, ok = c.Get([]byte("key02"), data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "secret02", string(data[:n]))
+	assert.Equal(t, nil, w.Close())
+
+	// the values can not be decrypted without the keyring
+	w, c = openTestWAL(t, dir)
+	assert.Equal(t, uint64(0), c.GetTotal())
+	assert.Equal(t, nil, w.Close())
+}
+
+func TestWAL_Replay_Fake_Clock(t *testing.T) {
+	dir := t.TempDir()
+
+	clock := bigcachetest.NewFakeClock(0)
+	w, err := OpenWAL(dir, New(4, 1<<16, WithClock(clock)), 0, time.Hour)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, w.PutWithTTL([]byte("key01"), []byte("value01"), 10*time.Second))
+	assert.Equal(t, nil, w.PutWithTTL([]byte("key02"), []byte("value02"), time.Hour))
+
+	// key01 is expired by the clock of the cache when logging
+	clock.Advance(20 * time.Second)
+	assert.Equal(t, nil, w.Snapshot())
+	assert.Equal(t, nil, w.Close())
+
+	clock = bigcachetest.NewFakeClock(0)
+	c := New(4, 1<<16, WithClock(clock))
+	w, err = OpenWAL(dir, c, 0, time.Hour)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint64(1), c.GetTotal())
+
+	_, ok := c.Get([]byte("key02"), nil)
+	assert.Equal(t, true, ok)
+	clock.Advance(time.Hour)
+	_, ok = c.Get([]byte("key02"), nil)
+	assert.Equal(t, false, ok)
+	assert.Equal(t, nil, w.Close())
+}
+
+func TestWAL_Batched_Sync(t *testing.T) {
+	c := New(4, 1<<16)
+	w, err := OpenWAL(t.TempDir(), c, 5*time.Millisecond, time.Hour)
+	assert.Equal(t, nil, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			assert.Equal(t, nil, w.Put([]byte(fmt.Sprintf("key:%d", i)), []byte("value")))
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, uint64(50), c.GetTotal())
+	assert.Less(t, w.GetSyncCount(), uint64(10))
+	assert.Equal(t, nil, w.Close())
+}
+
+func TestWAL_Closed(t *testing.T) {
+	w, _ := openTestWAL(t, t.TempDir())
+	assert.Equal(t, nil, w.Close())
+	assert.Equal(t, nil, w.Close())
+
+	assert.Equal(t, ErrWALClosed, w.Put([]byte("key01"), []byte("value01")))
+	assert.Equal(t, ErrWALClosed, w.Snapshot())
+}
+
+func TestWAL_Start(t *testing.T) {
+	c := New(4, 1<<16)
+	w, err := OpenWAL(t.TempDir(), c, 0, 5*time.Millisecond)
+	assert.Equal(t, nil, err)
+
+	w.Start(context.Background())
+	assert.Eventually(t, func() bool {
+		return w.GetSnapshotCount() > 0
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, nil, w.Close())
+}
+
+func TestWAL_No_Periodic_Snapshot(t *testing.T) {
+	dir := t.TempDir()
+	w, err := OpenWAL(dir, New(4, 1<<16), 0, 0)
+	assert.Equal(t, nil, err)
+
+	w.Start(context.Background())
+	assert.Equal(t, nil, w.Put([]byte("key01"), []byte("value01")))
+	assert.Equal(t, nil, w.Snapshot())
+	assert.Equal(t, uint64(1), w.GetSnapshotCount())
+	assert.Equal(t, nil, w.Close())
+
+	_, err = OpenWAL(dir, New(4, 1<<16), 0, -time.Second)
+	assert.EqualError(t, err, "bigcache: wal snapshot interval must be >= 0")
+}
+
+func TestWAL_Concurrent_Writes_Same_Keys(t *testing.T) {
+	dir := t.TempDir()
+	w, c := openTestWAL(t, dir)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for k := 0; k < 200; k++ {
+				key := []byte(fmt.Sprintf("key:%d", k%10))
+				assert.Equal(t, nil, w.Put(key, []byte(fmt.Sprintf("value:%d:%d", i, k))))
+			}
+		}(i)
+	}
+	assert.Equal(t, nil, w.Snapshot())
+	wg.Wait()
+	assert.Equal(t, nil, w.Close())
+
+	// the log and the cache agree on the last write of each key
+	_, replayed := openTestWAL(t, dir)
+	for k := 0; k < 10; k++ {
+		key := []byte(fmt.Sprintf("key:%d", k))
+		expected := make([]byte, 100)
+		n, ok := c.Get(key, expected)
+		assert.Equal(t, true, ok)
+
+		data := make([]byte, 100)
+		m, ok := replayed.Get(key, data)
+		assert.Equal(t, true, ok)
+		assert.Equal(t, string(expected[:n]), string(data[:m]))
+	}
+}
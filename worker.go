@@ -0,0 +1,72 @@
+package bigcache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// worker runs a function periodically in a background goroutine, used by Janitor and Compactor
+type worker struct {
+	name     string
+	interval time.Duration
+	fn       func(ctx context.Context)
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newWorker(name string, interval time.Duration, fn func(ctx context.Context)) *worker {
+	if interval <= 0 {
+		panic(name + " interval must be > 0")
+	}
+	return &worker{
+		name:     name,
+		interval: interval,
+		fn:       fn,
+	}
+}
+
+func (w *worker) start(ctx context.Context) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.cancel != nil {
+		panic(w.name + " already started")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	w.cancel = cancel
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		w.run(ctx)
+	}()
+}
+
+func (w *worker) close() {
+	w.mu.Lock()
+	cancel := w.cancel
+	w.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
+	w.wg.Wait()
+}
+
+func (w *worker) run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.fn(ctx)
+		}
+	}
+}